@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// assertMetrics are the datapoint measures an --assert can check, with how
+// to read the threshold's units.
+var assertMetrics = map[string]struct {
+	get   func(Datapoint) float64
+	parse func(string) (float64, error)
+}{
+	"throughput": {func(dp Datapoint) float64 { return dp.ThroughputMiBs }, parseSuffixed("MiB/s")},
+	"rps":        {func(dp Datapoint) float64 { return dp.RequestsPerSec }, parseSuffixed("/s")},
+	"p50":        {func(dp Datapoint) float64 { return dp.P50Latency }, parseSeconds},
+	"p95":        {func(dp Datapoint) float64 { return dp.P95Latency }, parseSeconds},
+	"p99":        {func(dp Datapoint) float64 { return dp.P99Latency }, parseSeconds},
+	"error-rate": {func(dp Datapoint) float64 { return dp.ErrorRate }, parseFraction},
+}
+
+// assertion is a parsed --assert, like "throughput>=400MiB/s" or
+// "p99<=200ms".
+type assertion struct {
+	spec   string
+	metric string
+	op     string
+	value  float64
+}
+
+// assertionsFailed is set when an emitted datapoint breaks an --assert, so
+// the process can exit non-zero once the run is over.
+var assertionsFailed atomic.Bool
+
+func parseAssertion(spec string) (assertion, error) {
+	s := strings.ReplaceAll(spec, " ", "")
+	// Two-character operators first, so ">=" isn't read as ">".
+	for _, op := range []string{">=", "<=", ">", "<"} {
+		metric, value, ok := strings.Cut(s, op)
+		if !ok {
+			continue
+		}
+		m, ok := assertMetrics[metric]
+		if !ok {
+			return assertion{}, fmt.Errorf("unknown metric '%s' in '%s'", metric, spec)
+		}
+		v, err := m.parse(value)
+		if err != nil {
+			return assertion{}, fmt.Errorf("bad threshold in '%s': %v", spec, err)
+		}
+		return assertion{spec: spec, metric: metric, op: op, value: v}, nil
+	}
+	return assertion{}, fmt.Errorf("'%s' has no comparison; use e.g. throughput>=400MiB/s or p99<=0.2s", spec)
+}
+
+// holds reports whether dp meets the assertion.
+func (a assertion) holds(dp Datapoint) bool {
+	v := assertMetrics[a.metric].get(dp)
+	switch a.op {
+	case ">=":
+		return v >= a.value
+	case "<=":
+		return v <= a.value
+	case ">":
+		return v > a.value
+	default:
+		return v < a.value
+	}
+}
+
+// checkAssertions logs each --assert that dp breaks.
+func checkAssertions(cfg *myConfig, dp Datapoint) {
+	for _, a := range cfg.Asserts {
+		if !a.holds(dp) {
+			errorf("assertion %s failed: %s was %g", a.spec, a.metric, assertMetrics[a.metric].get(dp))
+			assertionsFailed.Store(true)
+		}
+	}
+}
+
+// parseSuffixed reads a number with an optional unit suffix.
+func parseSuffixed(unit string) func(string) (float64, error) {
+	return func(s string) (float64, error) {
+		return strconv.ParseFloat(strings.TrimSuffix(s, unit), 64)
+	}
+}
+
+// parseSeconds reads a duration like "200ms", or a plain number of seconds.
+func parseSeconds(s string) (float64, error) {
+	if v, err := strconv.ParseFloat(s, 64); err == nil {
+		return v, nil
+	}
+	d, err := time.ParseDuration(s)
+	return d.Seconds(), err
+}
+
+// parseFraction reads a fraction like "0.01", or a percentage like "1%".
+func parseFraction(s string) (float64, error) {
+	if strings.HasSuffix(s, "%") {
+		v, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		return v / 100, err
+	}
+	return strconv.ParseFloat(s, 64)
+}
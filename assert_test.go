@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseAssertion(t *testing.T) {
+	tests := []struct {
+		spec    string
+		metric  string
+		op      string
+		value   float64
+		wantErr string
+	}{
+		{spec: "throughput>=400MiB/s", metric: "throughput", op: ">=", value: 400},
+		{spec: "throughput > 400", metric: "throughput", op: ">", value: 400},
+		{spec: "rps<=1000/s", metric: "rps", op: "<=", value: 1000},
+		{spec: "p99<=200ms", metric: "p99", op: "<=", value: 0.2},
+		{spec: "p50<0.05", metric: "p50", op: "<", value: 0.05},
+		{spec: "p95<1.5s", metric: "p95", op: "<", value: 1.5},
+		{spec: "error-rate<=1%", metric: "error-rate", op: "<=", value: 0.01},
+		{spec: "error-rate<0.001", metric: "error-rate", op: "<", value: 0.001},
+		{spec: "p99=200ms", wantErr: "no comparison"},
+		{spec: "latency<=1s", wantErr: "unknown metric"},
+		{spec: "p99<=soon", wantErr: "bad threshold"},
+		{spec: "throughput>=fast", wantErr: "bad threshold"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			a, err := parseAssertion(tt.spec)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a.metric != tt.metric || a.op != tt.op || a.value != tt.value || a.spec != tt.spec {
+				t.Errorf("got %+v, want %s %s %g", a, tt.metric, tt.op, tt.value)
+			}
+		})
+	}
+}
+
+func TestAssertionHolds(t *testing.T) {
+	dp := Datapoint{ThroughputMiBs: 400, P99Latency: 0.2, ErrorRate: 0}
+	tests := []struct {
+		spec string
+		want bool
+	}{
+		{"throughput>=400", true},
+		{"throughput>400", false},
+		{"throughput<=400", true},
+		{"throughput<400", false},
+		{"p99<=200ms", true},
+		{"p99<200ms", false},
+		{"error-rate<=0", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			a, err := parseAssertion(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := a.holds(dp); got != tt.want {
+				t.Errorf("holds = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
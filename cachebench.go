@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/spf13/pflag"
+)
+
+func parseCacheFlags(args []string) *myConfig {
+	cfg := &myConfig{}
+	fs := pflag.NewFlagSet("cache", pflag.ExitOnError)
+	addS3Flags(fs, cfg)
+	fs.IntVar(&cfg.Count, "count", 1, "number of datapoint series to generate")
+	fs.StringVar(&cfg.EC2Instance, "instance", "", "EC2 instance type (default: detected from instance metadata)")
+	fs.StringVar(&cfg.FileSetName, "set", "K064", "file set to fetch from")
+	fs.IntVar(&cfg.Goroutines, "goroutines", runtime.NumCPU(), "parallel downloads")
+	fs.IntVar(&cfg.CacheHotKeys, "hot-keys", 10, "keys to fetch over and over")
+	fs.IntVar(&cfg.CacheRounds, "rounds", 1000, "times to fetch each hot key")
+	fs.IntVar(&cfg.CacheWindows, "windows", 4, "split the rounds into this many datapoints, to show latency changing over time")
+	addResultFlags(fs, cfg)
+	parseFlags(fs, args)
+
+	validateS3Flags(cfg)
+	validateResultFlags(cfg)
+
+	if _, ok := fileSets[cfg.FileSetName]; !ok {
+		fatalf("unknown file set '%s'", cfg.FileSetName)
+	}
+	if cfg.Goroutines < 1 || cfg.CacheHotKeys < 1 {
+		fatalf("goroutines and hot-keys must be at least 1")
+	}
+	if cfg.CacheWindows < 1 || cfg.CacheRounds < cfg.CacheWindows {
+		fatalf("need 1 <= windows <= rounds")
+	}
+
+	return cfg
+}
+
+// timeGets fetches keys with cfg.Goroutines workers, discarding the bodies,
+// and emits a datapoint for op.  Latency is to response headers, as for
+// downloads.
+func timeGets(cfg *myConfig, s3Client *s3.Client, op string, window int, keys []string) Datapoint {
+	work := make(chan string, cfg.Goroutines)
+	go func() {
+		for _, k := range keys {
+			work <- k
+		}
+		close(work)
+	}()
+
+	td := newLatencyDigest()
+	var tdMu sync.Mutex
+	var bytesRead int64
+
+	tracker := newConnTracker()
+	ctx := withConnTracker(context.Background(), tracker)
+	startTime := time.Now()
+
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range work {
+				start := time.Now()
+				resp, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+					Bucket: aws.String(cfg.Bucket),
+					Key:    aws.String(key),
+				})
+				expRequests.Add(1)
+				if err != nil {
+					expErrors.Add(1)
+					fatalf("error downloading %s: %v", key, err)
+				}
+				secs := time.Since(start).Seconds()
+				n, err := io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				if err != nil {
+					expErrors.Add(1)
+					fatalf("error downloading %s: %v", key, err)
+				}
+				expBytesRead.Add(n)
+
+				tdMu.Lock()
+				td.Add(secs)
+				tdMu.Unlock()
+				atomic.AddInt64(&bytesRead, n)
+			}
+		}()
+	}
+	wg.Wait()
+	elapsedSec := time.Since(startTime).Seconds()
+
+	datapoint := baseDatapoint(cfg)
+	datapoint.Operation = op
+	datapoint.TotalSizeBytes = int(bytesRead)
+	datapoint.CacheWindow = window
+
+	datapoint.StartTime = startTime
+	datapoint.ElapsedSecs = elapsedSec
+	datapoint.setLatencies(td, cfg.Quantiles)
+	datapoint.ThroughputMiBs = float64(bytesRead) / MiB / elapsedSec
+	datapoint.RequestsPerSec = float64(len(keys)) / elapsedSec
+	datapoint.setConnStats(tracker, elapsedSec)
+
+	emitDatapoint(cfg, datapoint)
+	return datapoint
+}
+
+// warmConns makes one untimed GET per goroutine, all at once, so the client
+// has a connection for each before anything is measured.  The key is in
+// neither the hot nor the distinct list, and needn't exist: a 404 sets up
+// the connection as well as an object would.
+func warmConns(cfg *myConfig, s3Client *s3.Client) {
+	key := cfg.FileSetName + "/cache-warmup"
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			resp, err := s3Client.GetObject(context.Background(), &s3.GetObjectInput{
+				Bucket: aws.String(cfg.Bucket),
+				Key:    aws.String(key),
+			})
+			if err != nil {
+				return
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}()
+	}
+	close(start)
+	wg.Wait()
+}
+
+// runCache looks for caching effects: it fetches a few hot keys over and
+// over, in windows, and compares their latency with fetching as many
+// distinct keys once each.  Both go through the same client, whose
+// connections are set up before either is timed, so a gap between them
+// comes from the S3 side rather than from connection setup.
+func runCache(args []string) int {
+	cfg := parseCacheFlags(args)
+	prepareRun(cfg)
+
+	for i := 0; i < cfg.Count; i++ {
+		s3Client, err := configS3(cfg)
+		if err != nil {
+			fatalf("error configuring S3: %v", err)
+		}
+		files, err := listSetFiles(cfg, s3Client, cfg.FileSetName)
+		if err != nil {
+			fatalf("error listing file set: %v", err)
+		}
+		if len(files) <= cfg.CacheHotKeys {
+			fatalf("file set %s has %d objects; need more than hot-keys (%d) to compare with distinct keys", cfg.FileSetName, len(files), cfg.CacheHotKeys)
+		}
+		hot := files[:cfg.CacheHotKeys]
+
+		// The baseline: as many requests as a window, but each to a key
+		// not fetched before, as far as the set allows.
+		perWindow := cfg.CacheHotKeys * (cfg.CacheRounds / cfg.CacheWindows)
+		unique := files[cfg.CacheHotKeys:]
+		if len(unique) > perWindow {
+			unique = unique[:perWindow]
+		}
+		if len(unique) < perWindow {
+			warnf("only %d distinct keys for the baseline; windows make %d requests", len(unique), perWindow)
+		}
+		debugf("warming %d connections", cfg.Goroutines)
+		warmConns(cfg, s3Client)
+
+		debugf("fetching %d distinct keys", len(unique))
+		baseline := timeGets(cfg, s3Client, "get-unique", 0, unique)
+
+		var first, last Datapoint
+		for w := 0; w < cfg.CacheWindows; w++ {
+			rounds := cfg.CacheRounds / cfg.CacheWindows
+			if w == cfg.CacheWindows-1 {
+				rounds = cfg.CacheRounds - rounds*(cfg.CacheWindows-1)
+			}
+			keys := make([]string, 0, rounds*len(hot))
+			for r := 0; r < rounds; r++ {
+				keys = append(keys, hot...)
+			}
+			debugf("window %d: fetching %d hot keys %d times each", w+1, len(hot), rounds)
+			dp := timeGets(cfg, s3Client, "get-repeat", w+1, keys)
+			if w == 0 {
+				first = dp
+			}
+			last = dp
+		}
+
+		infof("p50 latency: %.1fms distinct keys; %.1fms hot keys in the first window, %.1fms in the last",
+			baseline.P50Latency*1000, first.P50Latency*1000, last.P50Latency*1000)
+	}
+
+	return 0
+}
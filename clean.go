@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/spf13/pflag"
+)
+
+// maxDeleteBatch is the most keys DeleteObjects accepts at once.
+const maxDeleteBatch = 1000
+
+// deletePrefix removes every object under a prefix, returning how many were
+// deleted.  Keys S3 fails to delete don't stop it; they're named in the
+// error once the rest are gone.
+func deletePrefix(s3Client *s3.Client, bucket, prefix string) (int, error) {
+	p := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: maxDeleteBatch,
+	})
+
+	var deleted int
+	var failed []string
+	for p.HasMorePages() {
+		page, err := p.NextPage(context.Background())
+		if err != nil {
+			return deleted, err
+		}
+		if len(page.Contents) == 0 {
+			continue
+		}
+
+		ids := make([]types.ObjectIdentifier, 0, len(page.Contents))
+		for _, obj := range page.Contents {
+			ids = append(ids, types.ObjectIdentifier{Key: obj.Key})
+		}
+
+		out, err := s3Client.DeleteObjects(context.Background(), &s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &types.Delete{Objects: ids, Quiet: true},
+		})
+		if err != nil {
+			return deleted, err
+		}
+		deleted += len(ids) - len(out.Errors)
+		for _, e := range out.Errors {
+			failed = append(failed, fmt.Sprintf("%s (%s)", aws.ToString(e.Key), aws.ToString(e.Code)))
+		}
+	}
+
+	if len(failed) > 0 {
+		const shown = 10
+		names := strings.Join(failed[:min(len(failed), shown)], ", ")
+		if len(failed) > shown {
+			names += fmt.Sprintf(" and %d more", len(failed)-shown)
+		}
+		return deleted, fmt.Errorf("%d keys not deleted: %s", len(failed), names)
+	}
+	return deleted, nil
+}
+
+// runClean deletes a file set from the bucket.
+func runClean(args []string) int {
+	cfg := &myConfig{}
+	fs := pflag.NewFlagSet("clean", pflag.ExitOnError)
+	addS3Flags(fs, cfg)
+	fs.StringVar(&cfg.FileSetName, "set", "", "file set to delete (required)")
+	parseFlags(fs, args)
+
+	validateS3Flags(cfg)
+
+	if _, ok := fileSets[cfg.FileSetName]; !ok {
+		fatalf("unknown file set '%s'", cfg.FileSetName)
+	}
+
+	s3Client, err := configS3(cfg)
+	if err != nil {
+		fatalf("error configuring S3: %v", err)
+	}
+
+	// Trailing slash so cleaning M001 doesn't touch a sibling like M0010.
+	prefix := path.Join(cfg.Prefix, cfg.FileSetName) + "/"
+	n, err := deletePrefix(s3Client, cfg.Bucket, prefix)
+	if err != nil {
+		fatalf("error deleting s3://%s/%s: %v", cfg.Bucket, prefix, err)
+	}
+	infof("deleted %d objects from s3://%s/%s", n, cfg.Bucket, prefix)
+
+	return 0
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// cloudWatch is the client for --cloudwatch-namespace, made on first use.
+// It has its own connections, so publishing doesn't count in a result's
+// connection stats.
+var cloudWatch *cloudwatch.Client
+
+// publishMetrics sends a datapoint's headline numbers to CloudWatch as
+// custom metrics, timestamped with its start and with dimensions to pick
+// out a workload for dashboards and alarms.  They go to the instance's
+// region if it's known, else to --region.
+func publishMetrics(cfg *myConfig, dp Datapoint) error {
+	if cfg.MetricsNamespace == "" {
+		return nil
+	}
+	if cloudWatch == nil {
+		region := cfg.InstanceRegion
+		if region == "" || region == "unknown" {
+			region = cfg.Region
+		}
+		awscfg, err := loadAWSConfig(cfg, region, nil)
+		if err != nil {
+			return err
+		}
+		cloudWatch = cloudwatch.NewFromConfig(awscfg)
+	}
+
+	// CloudWatch needs a value for every dimension.
+	dimension := func(name, value string) types.Dimension {
+		if value == "" {
+			value = "unknown"
+		}
+		return types.Dimension{Name: aws.String(name), Value: aws.String(value)}
+	}
+	dims := []types.Dimension{
+		dimension("InstanceType", dp.EC2Instance),
+		dimension("FileSet", dp.FileSizeLabel),
+		dimension("Operation", dp.Operation),
+	}
+	metric := func(name string, v float64, unit types.StandardUnit) types.MetricDatum {
+		return types.MetricDatum{
+			MetricName: aws.String(name),
+			Dimensions: dims,
+			Timestamp:  aws.Time(dp.StartTime),
+			Value:      aws.Float64(v),
+			Unit:       unit,
+		}
+	}
+	data := []types.MetricDatum{
+		metric("ThroughputMiBs", dp.ThroughputMiBs, types.StandardUnitMegabytesSecond),
+		metric("RequestsPerSec", dp.RequestsPerSec, types.StandardUnitCountSecond),
+		metric("P50Latency", dp.P50Latency, types.StandardUnitSeconds),
+		metric("P95Latency", dp.P95Latency, types.StandardUnitSeconds),
+		metric("P99Latency", dp.P99Latency, types.StandardUnitSeconds),
+		metric("ErrorRate", dp.ErrorRate*100, types.StandardUnitPercent),
+	}
+
+	_, err := cloudWatch.PutMetricData(context.TODO(), &cloudwatch.PutMetricDataInput{
+		Namespace:  aws.String(cfg.MetricsNamespace),
+		MetricData: data,
+	})
+	return err
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/spf13/pflag"
+)
+
+// readDatapoints reads a JSON results file, one datapoint per line.  Summary
+// records of --count runs are skipped, since they repeat their iterations.
+func readDatapoints(name string) ([]Datapoint, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var dps []Datapoint
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		dp, err := decodeDatapoint(sc.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if dp.Iterations == 0 {
+			dps = append(dps, dp)
+		}
+	}
+	return dps, sc.Err()
+}
+
+// mannWhitney returns the two-sided p-value of a Mann-Whitney U test that
+// a and b come from the same distribution, using the normal approximation
+// with a correction for ties.  It assumes nothing about the shape of the
+// distributions, which for throughput and tail latency are rarely normal.
+func mannWhitney(a, b []float64) float64 {
+	type obs struct {
+		v     float64
+		fromA bool
+	}
+	all := make([]obs, 0, len(a)+len(b))
+	for _, v := range a {
+		all = append(all, obs{v, true})
+	}
+	for _, v := range b {
+		all = append(all, obs{v, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].v < all[j].v })
+
+	// Rank, giving ties the mean of their ranks.
+	var rankSumA, tieTerm float64
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].fromA {
+				rankSumA += rank
+			}
+		}
+		t := float64(j - i)
+		tieTerm += t*t*t - t
+		i = j
+	}
+
+	n1, n2 := float64(len(a)), float64(len(b))
+	n := n1 + n2
+	u := rankSumA - n1*(n1+1)/2
+	mean := n1 * n2 / 2
+	variance := n1 * n2 / 12 * ((n + 1) - tieTerm/(n*(n-1)))
+	if variance <= 0 {
+		return 1
+	}
+	// Continuity correction towards the mean.
+	z := (math.Abs(u-mean) - 0.5) / math.Sqrt(variance)
+	if z < 0 {
+		z = 0
+	}
+	return math.Erfc(z / math.Sqrt2)
+}
+
+func mean(vs []float64) float64 {
+	var sum float64
+	for _, v := range vs {
+		sum += v
+	}
+	return sum / float64(len(vs))
+}
+
+// runCompare tests whether a candidate results file differs from a
+// baseline in throughput or latency by more than run-to-run noise.  Each
+// file needs several datapoints, e.g. from --count, for a test to mean
+// anything.
+func runCompare(args []string) int {
+	fs := pflag.NewFlagSet("compare", pflag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: s3skunk compare [flags] <baseline.jsonl> <candidate.jsonl>\n")
+		fs.PrintDefaults()
+	}
+	alpha := fs.Float64("alpha", 0.05, "significance level: p-values below this count as a real difference")
+	parseFlags(fs, args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+	if *alpha <= 0 || *alpha >= 1 {
+		fatalf("alpha must be between 0 and 1")
+	}
+
+	baseline, err := readDatapoints(fs.Arg(0))
+	if err != nil {
+		fatalf("error reading %s: %v", fs.Arg(0), err)
+	}
+	candidate, err := readDatapoints(fs.Arg(1))
+	if err != nil {
+		fatalf("error reading %s: %v", fs.Arg(1), err)
+	}
+	if len(baseline) == 0 || len(candidate) == 0 {
+		fatalf("need at least one datapoint in each file")
+	}
+	// Below 4 a side, no difference reaches p < 0.05.
+	if len(baseline) < 4 || len(candidate) < 4 {
+		warnf("only %d baseline and %d candidate datapoints; use --count for enough to show a difference", len(baseline), len(candidate))
+	}
+
+	metrics := []struct {
+		name string
+		get  func(Datapoint) float64
+	}{
+		{"throughput MiB/s", func(dp Datapoint) float64 { return dp.ThroughputMiBs }},
+		{"p50 latency ms", func(dp Datapoint) float64 { return dp.P50Latency * 1000 }},
+		{"p95 latency ms", func(dp Datapoint) float64 { return dp.P95Latency * 1000 }},
+		{"p99 latency ms", func(dp Datapoint) float64 { return dp.P99Latency * 1000 }},
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "\tbaseline (n=%d)\tcandidate (n=%d)\tchange\tp-value\t\n", len(baseline), len(candidate))
+	for _, m := range metrics {
+		a := make([]float64, len(baseline))
+		for i, dp := range baseline {
+			a[i] = m.get(dp)
+		}
+		b := make([]float64, len(candidate))
+		for i, dp := range candidate {
+			b[i] = m.get(dp)
+		}
+		ma, mb := mean(a), mean(b)
+		change := "-"
+		if ma != 0 {
+			change = fmt.Sprintf("%+.1f%%", (mb-ma)/ma*100)
+		}
+		p := mannWhitney(a, b)
+		verdict := "no significant difference"
+		if p < *alpha {
+			verdict = "significant"
+		}
+		fmt.Fprintf(tw, "%s\t%.2f\t%.2f\t%s\t%.3f\t%s\n", m.name, ma, mb, change, p, verdict)
+	}
+	tw.Flush()
+
+	return 0
+}
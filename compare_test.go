@@ -0,0 +1,31 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMannWhitney(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []float64
+		want float64
+	}{
+		{"separated", []float64{1, 2, 3, 4, 5}, []float64{6, 7, 8, 9, 10}, 0.012185780355344818},
+		{"separated, larger", []float64{10, 11, 12, 13, 14, 15, 16, 17}, []float64{1, 2, 3, 4, 5, 6, 7, 8}, 0.0009391056991171905},
+		{"overlapping with ties", []float64{1, 2, 2, 3}, []float64{2, 3, 4, 5}, 0.13665824773814753},
+		{"same values", []float64{1, 2, 3}, []float64{1, 2, 3}, 1},
+		{"all tied", []float64{5, 5, 5}, []float64{5, 5}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mannWhitney(tt.a, tt.b); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("mannWhitney(a, b) = %g, want %g", got, tt.want)
+			}
+			if got := mannWhitney(tt.b, tt.a); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("mannWhitney(b, a) = %g, want %g", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is prepended to a flag's name to find its environment variable,
+// e.g. --max-memory can be set with S3BENCH_MAX_MEMORY.
+const EnvPrefix = "S3BENCH_"
+
+// readOnlyCommands read results rather than benchmark, so they don't start
+// the diagnostics server unless asked to: serve runs alongside benchmarks on
+// the same host, and would hold the port they want.
+var readOnlyCommands = map[string]bool{
+	"serve":   true,
+	"report":  true,
+	"compare": true,
+	"plot":    true,
+}
+
+// parseFlags adds the flags common to every command and parses args into
+// fs, then fills in any flags not given on the command line.  Precedence is:
+// command line, then S3BENCH_* environment variables, then the --config
+// file, then the flag's default.  Once flags are settled, it starts the
+// diagnostics server unless that's been disabled, as it is by default for
+// readOnlyCommands, and with --notify-url, --statsd-addr and
+// --otlp-endpoint, notification, statsd emission and tracing.
+func parseFlags(fs *pflag.FlagSet, args []string) {
+	configFile := fs.String("config", "", "YAML file of flag values")
+	pprofAddr := fs.String("pprof-addr", "localhost:6060", "address for the diagnostics server: a live web dashboard, pprof, expvar and Prometheus /metrics")
+	pprofDisable := fs.Bool("pprof-disable", readOnlyCommands[fs.Name()], "don't start the diagnostics server")
+	statsdAddr := fs.String("statsd-addr", "", "host:port of a statsd or DogStatsD agent to stream request timings and counters to over UDP")
+	statsdTags := fs.StringSlice("statsd-tag", nil, "DogStatsD key:value tag for every metric sent to --statsd-addr (repeatable)")
+	otlpEndpoint := fs.String("otlp-endpoint", "", "http(s):// URL of an OpenTelemetry collector to export a span per request, and per SDK attempt, to over OTLP/HTTP")
+	traceSample := fs.Float64("trace-sample", 1, "fraction of requests to trace with --otlp-endpoint")
+	logLevel := fs.String("log-level", "info", "least severe messages to log to stderr (debug, info, warn or error); debug logs every S3 request attempt with its key and request IDs")
+	logFormat := fs.String("log-format", "text", "format of messages logged to stderr (text or json)")
+	notifyURL := fs.String("notify-url", "", "webhook URL, e.g. a Slack incoming webhook, to POST a JSON summary of results and any errors to when the command finishes or fails")
+	fs.Parse(args)
+
+	if err := applyEnv(fs); err != nil {
+		fatalf("%v", err)
+	}
+
+	if *configFile != "" {
+		if err := applyConfigFile(fs, *configFile); err != nil {
+			fatalf("error reading config file %s: %v", *configFile, err)
+		}
+	}
+
+	if err := setLogLevel(*logLevel); err != nil {
+		fatalf("%v", err)
+	}
+	if err := setLogFormat(*logFormat); err != nil {
+		fatalf("%v", err)
+	}
+
+	if *notifyURL != "" {
+		startNotify(*notifyURL)
+	}
+
+	if !*pprofDisable {
+		var storeName string
+		if f := fs.Lookup("store"); f != nil {
+			storeName = f.Value.String()
+		}
+		startDiagServer(*pprofAddr, storeName)
+	}
+
+	if *statsdAddr != "" {
+		if err := startStatsd(*statsdAddr, *statsdTags); err != nil {
+			fatalf("error connecting to statsd at %s: %v", *statsdAddr, err)
+		}
+	}
+
+	if *otlpEndpoint != "" {
+		if *traceSample <= 0 || *traceSample > 1 {
+			fatalf("trace-sample must be greater than 0 and at most 1")
+		}
+		if err := startTracing(*otlpEndpoint, *traceSample); err != nil {
+			fatalf("error setting up tracing: %v", err)
+		}
+	}
+}
+
+// envName returns the environment variable that sets a flag.
+func envName(flag string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+// applyEnv sets flags not given on the command line from the environment.
+func applyEnv(fs *pflag.FlagSet) error {
+	var err error
+	fs.VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed {
+			return
+		}
+		v, ok := os.LookupEnv(envName(f.Name))
+		if !ok {
+			return
+		}
+		if serr := fs.Set(f.Name, v); serr != nil {
+			err = fmt.Errorf("error setting %s from %s: %w", f.Name, envName(f.Name), serr)
+		}
+	})
+	return err
+}
+
+// applyConfigFile sets flags from a YAML file whose keys are flag names, e.g.
+//
+//	bucket: my-bucket
+//	set: M016
+//	goroutines: 64
+//
+// Flags already set on the command line or environment are left alone.
+// List values set a flag once per element and maps set it once per
+// "key=value" pair, for repeatable flags.
+func applyConfigFile(fs *pflag.FlagSet, filename string) error {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(b, &values); err != nil {
+		return err
+	}
+
+	for name, v := range values {
+		if name == "config" {
+			return fmt.Errorf("config files can't include other config files")
+		}
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("unknown flag '%s'", name)
+		}
+		if fs.Changed(name) {
+			continue
+		}
+		if err := setFlagFromConfig(fs, name, v); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func setFlagFromConfig(fs *pflag.FlagSet, name string, v interface{}) error {
+	switch v := v.(type) {
+	case []interface{}:
+		for _, elem := range v {
+			if err := fs.Set(name, configString(elem)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		for k, elem := range v {
+			if err := fs.Set(name, k+"="+configString(elem)); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fs.Set(name, configString(v))
+	}
+}
+
+// configString formats a YAML scalar as it'd be given on the command line.
+// YAML reads 1e6 as a float, which fmt would print as 1e+06, too much for
+// an integer flag to parse.
+func configString(v interface{}) string {
+	if f, ok := v.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+// TestFlagPrecedence settles flags as parseFlags does, from the command
+// line, then the environment, then a config file, then defaults.
+func TestFlagPrecedence(t *testing.T) {
+	type flags struct {
+		Set      string
+		Download uint
+		Rate     float64
+		Label    map[string]string
+		SLO      []string
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		config  string
+		want    flags
+		wantErr string
+	}{
+		{name: "defaults", want: flags{Set: "K064", Download: 256}},
+		{
+			name:   "config over default",
+			config: "set: M016\ndownload: 1024\n",
+			want:   flags{Set: "M016", Download: 1024},
+		},
+		{
+			name:   "env over config",
+			env:    map[string]string{"S3BENCH_SET": "G001"},
+			config: "set: M016\ndownload: 1024\n",
+			want:   flags{Set: "G001", Download: 1024},
+		},
+		{
+			name:   "flag over env and config",
+			args:   []string{"--set", "K001"},
+			env:    map[string]string{"S3BENCH_SET": "G001"},
+			config: "set: M016\n",
+			want:   flags{Set: "K001", Download: 256},
+		},
+		{
+			name:   "exponent to uint and float",
+			config: "download: 1e6\nrate: 2.5e3\n",
+			want:   flags{Set: "K064", Download: 1000000, Rate: 2500},
+		},
+		{
+			name:   "list and map for repeatable flags",
+			config: "label:\n  team: s3\n  run: nightly\nslo:\n  - p99<100ms\n  - errors<1%\n",
+			want: flags{
+				Set: "K064", Download: 256,
+				Label: map[string]string{"team": "s3", "run": "nightly"},
+				SLO:   []string{"p99<100ms", "errors<1%"},
+			},
+		},
+		{
+			name:   "flag replaces config list",
+			args:   []string{"--slo", "p50<10ms"},
+			config: "slo:\n  - p99<100ms\n",
+			want:   flags{Set: "K064", Download: 256, SLO: []string{"p50<10ms"}},
+		},
+		{name: "unknown flag", config: "sett: M016\n", wantErr: "unknown flag 'sett'"},
+		{name: "nested config", config: "config: other.yaml\n", wantErr: "can't include other config files"},
+		{name: "bad value", config: "download: lots\n", wantErr: "download:"},
+		{name: "bad env", env: map[string]string{"S3BENCH_DOWNLOAD": "-1"}, wantErr: "S3BENCH_DOWNLOAD"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			var got flags
+			fs.StringVar(&got.Set, "set", "K064", "")
+			fs.UintVar(&got.Download, "download", 256, "")
+			fs.Float64Var(&got.Rate, "rate", 0, "")
+			fs.StringToStringVar(&got.Label, "label", nil, "")
+			fs.StringArrayVar(&got.SLO, "slo", nil, "")
+			fs.String("config", "", "")
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			err := applyEnv(fs)
+			if err == nil && tt.config != "" {
+				filename := filepath.Join(t.TempDir(), "bench.yaml")
+				if err := os.WriteFile(filename, []byte(tt.config), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				err = applyConfigFile(fs, filename)
+			}
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
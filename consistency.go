@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"path"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/spf13/pflag"
+)
+
+func parseConsistencyFlags(args []string) *myConfig {
+	cfg := &myConfig{}
+	fs := pflag.NewFlagSet("consistency", pflag.ExitOnError)
+	addS3Flags(fs, cfg)
+	fs.IntVar(&cfg.Count, "count", 1, "number of datapoints to generate")
+	fs.StringVar(&cfg.EC2Instance, "instance", "", "EC2 instance type (default: detected from instance metadata)")
+	fs.IntVar(&cfg.Probes, "probes", 100, "write-then-read probes per datapoint")
+	fs.IntVar(&cfg.Goroutines, "readers", 1, "parallel readers checking each write")
+	fs.BoolVar(&cfg.ProbeNewKeys, "new-keys", false, "write a new key for each probe instead of overwriting one key")
+	fs.DurationVar(&cfg.RequestTimeout, "probe-timeout", 10*time.Second, "give up on a probe if a reader hasn't seen the write by then")
+	fs.StringVar(&cfg.ScratchPrefix, "scratch-prefix", "s3skunk-scratch", "prefix to write probes under")
+	addResultFlags(fs, cfg)
+	parseFlags(fs, args)
+
+	validateS3Flags(cfg)
+	validateResultFlags(cfg)
+
+	if cfg.Probes < 1 || cfg.Goroutines < 1 {
+		fatalf("probes and readers must be at least 1")
+	}
+	if path.Clean(cfg.ScratchPrefix) == path.Clean(cfg.Prefix) {
+		fatalf("scratch-prefix must differ from prefix")
+	}
+
+	return cfg
+}
+
+// consistencyPollInterval is how long a reader waits between GETs of a
+// probe it hasn't seen yet, so polling doesn't draw SlowDowns that would
+// stretch the convergence times measured.  It bounds their resolution.
+const consistencyPollInterval = 10 * time.Millisecond
+
+// probeStats accumulates what the readers saw across probes.
+type probeStats struct {
+	sync.Mutex
+	td       *latencyDigest
+	reads    int64 // atomic
+	stale    int64 // atomic
+	timeouts int64 // atomic; probes some reader gave up on, one per write
+}
+
+// readUntilSeen GETs key every consistencyPollInterval until it returns
+// want, counting anything else as a stale read, and returns the time from
+// since until it first read want.
+func readUntilSeen(ctx context.Context, cfg *myConfig, s3Client *s3.Client, key string, want []byte, since time.Time, ps *probeStats) (time.Duration, error) {
+	for {
+		resp, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(cfg.Bucket),
+			Key:    aws.String(key),
+		})
+		atomic.AddInt64(&ps.reads, 1)
+		expRequests.Add(1)
+
+		var nsk *types.NoSuchKey
+		switch {
+		case errors.As(err, &nsk):
+		case err != nil:
+			return 0, err
+		default:
+			got, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				return 0, err
+			}
+			if bytes.Equal(got, want) {
+				return time.Since(since), nil
+			}
+		}
+		atomic.AddInt64(&ps.stale, 1)
+
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(consistencyPollInterval):
+		}
+	}
+}
+
+// probe writes a fresh value to key and has every reader poll until it
+// sees it.
+func probe(parent context.Context, cfg *myConfig, s3Client *s3.Client, key string, n int, ps *probeStats) {
+	want := []byte(strconv.FormatInt(time.Now().UnixNano(), 10) + "-" + strconv.Itoa(n))
+	_, err := s3Client.PutObject(parent, &s3.PutObjectInput{
+		Bucket:        aws.String(cfg.Bucket),
+		Key:           aws.String(key),
+		Body:          bytes.NewReader(want),
+		ContentLength: int64(len(want)),
+	})
+	expRequests.Add(1)
+	if err != nil {
+		expErrors.Add(1)
+		fatalf("error writing probe %s: %v", key, err)
+	}
+	written := time.Now()
+
+	ctx, cancel := context.WithTimeout(parent, cfg.RequestTimeout)
+	defer cancel()
+
+	var timedOut int32
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d, err := readUntilSeen(ctx, cfg, s3Client, key, want, written, ps)
+			if err != nil {
+				if ctx.Err() != nil {
+					if atomic.CompareAndSwapInt32(&timedOut, 0, 1) {
+						atomic.AddInt64(&ps.timeouts, 1)
+					}
+					return
+				}
+				expErrors.Add(1)
+				fatalf("error reading probe %s: %v", key, err)
+			}
+			ps.Lock()
+			ps.td.Add(d.Seconds())
+			ps.Unlock()
+		}()
+	}
+	wg.Wait()
+}
+
+// runConsistency probes read-after-write behaviour: it writes an object and
+// measures how long readers take to see the new content, counting any stale
+// reads along the way.
+func runConsistency(args []string) int {
+	cfg := parseConsistencyFlags(args)
+	prepareRun(cfg)
+
+	for i := 0; i < cfg.Count; i++ {
+		s3Client, err := configS3(cfg)
+		if err != nil {
+			fatalf("error configuring S3: %v", err)
+		}
+		runPrefix := path.Join(cfg.ScratchPrefix, "consistency", strconv.FormatInt(time.Now().UnixNano(), 10))
+
+		ps := &probeStats{td: newLatencyDigest()}
+		tracker := newConnTracker()
+		ctx := withConnTracker(context.Background(), tracker)
+		startTime := time.Now()
+
+		for n := 0; n < cfg.Probes; n++ {
+			key := path.Join(runPrefix, "probe")
+			if cfg.ProbeNewKeys {
+				key = path.Join(runPrefix, strconv.Itoa(n))
+			}
+			probe(ctx, cfg, s3Client, key, n, ps)
+		}
+		elapsedSec := time.Since(startTime).Seconds()
+
+		datapoint := baseDatapoint(cfg)
+		datapoint.Operation = "consistency"
+
+		datapoint.StartTime = startTime
+		datapoint.ElapsedSecs = elapsedSec
+		datapoint.setLatencies(ps.td, cfg.Quantiles)
+		datapoint.RequestsPerSec = float64(ps.reads) / elapsedSec
+		datapoint.StaleReads = int(ps.stale)
+		datapoint.Timeouts = int(ps.timeouts)
+		datapoint.setConnStats(tracker, elapsedSec)
+
+		emitDatapoint(cfg, datapoint)
+
+		if _, err := deletePrefix(s3Client, cfg.Bucket, runPrefix+"/"); err != nil {
+			fatalf("error cleaning up s3://%s/%s: %v", cfg.Bucket, runPrefix, err)
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/spf13/pflag"
+)
+
+func parseCopyFlags(args []string) *myConfig {
+	cfg := &myConfig{}
+	fs := pflag.NewFlagSet("copy", pflag.ExitOnError)
+	addS3Flags(fs, cfg)
+	fs.IntVar(&cfg.Count, "count", 1, "number of datapoint pairs to generate per set")
+	fs.StringVar(&cfg.EC2Instance, "instance", "", "EC2 instance type (default: detected from instance metadata)")
+	fs.StringSliceVar(&cfg.CopySets, "sets", []string{"K064", "M001", "M016", "M064", "M256"}, "file sets whose objects to copy")
+	fs.IntVar(&cfg.CopyObjects, "objects", 10, "objects to copy, one at a time, per datapoint")
+	partSize := fs.Uint("part-size", 64, "copy objects larger than this many MiB with UploadPartCopy in parts of this size")
+	fs.StringVar(&cfg.ScratchPrefix, "scratch-prefix", "s3skunk-scratch", "prefix to copy to; each iteration uses a fresh sub-prefix")
+	addResultFlags(fs, cfg)
+	parseFlags(fs, args)
+
+	validateS3Flags(cfg)
+	validateResultFlags(cfg)
+
+	for _, name := range cfg.CopySets {
+		if _, ok := fileSets[name]; !ok {
+			fatalf("unknown file set '%s'", name)
+		}
+	}
+	if cfg.CopyObjects < 1 {
+		fatalf("objects must be at least 1")
+	}
+	cfg.CopyPartSizeBytes = int(*partSize) * MiB
+	if cfg.CopyPartSizeBytes < MinPartSize {
+		fatalf("part-size must be at least %d MiB", MinPartSize/MiB)
+	}
+	if path.Clean(cfg.ScratchPrefix) == path.Clean(cfg.Prefix) {
+		fatalf("scratch-prefix must differ from prefix")
+	}
+
+	return cfg
+}
+
+// copyPartConcurrency bounds the UploadPartCopy parts of one object in
+// flight at once, so small --part-size doesn't send them all together and
+// get throttled.
+const copyPartConcurrency = 8
+
+// serverCopy copies src to dst within the bucket without the data passing
+// through the client, in concurrent UploadPartCopy parts if it's large.
+// At most copyPartConcurrency parts are copied at once.
+func serverCopy(ctx context.Context, cfg *myConfig, s3Client *s3.Client, src, dst string, size int) error {
+	source := aws.String(cfg.Bucket + "/" + src)
+	if size <= cfg.CopyPartSizeBytes {
+		_, err := s3Client.CopyObject(ctx, &s3.CopyObjectInput{
+			Bucket:     aws.String(cfg.Bucket),
+			Key:        aws.String(dst),
+			CopySource: source,
+		})
+		return err
+	}
+
+	mpu, err := s3Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(cfg.Bucket),
+		Key:    aws.String(dst),
+	})
+	if err != nil {
+		return err
+	}
+
+	numParts := (size + cfg.CopyPartSizeBytes - 1) / cfg.CopyPartSizeBytes
+	parts := make([]types.CompletedPart, numParts)
+	work := make(chan int, copyPartConcurrency)
+	go func() {
+		for i := 0; i < numParts; i++ {
+			work <- i
+		}
+		close(work)
+	}()
+
+	errs := make(chan error, numParts)
+	var wg sync.WaitGroup
+	for w := 0; w < min(copyPartConcurrency, numParts); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				first := i * cfg.CopyPartSizeBytes
+				last := first + cfg.CopyPartSizeBytes - 1
+				if last >= size {
+					last = size - 1
+				}
+				resp, err := s3Client.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+					Bucket:          aws.String(cfg.Bucket),
+					Key:             aws.String(dst),
+					UploadId:        mpu.UploadId,
+					PartNumber:      int32(i + 1),
+					CopySource:      source,
+					CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", first, last)),
+				})
+				if err != nil {
+					errs <- err
+					continue
+				}
+				parts[i] = types.CompletedPart{ETag: resp.CopyPartResult.ETag, PartNumber: int32(i + 1)}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	if err := <-errs; err != nil {
+		_, abortErr := s3Client.AbortMultipartUpload(context.Background(), &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(cfg.Bucket),
+			Key:      aws.String(dst),
+			UploadId: mpu.UploadId,
+		})
+		if abortErr != nil {
+			warnf("error aborting multipart copy to %s: %v", dst, abortErr)
+		}
+		return err
+	}
+
+	_, err = s3Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(cfg.Bucket),
+		Key:             aws.String(dst),
+		UploadId:        mpu.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	return err
+}
+
+// clientCopy copies src to dst by downloading it into buf and uploading it
+// again, the way a client without server-side copy would.
+func clientCopy(ctx context.Context, cfg *myConfig, s3Client *s3.Client, src, dst string, buf *bytes.Buffer) error {
+	resp, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(cfg.Bucket),
+		Key:    aws.String(src),
+	})
+	if err != nil {
+		return err
+	}
+	buf.Reset()
+	n, err := io.Copy(buf, resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	expBytesRead.Add(n)
+
+	_, err = s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(cfg.Bucket),
+		Key:           aws.String(dst),
+		Body:          bytes.NewReader(buf.Bytes()),
+		ContentLength: n,
+	})
+	if err == nil {
+		expBytesWritten.Add(n)
+	}
+	return err
+}
+
+// timeCopies copies each source object with copyFn, one at a time, and emits
+// a datapoint with per-object latency.
+func timeCopies(cfg *myConfig, s3Client *s3.Client, op, set string, srcs []string, dstPrefix string, copyFn func(ctx context.Context, src, dst string) error) {
+	td := newLatencyDigest()
+	tracker := newConnTracker()
+	ctx := withConnTracker(context.Background(), tracker)
+	startTime := time.Now()
+
+	for i, src := range srcs {
+		dst := path.Join(dstPrefix, strconv.Itoa(i))
+		start := time.Now()
+		err := copyFn(ctx, src, dst)
+		expRequests.Add(1)
+		if err != nil {
+			expErrors.Add(1)
+			fatalf("error copying %s to %s: %v", src, dst, err)
+		}
+		td.Add(time.Since(start).Seconds())
+	}
+	elapsedSec := time.Since(startTime).Seconds()
+
+	size := fileSets[set].Size
+	setCfg := *cfg
+	setCfg.FileSetName = set
+	datapoint := baseDatapoint(&setCfg)
+	datapoint.Operation = op
+	datapoint.Goroutines = 1
+	datapoint.TotalSizeBytes = size * len(srcs)
+	if op == "copy" && size > cfg.CopyPartSizeBytes {
+		datapoint.PartSizeBytes = cfg.CopyPartSizeBytes
+	}
+
+	datapoint.StartTime = startTime
+	datapoint.ElapsedSecs = elapsedSec
+	datapoint.setLatencies(td, cfg.Quantiles)
+	datapoint.ThroughputMiBs = float64(datapoint.TotalSizeBytes) / MiB / elapsedSec
+	datapoint.RequestsPerSec = float64(len(srcs)) / elapsedSec
+	datapoint.setConnStats(tracker, elapsedSec)
+
+	emitDatapoint(cfg, datapoint)
+}
+
+// runCopy compares server-side copy (CopyObject, or UploadPartCopy for large
+// objects) with copying through the client, for objects of each set's size.
+func runCopy(args []string) int {
+	cfg := parseCopyFlags(args)
+	prepareRun(cfg)
+
+	var buf bytes.Buffer
+	for i := 0; i < cfg.Count; i++ {
+		s3Client, err := configS3(cfg)
+		if err != nil {
+			fatalf("error configuring S3: %v", err)
+		}
+		runPrefix := path.Join(cfg.ScratchPrefix, strconv.FormatInt(time.Now().UnixNano(), 10))
+
+		for _, set := range cfg.CopySets {
+			setCfg := *cfg
+			setCfg.FileSetName = set
+			files, err := listSetFiles(&setCfg, s3Client, set)
+			if err != nil {
+				fatalf("error listing file set: %v", err)
+			}
+			if len(files) == 0 {
+				fatalf("no S3 files found for file set %s under s3://%s/%s (see the list-sets command)", set, cfg.Bucket, cfg.Prefix)
+			}
+			srcs := make([]string, cfg.CopyObjects)
+			for j := range srcs {
+				srcs[j] = files[j%len(files)]
+			}
+			size := fileSets[set].Size
+
+			debugf("copying %d %s objects server-side", len(srcs), set)
+			timeCopies(cfg, s3Client, "copy", set, srcs, path.Join(runPrefix, set, "server"), func(ctx context.Context, src, dst string) error {
+				return serverCopy(ctx, cfg, s3Client, src, dst, size)
+			})
+
+			debugf("copying %d %s objects through the client", len(srcs), set)
+			timeCopies(cfg, s3Client, "copy-client", set, srcs, path.Join(runPrefix, set, "client"), func(ctx context.Context, src, dst string) error {
+				return clientCopy(ctx, cfg, s3Client, src, dst, &buf)
+			})
+		}
+
+		n, err := deletePrefix(s3Client, cfg.Bucket, runPrefix+"/")
+		if err != nil {
+			fatalf("error cleaning up s3://%s/%s: %v", cfg.Bucket, runPrefix, err)
+		}
+		debugf("deleted %d copies", n)
+	}
+
+	return 0
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// cpuTimes are cumulative CPU seconds for this process and, summed over all
+// CPUs, for the whole system.
+type cpuTimes struct {
+	procUser, procSys        float64
+	user, sys, iowait, total float64
+}
+
+// cpuSampler samples CPU use each second while a run is in flight, so a
+// throughput plateau can be told apart from the client running out of CPU
+// for TLS and copying.  It needs readCPUTimes, which is Linux only.
+type cpuSampler struct {
+	sync.Mutex
+	start     cpuTimes
+	startWall time.Time
+	last      cpuTimes
+	lastWall  time.Time
+	procPeak  float64 // percent of one CPU, over a second
+	sysPeak   float64 // percent of all CPUs busy, over a second
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// startCPUSampler starts sampling, or returns nil where CPU times can't be
+// read.
+func startCPUSampler() *cpuSampler {
+	now, ok := readCPUTimes()
+	if !ok {
+		return nil
+	}
+	wall := time.Now()
+	cs := &cpuSampler{
+		start:     now,
+		startWall: wall,
+		last:      now,
+		lastWall:  wall,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go func() {
+		defer close(cs.done)
+		tick := time.NewTicker(time.Second)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				cs.sample()
+			case <-cs.stop:
+				return
+			}
+		}
+	}()
+	return cs
+}
+
+func (cs *cpuSampler) sample() {
+	now, ok := readCPUTimes()
+	if !ok {
+		return
+	}
+	wall := time.Now()
+	cs.Lock()
+	defer cs.Unlock()
+	proc, busy := cpuPercents(cs.last, now, wall.Sub(cs.lastWall))
+	if proc > cs.procPeak {
+		cs.procPeak = proc
+	}
+	if busy > cs.sysPeak {
+		cs.sysPeak = busy
+	}
+	cs.last, cs.lastWall = now, wall
+}
+
+// cpuPercents returns process CPU as a percentage of one CPU, and system
+// CPU busy as a percentage of all of them, between a and b.
+func cpuPercents(a, b cpuTimes, wall time.Duration) (proc, busy float64) {
+	if secs := wall.Seconds(); secs > 0 {
+		proc = (b.procUser + b.procSys - a.procUser - a.procSys) / secs * 100
+	}
+	if total := b.total - a.total; total > 0 {
+		busy = (b.user + b.sys - a.user - a.sys) / total * 100
+	}
+	return proc, busy
+}
+
+// Stop ends sampling.
+func (cs *cpuSampler) Stop() {
+	close(cs.stop)
+	<-cs.done
+}
+
+// setCPU reports CPU use since the sampler started or was last taken, and
+// starts afresh.  Peaks are over whole seconds; means cover everything.
+func (cs *cpuSampler) setCPU(dp *Datapoint) {
+	now, ok := readCPUTimes()
+	if !ok {
+		return
+	}
+	wall := time.Now()
+	cs.Lock()
+	defer cs.Unlock()
+
+	a := cs.start
+	if secs := wall.Sub(cs.startWall).Seconds(); secs > 0 {
+		dp.ProcessUserPct = (now.procUser - a.procUser) / secs * 100
+		dp.ProcessSysPct = (now.procSys - a.procSys) / secs * 100
+	}
+	if total := now.total - a.total; total > 0 {
+		dp.SystemUserPct = (now.user - a.user) / total * 100
+		dp.SystemSysPct = (now.sys - a.sys) / total * 100
+		dp.SystemIOWaitPct = (now.iowait - a.iowait) / total * 100
+	}
+	dp.ProcessCPUPct = dp.ProcessUserPct + dp.ProcessSysPct
+	dp.ProcessCPUPeakPct = cs.procPeak
+	dp.SystemBusyPeakPct = cs.sysPeak
+
+	cs.start, cs.startWall = now, wall
+	cs.last, cs.lastWall = now, wall
+	cs.procPeak, cs.sysPeak = 0, 0
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// clockTicks is USER_HZ, the unit of /proc CPU times; it's 100 on every
+// Linux platform Go supports.
+const clockTicks = 100
+
+// readCPUTimes reads the process's times from /proc/self/stat and the
+// system's from the aggregate cpu line of /proc/stat.
+func readCPUTimes() (cpuTimes, bool) {
+	var ct cpuTimes
+
+	self, err := os.ReadFile("/proc/self/stat")
+	if err != nil {
+		return ct, false
+	}
+	// The command name may hold spaces, so count fields after its ')'.
+	// utime and stime are fields 14 and 15, i.e. 12 and 13 after it.
+	fields := strings.Fields(string(self[bytes.LastIndexByte(self, ')')+1:]))
+	if len(fields) < 13 {
+		return ct, false
+	}
+	utime, err1 := strconv.ParseFloat(fields[11], 64)
+	stime, err2 := strconv.ParseFloat(fields[12], 64)
+	if err1 != nil || err2 != nil {
+		return ct, false
+	}
+	ct.procUser, ct.procSys = utime/clockTicks, stime/clockTicks
+
+	stat, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return ct, false
+	}
+	line, _, _ := strings.Cut(string(stat), "\n")
+	fields = strings.Fields(line)
+	// cpu user nice system idle iowait irq softirq steal ...; guest time is
+	// already in user, so it isn't added to the total.
+	if len(fields) < 9 || fields[0] != "cpu" {
+		return ct, false
+	}
+	var v [8]float64
+	for i := range v {
+		v[i], err = strconv.ParseFloat(fields[i+1], 64)
+		if err != nil {
+			return ct, false
+		}
+		ct.total += v[i] / clockTicks
+	}
+	ct.user = (v[0] + v[1]) / clockTicks
+	ct.sys = (v[2] + v[5] + v[6]) / clockTicks
+	ct.iowait = v[4] / clockTicks
+	return ct, true
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+// readCPUTimes isn't supported outside Linux, so CPU use isn't reported.
+func readCPUTimes() (cpuTimes, bool) {
+	return cpuTimes{}, false
+}
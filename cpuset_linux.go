@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// setCPUAffinity pins every thread of the process to cpus.  Affinity is
+// per-thread on Linux and the runtime has already started several, so each
+// one under /proc/self/task is set; threads started later inherit it.
+func setCPUAffinity(cpus []int) error {
+	var mask [16]uint64 // up to 1024 CPUs, matching glibc's cpu_set_t
+	for _, c := range cpus {
+		if c >= len(mask)*64 {
+			return syscall.EINVAL
+		}
+		mask[c/64] |= 1 << (uint(c) % 64)
+	}
+
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return err
+	}
+	for _, t := range tasks {
+		tid, err := strconv.Atoi(t.Name())
+		if err != nil {
+			continue
+		}
+		_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY,
+			uintptr(tid), unsafe.Sizeof(mask), uintptr(unsafe.Pointer(&mask)))
+		// A thread may have exited since the directory was read.
+		if errno != 0 && errno != syscall.ESRCH {
+			return errno
+		}
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// setCPUAffinity isn't implemented outside Linux.
+func setCPUAffinity(cpus []int) error {
+	return errors.New("--cpuset is only supported on Linux")
+}
@@ -0,0 +1,198 @@
+//go:build crt && cgo
+
+#include <stdlib.h>
+#include <string.h>
+
+#include <aws/auth/credentials.h>
+#include <aws/common/uri.h>
+#include <aws/http/request_response.h>
+#include <aws/io/channel_bootstrap.h>
+#include <aws/io/event_loop.h>
+#include <aws/io/host_resolver.h>
+#include <aws/io/tls_channel_handler.h>
+#include <aws/s3/s3.h>
+#include <aws/s3/s3_client.h>
+
+#include "crt.h"
+
+struct s3skunk_crt {
+	struct aws_allocator *alloc;
+	struct aws_event_loop_group *elg;
+	struct aws_host_resolver *resolver;
+	struct aws_client_bootstrap *bootstrap;
+	struct aws_credentials_provider *creds;
+	struct aws_tls_ctx *tls_ctx;
+	struct aws_tls_connection_options tls_opts;
+	struct aws_signing_config_aws signing;
+	struct aws_s3_client *client;
+	char *region; // signing.region points into it
+	struct aws_uri endpoint;
+	int has_endpoint;
+};
+
+static int s3skunk_headers(struct aws_s3_meta_request *mr, const struct aws_http_headers *headers,
+	int status, void *user_data) {
+	(void)mr;
+	(void)headers;
+	crtHeaders((uintptr_t)user_data, status);
+	return AWS_OP_SUCCESS;
+}
+
+static int s3skunk_body(struct aws_s3_meta_request *mr, const struct aws_byte_cursor *body,
+	uint64_t range_start, void *user_data) {
+	(void)mr;
+	(void)range_start;
+	crtBody((uintptr_t)user_data, body->len);
+	return AWS_OP_SUCCESS;
+}
+
+static void s3skunk_finish(struct aws_s3_meta_request *mr, const struct aws_s3_meta_request_result *result,
+	void *user_data) {
+	(void)mr;
+	crtFinish((uintptr_t)user_data, result->error_code, result->response_status);
+}
+
+static void s3skunk_crt_free(struct s3skunk_crt *c) {
+	if (c->client) {
+		aws_s3_client_release(c->client);
+	}
+	if (c->tls_ctx) {
+		aws_tls_connection_options_clean_up(&c->tls_opts);
+		aws_tls_ctx_release(c->tls_ctx);
+	}
+	aws_credentials_provider_release(c->creds);
+	aws_client_bootstrap_release(c->bootstrap);
+	aws_host_resolver_release(c->resolver);
+	aws_event_loop_group_release(c->elg);
+	if (c->has_endpoint) {
+		aws_uri_clean_up(&c->endpoint);
+	}
+	free(c->region);
+	free(c);
+}
+
+struct s3skunk_crt *s3skunk_crt_new(const char *region, const char *endpoint,
+	const char *access_key_id, const char *secret_access_key, const char *session_token,
+	uint64_t part_size, double target_gbps) {
+	static int initialized;
+	struct aws_allocator *alloc = aws_default_allocator();
+	if (!initialized) {
+		aws_s3_library_init(alloc);
+		initialized = 1;
+	}
+
+	struct s3skunk_crt *c = calloc(1, sizeof(*c));
+	c->alloc = alloc;
+	c->region = strdup(region);
+
+	c->elg = aws_event_loop_group_new_default(alloc, 0, NULL);
+	struct aws_host_resolver_default_options resolver_opts = {
+		.max_entries = 8,
+		.el_group = c->elg,
+	};
+	c->resolver = aws_host_resolver_new_default(alloc, &resolver_opts);
+	struct aws_client_bootstrap_options bootstrap_opts = {
+		.event_loop_group = c->elg,
+		.host_resolver = c->resolver,
+	};
+	c->bootstrap = aws_client_bootstrap_new(alloc, &bootstrap_opts);
+	if (!c->elg || !c->resolver || !c->bootstrap) {
+		s3skunk_crt_free(c);
+		return NULL;
+	}
+
+	struct aws_credentials_provider_static_options creds_opts = {
+		.access_key_id = aws_byte_cursor_from_c_str(access_key_id),
+		.secret_access_key = aws_byte_cursor_from_c_str(secret_access_key),
+		.session_token = aws_byte_cursor_from_c_str(session_token),
+	};
+	c->creds = aws_credentials_provider_new_static(alloc, &creds_opts);
+	if (!c->creds) {
+		s3skunk_crt_free(c);
+		return NULL;
+	}
+	aws_s3_init_default_signing_config(&c->signing, aws_byte_cursor_from_c_str(c->region), c->creds);
+
+	int tls = 1;
+	if (endpoint[0] != '\0') {
+		struct aws_byte_cursor cur = aws_byte_cursor_from_c_str(endpoint);
+		if (aws_uri_init_parse(&c->endpoint, alloc, &cur)) {
+			s3skunk_crt_free(c);
+			return NULL;
+		}
+		c->has_endpoint = 1;
+		struct aws_byte_cursor http = aws_byte_cursor_from_c_str("http");
+		tls = !aws_byte_cursor_eq_ignore_case(aws_uri_scheme(&c->endpoint), &http);
+	}
+	if (tls) {
+		struct aws_tls_ctx_options tls_ctx_opts;
+		aws_tls_ctx_options_init_default_client(&tls_ctx_opts, alloc);
+		c->tls_ctx = aws_tls_client_ctx_new(alloc, &tls_ctx_opts);
+		aws_tls_ctx_options_clean_up(&tls_ctx_opts);
+		if (!c->tls_ctx) {
+			s3skunk_crt_free(c);
+			return NULL;
+		}
+		aws_tls_connection_options_init_from_ctx(&c->tls_opts, c->tls_ctx);
+	}
+
+	struct aws_s3_client_config config = {
+		.client_bootstrap = c->bootstrap,
+		.region = aws_byte_cursor_from_c_str(c->region),
+		.signing_config = &c->signing,
+		.part_size = part_size,
+		.throughput_target_gbps = target_gbps,
+		.tls_mode = tls ? AWS_MR_TLS_ENABLED : AWS_MR_TLS_DISABLED,
+		.tls_connection_options = tls ? &c->tls_opts : NULL,
+	};
+	c->client = aws_s3_client_new(alloc, &config);
+	if (!c->client) {
+		s3skunk_crt_free(c);
+		return NULL;
+	}
+	return c;
+}
+
+struct aws_s3_meta_request *s3skunk_crt_get(struct s3skunk_crt *c, const char *host,
+	const char *path, const char *range, uintptr_t handle) {
+	struct aws_http_message *msg = aws_http_message_new_request(c->alloc);
+	if (!msg) {
+		return NULL;
+	}
+	aws_http_message_set_request_method(msg, aws_http_method_get);
+	aws_http_message_set_request_path(msg, aws_byte_cursor_from_c_str(path));
+	struct aws_http_header host_header = {
+		.name = aws_byte_cursor_from_c_str("Host"),
+		.value = aws_byte_cursor_from_c_str(host),
+	};
+	aws_http_message_add_header(msg, host_header);
+	if (range[0] != '\0') {
+		struct aws_http_header range_header = {
+			.name = aws_byte_cursor_from_c_str("Range"),
+			.value = aws_byte_cursor_from_c_str(range),
+		};
+		aws_http_message_add_header(msg, range_header);
+	}
+
+	struct aws_s3_meta_request_options opts = {
+		.type = AWS_S3_META_REQUEST_TYPE_GET_OBJECT,
+		.message = msg,
+		.endpoint = c->has_endpoint ? &c->endpoint : NULL,
+		.user_data = (void *)handle,
+		.headers_callback = s3skunk_headers,
+		.body_callback = s3skunk_body,
+		.finish_callback = s3skunk_finish,
+	};
+	struct aws_s3_meta_request *mr = aws_s3_client_make_meta_request(c->client, &opts);
+	// The meta request holds its own reference to the message.
+	aws_http_message_release(msg);
+	return mr;
+}
+
+void s3skunk_crt_cancel(struct aws_s3_meta_request *mr) {
+	aws_s3_meta_request_cancel(mr);
+}
+
+void s3skunk_crt_release(struct aws_s3_meta_request *mr) {
+	aws_s3_meta_request_release(mr);
+}
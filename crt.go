@@ -0,0 +1,216 @@
+//go:build crt && cgo
+
+package main
+
+// #cgo LDFLAGS: -laws-c-s3 -laws-c-auth -laws-c-http -laws-c-io -laws-c-compression -laws-c-cal -laws-c-sdkutils -laws-checksums -laws-c-common
+// #include <stdlib.h>
+// #include "crt.h"
+import "C"
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"runtime/cgo"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// crtAvailable is whether s3skunk was built with --client crt, which needs
+// the crt build tag, cgo and aws-c-s3 with its dependencies installed.
+const crtAvailable = true
+
+// crtClient is an aws-c-s3 client, the AWS Common Runtime's S3 client, for
+// --client crt.  It splits each GET into ranged parts of
+// --manager-part-size, fetched over as many connections as it judges
+// --crt-target-gbps needs, all on its own event loop threads.
+type crtClient struct {
+	c         *C.struct_s3skunk_crt
+	bucket    string
+	host      string // of every request
+	pathStyle bool
+}
+
+// newCRTClient makes a CRT client with the credentials, region and
+// endpoint configS3 would use.  The CRT can't call back into the SDK's
+// credential providers, so it signs with the credentials as they are now;
+// newBenchClients is called again for each --fresh-client iteration.
+func newCRTClient(cfg *myConfig) (*crtClient, error) {
+	awscfg, err := loadAWSConfig(cfg, cfg.Region, nil)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := awscfg.Credentials.Retrieve(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	cc := &crtClient{bucket: cfg.Bucket, pathStyle: cfg.PathStyle}
+	if cfg.Endpoint != "" {
+		u, err := url.Parse(cfg.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		cc.host = u.Host
+	} else {
+		cc.host = "s3." + awscfg.Region + ".amazonaws.com"
+	}
+	if !cc.pathStyle {
+		cc.host = cfg.Bucket + "." + cc.host
+	}
+
+	cs := []*C.char{
+		C.CString(awscfg.Region),
+		C.CString(cfg.Endpoint),
+		C.CString(creds.AccessKeyID),
+		C.CString(creds.SecretAccessKey),
+		C.CString(creds.SessionToken),
+	}
+	defer func() {
+		for _, s := range cs {
+			C.free(unsafe.Pointer(s))
+		}
+	}()
+	cc.c = C.s3skunk_crt_new(cs[0], cs[1], cs[2], cs[3], cs[4],
+		C.uint64_t(cfg.ManagerPartSize), C.double(cfg.CRTTargetGbps))
+	if cc.c == nil {
+		return nil, crtLastError()
+	}
+	return cc, nil
+}
+
+// crtError is an aws-c-* error code.
+type crtError int
+
+func (e crtError) Error() string {
+	return C.GoString(C.aws_error_name(C.int(e))) + ": " + C.GoString(C.aws_error_str(C.int(e)))
+}
+
+func crtLastError() error {
+	return crtError(C.aws_last_error())
+}
+
+// crtGetState is one GET in flight, called back from the CRT's threads
+// through a cgo.Handle.
+type crtGetState struct {
+	headers  chan struct{} // closed at successful response headers
+	done     chan struct{} // closed when the GET has finished
+	streamed *int64        // the run's, added to as the body arrives
+	n        int64         // atomic
+	errCode  int
+	status   int
+}
+
+//export crtHeaders
+func crtHeaders(h C.uintptr_t, status C.int) {
+	g := cgo.Handle(h).Value().(*crtGetState)
+	if status < 300 {
+		select {
+		case <-g.headers:
+		default:
+			close(g.headers)
+		}
+	}
+}
+
+//export crtBody
+func crtBody(h C.uintptr_t, n C.uint64_t) {
+	g := cgo.Handle(h).Value().(*crtGetState)
+	atomic.AddInt64(&g.n, int64(n))
+	atomic.AddInt64(g.streamed, int64(n))
+}
+
+//export crtFinish
+func crtFinish(h C.uintptr_t, errCode, status C.int) {
+	g := cgo.Handle(h).Value().(*crtGetState)
+	g.errCode, g.status = int(errCode), int(status)
+	close(g.done)
+}
+
+// crtGet downloads an object through c, for --client crt, and records it
+// as fetch does.  Like --client manager, it counts each part as a request,
+// and raw records have no attempts.  Latency is to the first part's
+// response headers.  The CRT's connections aren't Go's, so there are no
+// connection stats or per-part spans.
+func (rs *runState) crtGet(ctx, reqCtx context.Context, span trace.Span, ws *workerStats, c *crtClient, req *s3.GetObjectInput, start time.Time) {
+	f := aws.ToString(req.Key)
+	path := (&url.URL{Path: "/" + f}).EscapedPath()
+	if c.pathStyle {
+		path = "/" + url.PathEscape(c.bucket) + path
+	}
+
+	g := &crtGetState{headers: make(chan struct{}), done: make(chan struct{}), streamed: &rs.streamed}
+	h := cgo.NewHandle(g)
+	defer h.Delete()
+	cs := []*C.char{C.CString(c.host), C.CString(path), C.CString(aws.ToString(req.Range))}
+	mr := C.s3skunk_crt_get(c.c, cs[0], cs[1], cs[2], C.uintptr_t(h))
+	for _, s := range cs {
+		C.free(unsafe.Pointer(s))
+	}
+	if mr == nil {
+		rs.getFailed(ctx, reqCtx, span, f, start, crtLastError())
+		return
+	}
+	defer C.s3skunk_crt_release(mr)
+
+	// The CRT's threads only signal; latency is recorded here, so they're
+	// never held up by a full channel.
+	var secs float64
+	started := false
+	select {
+	case <-g.headers:
+	case <-g.done:
+	case <-reqCtx.Done():
+	}
+	select {
+	case <-g.headers:
+		secs = rs.recordLatency(f, start)
+		started = true
+	default:
+	}
+	select {
+	case <-g.done:
+	case <-reqCtx.Done():
+		C.s3skunk_crt_cancel(mr)
+		<-g.done
+	}
+	n := atomic.LoadInt64(&g.n)
+
+	parts := int64(1)
+	if req.Range == nil && n > int64(rs.cfg.ManagerPartSize) {
+		parts = (n + int64(rs.cfg.ManagerPartSize) - 1) / int64(rs.cfg.ManagerPartSize)
+	}
+	expRequests.Add(parts)
+	statsd.Count("requests", parts, "")
+	atomic.AddInt64(&rs.requests, parts)
+
+	var err error
+	switch {
+	case reqCtx.Err() != nil:
+		err = reqCtx.Err()
+	case g.status >= 300:
+		err = fmt.Errorf("GET %s: status %d", f, g.status)
+	case g.errCode != 0:
+		err = crtError(g.errCode)
+	case !started:
+		err = fmt.Errorf("GET %s: finished without response headers", f)
+	}
+	if !started {
+		rs.getFailed(ctx, reqCtx, span, f, start, err)
+		return
+	}
+	if rs.worst != nil {
+		rs.worst.Add(f, secs, middleware.Metadata{})
+	}
+
+	span.SetAttributes(attribute.Int64("s3.parts", parts))
+	rec := rawRecord{Key: f, Start: start, TTFBSecs: secs, Status: g.status}
+	rs.readDone(ctx, reqCtx, span, ws, secs, n, -1, rec, nil, err)
+}
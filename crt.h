@@ -0,0 +1,36 @@
+// Declarations shared by crt.c and crt.go, the --client crt backend.
+
+#ifndef S3SKUNK_CRT_H
+#define S3SKUNK_CRT_H
+
+#include <stdint.h>
+
+#include <aws/common/error.h>
+
+struct s3skunk_crt;
+struct aws_s3_meta_request;
+
+// s3skunk_crt_new makes an aws-c-s3 client signing with static
+// credentials.  endpoint is "" for AWS, or a URL whose scheme and port
+// requests go to.  It returns NULL, with aws_last_error set, on failure.
+struct s3skunk_crt *s3skunk_crt_new(const char *region, const char *endpoint,
+	const char *access_key_id, const char *secret_access_key, const char *session_token,
+	uint64_t part_size, double target_gbps);
+
+// s3skunk_crt_get starts an auto-ranged GET of path from host, with range
+// ("" for the whole object), calling back crtHeaders, crtBody and
+// crtFinish with handle.  It returns NULL, with aws_last_error set, if the
+// request couldn't be started; otherwise release the request once
+// crtFinish has been called.
+struct aws_s3_meta_request *s3skunk_crt_get(struct s3skunk_crt *c, const char *host,
+	const char *path, const char *range, uintptr_t handle);
+
+void s3skunk_crt_cancel(struct aws_s3_meta_request *mr);
+void s3skunk_crt_release(struct aws_s3_meta_request *mr);
+
+// Implemented in Go.
+extern void crtHeaders(uintptr_t handle, int status);
+extern void crtBody(uintptr_t handle, uint64_t n);
+extern void crtFinish(uintptr_t handle, int error_code, int status);
+
+#endif
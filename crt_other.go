@@ -0,0 +1,27 @@
+//go:build !crt || !cgo
+
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// crtAvailable is whether s3skunk was built with --client crt, which needs
+// the crt build tag, cgo and aws-c-s3 with its dependencies installed.
+const crtAvailable = false
+
+// crtClient isn't available without the crt build tag.
+type crtClient struct{}
+
+func newCRTClient(*myConfig) (*crtClient, error) {
+	return nil, errors.New("built without the crt tag")
+}
+
+func (rs *runState) crtGet(context.Context, context.Context, trace.Span, *workerStats, *crtClient, *s3.GetObjectInput, time.Time) {
+	panic("crtGet without the crt build tag")
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// csvColumns are the Datapoint fields with a single value, in declaration
+// order.  Maps, lists and nested results don't fit in a cell, so CSV leaves
+// them out, as it does the MongoDB _id; use JSON for those.
+var csvColumns = func() []int {
+	var cols []int
+	t := reflect.TypeOf(Datapoint{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		switch f.Type.Kind() {
+		case reflect.Map, reflect.Slice, reflect.Ptr, reflect.Array:
+			continue
+		case reflect.Struct:
+			if f.Type != reflect.TypeOf(time.Time{}) {
+				continue
+			}
+		}
+		cols = append(cols, i)
+	}
+	return cols
+}()
+
+// csvHeader is the header row for --format csv.
+func csvHeader() string {
+	t := reflect.TypeOf(Datapoint{})
+	names := make([]string, len(csvColumns))
+	for i, c := range csvColumns {
+		names[i] = t.Field(c).Name
+	}
+	return csvLine(names)
+}
+
+// formatCSV renders a datapoint as one CSV row, in csvHeader's columns.
+func formatCSV(dp Datapoint) (string, error) {
+	v := reflect.ValueOf(dp)
+	cells := make([]string, len(csvColumns))
+	for i, c := range csvColumns {
+		f := v.Field(c)
+		switch f.Kind() {
+		case reflect.Bool:
+			cells[i] = strconv.FormatBool(f.Bool())
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			cells[i] = strconv.FormatInt(f.Int(), 10)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			cells[i] = strconv.FormatUint(f.Uint(), 10)
+		case reflect.Float32, reflect.Float64:
+			cells[i] = strconv.FormatFloat(f.Float(), 'g', -1, 64)
+		case reflect.String:
+			cells[i] = f.String()
+		default:
+			cells[i] = f.Interface().(time.Time).Format(time.RFC3339Nano)
+		}
+	}
+	return csvLine(cells), nil
+}
+
+// csvLine quotes cells as needed and joins them, without a line ending.
+func csvLine(cells []string) string {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Write(cells)
+	w.Flush()
+	return strings.TrimSuffix(b.String(), "\n")
+}
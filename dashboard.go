@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// dashboardSecs is how many seconds of live figures the dashboard keeps.
+const dashboardSecs = 600
+
+// dashboardResults bounds the results listed on the dashboard, newest
+// first; /history has them all.
+const dashboardResults = 50
+
+// dashSample is a second of live figures, for the dashboard's charts.
+// Latencies are rolling quantiles, absent while nothing completes.
+type dashSample struct {
+	Time     int64 // Unix seconds
+	MiBs     float64
+	Requests int64
+	P50ms    *float64 `json:",omitempty"`
+	P99ms    *float64 `json:",omitempty"`
+	InFlight int64
+	Errors   int64
+	Timeouts int64
+}
+
+// dashboard is the web UI on the diagnostics server: live charts of
+// whatever the process is running, from the expvar counters and the
+// latencies recordLatency feeds it, and the results completed so far.
+// With --store, /history browses every result in it, in the same form as
+// report --html.  It's meant for a headless box reached over an SSH tunnel.
+type dashboard struct {
+	store string
+	start time.Time
+	lat   *rollingLatency
+
+	sync.Mutex
+	samples []dashSample
+	results []Datapoint // emitted by this process
+}
+
+// dash is the dashboard; nil with --pprof-disable.
+var dash *dashboard
+
+// startDashboard registers the dashboard's pages on the default mux and
+// starts taking a sample every second.
+func startDashboard(storeName string) {
+	dash = &dashboard{store: storeName, start: time.Now(), lat: newRollingLatency(time.Now().UnixNano())}
+	http.HandleFunc("/", dash.serveIndex)
+	http.HandleFunc("/live.json", dash.serveLive)
+	http.HandleFunc("/history", dash.serveHistory)
+	http.HandleFunc("/result", dash.serveResult)
+	go dash.sample()
+}
+
+// Add records a request's latency for the rolling quantiles.
+func (d *dashboard) Add(secs float64) {
+	if d == nil {
+		return
+	}
+	d.lat.Add(secs)
+}
+
+// record adds an emitted result to the dashboard.
+func (d *dashboard) record(dp Datapoint) {
+	if d == nil {
+		return
+	}
+	d.Lock()
+	defer d.Unlock()
+	d.results = append(d.results, dp)
+}
+
+func (d *dashboard) sample() {
+	moved := func() int64 { return expBytesRead.Value() + expBytesWritten.Value() }
+	lastBytes, lastRequests := moved(), expRequests.Value()
+	for now := range time.Tick(time.Second) {
+		bytes, requests := moved(), expRequests.Value()
+		s := dashSample{
+			Time:     now.Unix(),
+			MiBs:     float64(bytes-lastBytes) / MiB,
+			Requests: requests - lastRequests,
+			InFlight: expInFlight.Value(),
+			Errors:   expErrors.Value(),
+			Timeouts: expTimeouts.Value(),
+		}
+		lastBytes, lastRequests = bytes, requests
+		if lat := d.lat.window(); len(lat) > 0 {
+			p50, p99 := lat[len(lat)/2]*1000, lat[(len(lat)*99)/100]*1000
+			s.P50ms, s.P99ms = &p50, &p99
+		}
+
+		d.Lock()
+		if len(d.samples) == dashboardSecs {
+			d.samples = append(d.samples[:0], d.samples[1:]...)
+		}
+		d.samples = append(d.samples, s)
+		d.Unlock()
+	}
+}
+
+func (d *dashboard) serveLive(w http.ResponseWriter, _ *http.Request) {
+	d.Lock()
+	samples := append([]dashSample{}, d.samples...)
+	d.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(samples)
+}
+
+// history returns the results to browse and where they're from: the
+// --store database if there is one yet, else those of this process.
+func (d *dashboard) history() ([]Datapoint, string, error) {
+	if d.store != "" {
+		dps, err := readStoredDatapoints(d.store)
+		if !os.IsNotExist(err) {
+			return dps, d.store, err
+		}
+	}
+	d.Lock()
+	defer d.Unlock()
+	var dps []Datapoint
+	for _, dp := range d.results {
+		// As readStoredDatapoints skips --count summaries.
+		if dp.Iterations == 0 {
+			dps = append(dps, dp)
+		}
+	}
+	return dps, "this run", nil
+}
+
+// serveHistory renders the results, narrowed by any filter=name=value
+// parameters as with report --filter, as report --html would.
+func (d *dashboard) serveHistory(w http.ResponseWriter, r *http.Request) {
+	dps, source, err := d.history()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	dps, err = filterDatapoints(dps, r.URL.Query()["filter"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := reportTemplate.Execute(w, buildReport([]string{source}, dps)); err != nil {
+		warnf("error rendering dashboard history: %v", err)
+	}
+}
+
+// serveResult writes the result with the given id as JSON.
+func (d *dashboard) serveResult(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	find := func(dps []Datapoint) bool {
+		for _, dp := range dps {
+			if dp.ID.Hex() == id {
+				w.Header().Set("Content-Type", "application/json")
+				enc := json.NewEncoder(w)
+				enc.SetIndent("", "  ")
+				enc.Encode(dp)
+				return true
+			}
+		}
+		return false
+	}
+
+	d.Lock()
+	results := append([]Datapoint{}, d.results...)
+	d.Unlock()
+	if find(results) {
+		return
+	}
+	if dps, _, err := d.history(); err == nil && find(dps) {
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// dashboardRow is a result as the dashboard lists it.
+type dashboardRow struct {
+	ID, Started, Operation, Set string
+	Goroutines                  int
+	ThroughputMiBs              float64
+	P50ms, P99ms                float64
+	ErrorRate                   float64
+}
+
+type dashboardPage struct {
+	Command string
+	Host    string
+	Started string
+	Store   string
+	Results []dashboardRow
+}
+
+func (d *dashboard) serveIndex(w http.ResponseWriter, r *http.Request) {
+	// The default mux sends every unmatched path here.
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	host, _ := os.Hostname()
+	page := dashboardPage{
+		Command: commandName,
+		Host:    host,
+		Started: d.start.UTC().Format(time.RFC1123),
+		Store:   d.store,
+	}
+	d.Lock()
+	for i := len(d.results) - 1; i >= 0 && len(page.Results) < dashboardResults; i-- {
+		dp := d.results[i]
+		row := dashboardRow{
+			ID:             dp.ID.Hex(),
+			Started:        dp.StartTime.UTC().Format("2006-01-02 15:04:05"),
+			Operation:      dp.Operation,
+			Set:            dp.FileSizeLabel,
+			Goroutines:     dp.Goroutines,
+			ThroughputMiBs: dp.ThroughputMiBs,
+			P50ms:          dp.P50Latency * 1000,
+			P99ms:          dp.P99Latency * 1000,
+			ErrorRate:      dp.ErrorRate * 100,
+		}
+		if dp.Iterations > 0 {
+			row.Set += fmt.Sprintf(" (mean of %d)", dp.Iterations)
+		}
+		page.Results = append(page.Results, row)
+	}
+	d.Unlock()
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.Execute(w, page); err != nil {
+		warnf("error rendering dashboard: %v", err)
+	}
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>s3skunk {{.Command}} on {{.Host}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+.note, .meta { color: #666; font-size: 90%; }
+.figures span { margin-right: 2em; font-size: 120%; }
+svg text { font-size: 12px; }
+.axis { stroke: #999; }
+table { border-collapse: collapse; margin-top: 1em; }
+th, td { padding: 0.3em 0.8em; text-align: right; border-bottom: 1px solid #ddd; }
+th { background: #f4f4f4; }
+td:nth-child(-n+3), th:nth-child(-n+3) { text-align: left; }
+</style>
+</head>
+<body>
+<h1>s3skunk {{.Command}} on {{.Host}}</h1>
+<p class="meta">Started {{.Started}}.  <a href="/history">History</a>{{if .Store}} of {{.Store}}{{else}} of this run{{end}} · <a href="/metrics">metrics</a> · <a href="/debug/vars">expvar</a> · <a href="/debug/pprof/">pprof</a></p>
+<h2>Live</h2>
+<p class="figures" id="figures">Waiting for the first sample&hellip;</p>
+<svg width="800" height="200" id="tput"></svg>
+<svg width="800" height="200" id="lat"></svg>
+<p class="note">MiB/s moved each second, and p50 (blue) and p99 (orange) latency to response headers over the last 10 seconds, for the last 10 minutes.  Refreshed every 2 seconds.</p>
+<h2>Results</h2>
+{{if .Results}}
+<table>
+<thead><tr><th>started</th><th>operation</th><th>set</th><th>goroutines</th><th>MiB/s</th><th>p50 ms</th><th>p99 ms</th><th>errors %</th></tr></thead>
+<tbody>
+{{range .Results}}<tr><td><a href="/result?id={{.ID}}">{{.Started}}</a></td><td>{{.Operation}}</td><td>{{.Set}}</td><td>{{.Goroutines}}</td><td>{{printf "%.1f" .ThroughputMiBs}}</td><td>{{printf "%.2f" .P50ms}}</td><td>{{printf "%.2f" .P99ms}}</td><td>{{printf "%.2f" .ErrorRate}}</td></tr>
+{{end}}</tbody>
+</table>
+{{else}}<p>No results yet; reload once one completes.</p>{{end}}
+<script>
+var svgNS = "http://www.w3.org/2000/svg";
+function el(svg, name, attrs, text) {
+  var e = document.createElementNS(svgNS, name);
+  for (var k in attrs) e.setAttribute(k, attrs[k]);
+  if (text !== undefined) e.textContent = text;
+  svg.appendChild(e);
+}
+// draw plots each series, a list of [time, value] with value null for a
+// gap, against a shared time axis.
+function draw(id, label, series, colors) {
+  var svg = document.getElementById(id), w = 800, h = 200, l = 60, r = 790, t = 10, b = 180;
+  while (svg.firstChild) svg.removeChild(svg.firstChild);
+  var xs = [], max = 0;
+  series.forEach(function (s) { s.forEach(function (p) { xs.push(p[0]); if (p[1] > max) max = p[1]; }); });
+  if (!xs.length) return;
+  var x0 = Math.min.apply(null, xs), x1 = Math.max(Math.max.apply(null, xs), x0 + 1);
+  max = max || 1;
+  var x = function (v) { return l + (v - x0) / (x1 - x0) * (r - l); };
+  var y = function (v) { return b - v / max * (b - t); };
+  el(svg, "line", {"class": "axis", x1: l, x2: r, y1: b, y2: b});
+  el(svg, "line", {"class": "axis", x1: l, x2: l, y1: t, y2: b});
+  el(svg, "text", {x: l - 6, y: t + 4, "text-anchor": "end"}, max.toPrecision(3));
+  el(svg, "text", {x: l - 6, y: b, "text-anchor": "end"}, "0");
+  el(svg, "text", {x: r, y: h - 4, "text-anchor": "end"}, label);
+  el(svg, "text", {x: l, y: h - 4}, new Date(x0 * 1000).toLocaleTimeString());
+  series.forEach(function (s, i) {
+    var d = "", pen = "M";
+    s.forEach(function (p) {
+      if (p[1] === null) { pen = "M"; return; }
+      d += pen + x(p[0]).toFixed(1) + "," + y(p[1]).toFixed(1);
+      pen = "L";
+    });
+    el(svg, "path", {d: d, fill: "none", stroke: colors[i], "stroke-width": 2});
+  });
+}
+function refresh() {
+  fetch("/live.json").then(function (r) { return r.json(); }).then(function (samples) {
+    if (!samples.length) return;
+    var s = samples[samples.length - 1];
+    var ms = function (v) { return v === undefined ? "-" : v.toFixed(1) + " ms"; };
+    document.getElementById("figures").innerHTML =
+      "<span>" + s.MiBs.toFixed(1) + " MiB/s</span><span>" + s.Requests + " req/s</span>" +
+      "<span>p50 " + ms(s.P50ms) + "</span><span>p99 " + ms(s.P99ms) + "</span>" +
+      "<span>" + s.InFlight + " in flight</span><span>" + s.Errors + " errors</span><span>" + s.Timeouts + " timeouts</span>";
+    var pick = function (f) { return samples.map(function (s) { var v = s[f]; return [s.Time, v === undefined ? null : v]; }); };
+    draw("tput", "MiB/s", [pick("MiBs")], ["#2ca02c"]);
+    draw("lat", "ms", [pick("P50ms"), pick("P99ms")], ["#1f77b4", "#ff7f0e"]);
+  }).catch(function () {
+    document.getElementById("figures").textContent = "s3skunk isn't answering; it may have finished.";
+  });
+}
+refresh();
+setInterval(refresh, 2000);
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/spf13/pflag"
+)
+
+func parseDeleteFlags(args []string) *myConfig {
+	cfg := &myConfig{}
+	fs := pflag.NewFlagSet("delete", pflag.ExitOnError)
+	addS3Flags(fs, cfg)
+	fs.IntVar(&cfg.Count, "count", 1, "number of datapoint pairs to generate")
+	fs.StringVar(&cfg.EC2Instance, "instance", "", "EC2 instance type (default: detected from instance metadata)")
+	fs.IntVar(&cfg.Goroutines, "goroutines", runtime.NumCPU(), "parallel deleters (and uploaders while seeding)")
+	fs.IntVar(&cfg.DeleteObjects, "objects", 10000, "objects to seed and then delete, for each of single and batch deletes")
+	fs.IntVar(&cfg.DeleteBatchSize, "batch-size", maxDeleteBatch, "keys per DeleteObjects call")
+	fs.StringVar(&cfg.ScratchPrefix, "scratch-prefix", "s3skunk-scratch", "prefix to seed and delete under; each iteration uses a fresh sub-prefix")
+	addResultFlags(fs, cfg)
+	parseFlags(fs, args)
+
+	validateS3Flags(cfg)
+	validateResultFlags(cfg)
+
+	if cfg.Goroutines < 1 {
+		fatalf("goroutines must be at least 1")
+	}
+	if cfg.DeleteObjects < cfg.Goroutines {
+		fatalf("objects (%d) must be at least goroutines (%d)", cfg.DeleteObjects, cfg.Goroutines)
+	}
+	if cfg.DeleteBatchSize < 1 || cfg.DeleteBatchSize > maxDeleteBatch {
+		fatalf("batch-size must be between 1 and %d", maxDeleteBatch)
+	}
+	if path.Clean(cfg.ScratchPrefix) == path.Clean(cfg.Prefix) {
+		fatalf("scratch-prefix must differ from prefix")
+	}
+
+	return cfg
+}
+
+// seedScratch uploads n empty objects under prefix, untimed, and returns
+// their keys.
+func seedScratch(cfg *myConfig, s3Client *s3.Client, prefix string, n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = seedKey(prefix, "", i)
+	}
+
+	work := make(chan string, cfg.Goroutines)
+	go func() {
+		for _, k := range keys {
+			work <- k
+		}
+		close(work)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range work {
+				_, err := s3Client.PutObject(context.Background(), &s3.PutObjectInput{
+					Bucket: aws.String(cfg.Bucket),
+					Key:    aws.String(key),
+					Body:   bytes.NewReader(nil),
+				})
+				if err != nil {
+					fatalf("error seeding %s: %v", key, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return keys
+}
+
+// timeDeletes runs del over batches of keys with cfg.Goroutines workers and
+// emits a datapoint for it.  Latency is per call.  del returns how many keys
+// of a batch S3 failed to delete, as DeleteObjects reports per key; those are
+// errors and left out of the keys deleted.
+func timeDeletes(cfg *myConfig, op string, keys []string, batchSize int, del func(ctx context.Context, batch []string) (int, error)) {
+	work := make(chan []string, cfg.Goroutines)
+	go func() {
+		for i := 0; i < len(keys); i += batchSize {
+			end := i + batchSize
+			if end > len(keys) {
+				end = len(keys)
+			}
+			work <- keys[i:end]
+		}
+		close(work)
+	}()
+
+	td := newLatencyDigest()
+	var tdMu sync.Mutex
+	var calls, failed int64
+
+	tracker := newConnTracker()
+	ctx := withConnTracker(context.Background(), tracker)
+	startTime := time.Now()
+
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range work {
+				start := time.Now()
+				n, err := del(ctx, batch)
+				expRequests.Add(1)
+				if err != nil {
+					expErrors.Add(1)
+					fatalf("error deleting %s...: %v", batch[0], err)
+				}
+				if n > 0 {
+					expErrors.Add(int64(n))
+					atomic.AddInt64(&failed, int64(n))
+				}
+				secs := time.Since(start).Seconds()
+				tdMu.Lock()
+				td.Add(secs)
+				tdMu.Unlock()
+				atomic.AddInt64(&calls, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	elapsedSec := time.Since(startTime).Seconds()
+
+	datapoint := baseDatapoint(cfg)
+	datapoint.Operation = op
+	if op == "delete-batch" {
+		datapoint.DeleteBatchSize = batchSize
+	}
+
+	datapoint.StartTime = startTime
+	datapoint.ElapsedSecs = elapsedSec
+	datapoint.setLatencies(td, cfg.Quantiles)
+	datapoint.RequestsPerSec = float64(calls) / elapsedSec
+	datapoint.KeysPerSec = float64(int64(len(keys))-failed) / elapsedSec
+	// Errors here are keys not deleted, so the rate is out of all keys.
+	datapoint.Errors = int(failed)
+	datapoint.ErrorRate = float64(failed) / float64(len(keys))
+	datapoint.setConnStats(tracker, elapsedSec)
+
+	emitDatapoint(cfg, datapoint)
+}
+
+// runDelete benchmarks deletes: single DeleteObject calls, then batched
+// DeleteObjects, each against objects it seeds itself under a scratch
+// prefix.
+func runDelete(args []string) int {
+	cfg := parseDeleteFlags(args)
+	prepareRun(cfg)
+
+	for i := 0; i < cfg.Count; i++ {
+		s3Client, err := configS3(cfg)
+		if err != nil {
+			fatalf("error configuring S3: %v", err)
+		}
+		runPrefix := path.Join(cfg.ScratchPrefix, strconv.FormatInt(time.Now().UnixNano(), 10))
+
+		debugf("seeding %d objects for single deletes", cfg.DeleteObjects)
+		keys := seedScratch(cfg, s3Client, path.Join(runPrefix, "single"), cfg.DeleteObjects)
+		timeDeletes(cfg, "delete", keys, 1, func(ctx context.Context, batch []string) (int, error) {
+			_, err := s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+				Bucket: aws.String(cfg.Bucket),
+				Key:    aws.String(batch[0]),
+			})
+			return 0, err
+		})
+
+		debugf("seeding %d objects for batch deletes", cfg.DeleteObjects)
+		keys = seedScratch(cfg, s3Client, path.Join(runPrefix, "batch"), cfg.DeleteObjects)
+		timeDeletes(cfg, "delete-batch", keys, cfg.DeleteBatchSize, func(ctx context.Context, batch []string) (int, error) {
+			ids := make([]types.ObjectIdentifier, len(batch))
+			for i := range batch {
+				ids[i] = types.ObjectIdentifier{Key: aws.String(batch[i])}
+			}
+			out, err := s3Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+				Bucket: aws.String(cfg.Bucket),
+				Delete: &types.Delete{Objects: ids, Quiet: true},
+			})
+			if err != nil {
+				return 0, err
+			}
+			if len(out.Errors) > 0 {
+				e := out.Errors[0]
+				warnf("%d of %d keys not deleted, first %s: %s", len(out.Errors), len(batch), aws.ToString(e.Key), aws.ToString(e.Code))
+			}
+			return len(out.Errors), nil
+		})
+	}
+
+	return 0
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+
+	_ "net/http/pprof"
+)
+
+// Live counters, served at /debug/vars alongside pprof so a running
+// benchmark can be inspected remotely.
+var (
+	expRequests     = expvar.NewInt("requests")
+	expBytesRead    = expvar.NewInt("bytes_read")
+	expBytesWritten = expvar.NewInt("bytes_written")
+	expErrors       = expvar.NewInt("errors")
+	expTimeouts     = expvar.NewInt("timeouts")
+	expInFlight     = expvar.NewInt("in_flight")
+)
+
+// startDiagServer serves pprof, expvar, Prometheus /metrics and the web
+// dashboard on the default mux in the background.  storeName is the
+// --store database for the dashboard's history, if any.
+func startDiagServer(addr, storeName string) {
+	http.HandleFunc("/metrics", serveMetrics)
+	startDashboard(storeName)
+	go func() {
+		errorf("diagnostics server: %v", http.ListenAndServe(addr, nil))
+	}()
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// parseDistribution validates --distribution, which is "uniform" or
+// "zipf:<s>" with s > 1, returning the Zipf exponent or 0 for uniform.
+func parseDistribution(spec string) (float64, error) {
+	if spec == "uniform" {
+		return 0, nil
+	}
+	name, arg, _ := strings.Cut(spec, ":")
+	if name != "zipf" {
+		return 0, fmt.Errorf("unknown distribution '%s'", spec)
+	}
+	s, err := strconv.ParseFloat(arg, 64)
+	if err != nil || s <= 1 {
+		return 0, fmt.Errorf("zipf exponent must be a number greater than 1, e.g. zipf:1.1")
+	}
+	return s, nil
+}
+
+// newKeyPicker returns a func choosing indexes into a shuffled list of n keys.
+// Uniform access walks the list in order, wrapping around; Zipf access
+// favours the first few keys, which the shuffle has made a random few.
+func newKeyPicker(cfg *myConfig, rng *rand.Rand, n int) func() int {
+	if cfg.ZipfS == 0 || n == 1 {
+		var next int
+		return func() int {
+			i := next % n
+			next++
+			return i
+		}
+	}
+	z := rand.NewZipf(rng, cfg.ZipfS, 1, uint64(n-1))
+	return func() int { return int(z.Uint64()) }
+}
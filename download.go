@@ -0,0 +1,1664 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http/httptrace"
+	"path"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3v1 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/influxdata/tdigest"
+	"github.com/spf13/pflag"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func parseDownloadFlags(args []string) *myConfig {
+	return parseDownloadFlagSet(pflag.NewFlagSet("download", pflag.ExitOnError), args)
+}
+
+// parseDownloadFlagSet adds the download flags to fs, which may already
+// hold flags of a command built on downloads, and parses args.
+func parseDownloadFlagSet(fs *pflag.FlagSet, args []string) *myConfig {
+	cfg := &myConfig{}
+	addS3Flags(fs, cfg)
+	count := fs.Uint("count", 1, "number of datapoints to generate")
+	instance := fs.String("instance", "", "EC2 instance type (default: detected from instance metadata)")
+	goroutines := fs.UintSlice("goroutines", []uint{uint(runtime.NumCPU())}, "parallel downloads, or a list like 8,32,128 to run each in turn")
+	fs.IntVar(&cfg.GoMaxProcs, "gomaxprocs", 0, "set GOMAXPROCS (default: the Go runtime's choice, or the size of --cpuset)")
+	fs.StringVar(&cfg.CPUSet, "cpuset", "", "pin the process to these CPUs, taskset-style, e.g. 0-3,8 (Linux only)")
+	fileSetName := fs.String("set", "M001", "file set to download, a list like M001,M016,M064 to run each in turn, or a weighted mix like M001:0.7,M016:0.2,M064:0.1")
+	downloadSize := fs.Uint("download", 256, "total size to download in MiB")
+	fs.DurationVar(&cfg.Duration, "duration", 0, "instead of a fixed --download size, keep looping over the file set for this long")
+	fs.DurationVar(&cfg.ReportInterval, "report-interval", 0, "with --duration, emit a datapoint for each interval of this length instead of one at the end")
+	ramp := fs.String("ramp", "", "grow the worker pool in goroutines:duration steps, e.g. 4:30s,16:30s,64:30s, emitting a datapoint per step")
+	fs.DurationVar(&cfg.Warmup, "warmup", 0, "before each iteration, download for this long without recording anything, to set up connections")
+	fs.IntVar(&cfg.WarmupRequests, "warmup-requests", 0, "before each iteration, make this many requests without recording anything, instead of --warmup")
+	fs.StringVar(&cfg.Client, "client", "sdk", "how GETs are made: sdk, a GetObject per object; manager, through the SDK's transfer manager Downloader in concurrent ranged parts; sdkv1, a GetObject per object with aws-sdk-go v1; or crt, through the AWS Common Runtime's S3 client in concurrent ranged parts, if built with -tags crt")
+	managerPartSize := fs.Uint("manager-part-size", manager.DefaultDownloadPartSize/MiB, "with --client manager or crt, part size in MiB")
+	fs.Float64Var(&cfg.CRTTargetGbps, "crt-target-gbps", 10, "with --client crt, throughput in Gbit/s the CRT sizes its connection pool for")
+	fs.IntVar(&cfg.ManagerParts, "manager-concurrency", manager.DefaultDownloadConcurrency, "with --client manager, parts of an object in flight at once")
+	fs.StringVar(&cfg.Op, "op", "get", "request to benchmark: get, head, conditional-get (expecting 304 Not Modified), get-tagging or get-acl")
+	fs.StringVar(&cfg.Distribution, "distribution", "uniform", "how requests spread over keys: uniform, or zipf:<s> (s > 1) to concentrate them on a few hot keys")
+	fs.Float64Var(&cfg.WriteRatio, "write-ratio", 0, "fraction of operations that PUT a new object under --scratch-prefix instead of a GET")
+	fs.StringVar(&cfg.ScratchPrefix, "scratch-prefix", "s3skunk-scratch", "prefix for --write-ratio uploads; each iteration uses a fresh sub-prefix")
+	fs.BoolVar(&cfg.KeepScratch, "keep", false, "don't delete objects written with --write-ratio")
+	fs.Float64Var(&cfg.Rate, "rate", 0, "open loop: start requests at this average rate per second, Poisson distributed, with --goroutines as the concurrency cap")
+	fs.Float64Var(&cfg.TargetMiBs, "target-mibs", 0, "open loop: pace requests evenly to offer this throughput in MiB/s, with --goroutines as the concurrency cap")
+	thinkTime := fs.String("think-time", "0s", "pause each worker between requests for this long, or exp:<mean> for exponentially distributed pauses")
+	fs.BoolVar(&cfg.FailOnError, "fail-on-error", false, "exit on the first failed request, rather than counting errors in the results")
+	fs.IntVar(&cfg.Worst, "worst", 0, "add the key, latency and request IDs of this many of the slowest GETs to JSON results")
+	fs.BoolVar(&cfg.PerWorker, "per-worker", false, "add each goroutine's requests, bytes and latency to JSON results")
+	fs.BoolVar(&cfg.ThroughputSeries, "throughput-series", false, "add the MiB/s of GET bodies in each second of the run to JSON results")
+	fs.BoolVar(&cfg.TUI, "tui", false, "show progress, MiB/s, rolling p50 and p99 latency, requests in flight and errors on stderr, refreshed every second")
+	fs.DurationVar(&cfg.LatencyWindow, "latency-window", 0, "add p50 and p99 latency for each window of this length, e.g. 10s, to JSON results")
+	fs.DurationVar(&cfg.Heatmap, "heatmap", 0, "add counts of latency by time slot of this length and latency bucket to JSON results, for a heatmap")
+	fs.BoolVar(&cfg.Histogram, "histogram", false, "add a mergeable histogram of latencies, in log-linear buckets under 1% wide, to JSON results")
+	fs.StringVar(&cfg.RawOutput, "raw-output", "", "also write a JSON record per GET (key, size, start, TTFB, total time, attempts, status) to this file")
+	fs.StringVar(&cfg.RawFormat, "raw-format", "json", "format of --raw-output: json, appended a line per GET, or parquet, written afresh and complete at exit")
+	fs.Float64Var(&cfg.RawSample, "sample-rate", 1, "fraction of GETs, chosen at random, to write to --raw-output or --store, e.g. 0.01 to keep long high-rate runs' raw output small")
+	fs.Float64Var(&cfg.RawSample, "raw-sample", 1, "")
+	fs.MarkDeprecated("raw-sample", "use --sample-rate")
+	fs.BoolVar(&cfg.StoreRequests, "store-requests", false, "also add the --raw-output record of each GET to --store")
+	sink := fs.String("sink", "discard", "where bodies go: discard, or disk:<dir> to write each worker's downloads to a file there")
+	fs.BoolVar(&cfg.SinkDirect, "sink-direct", false, "open --sink files with O_DIRECT, bypassing the page cache (Linux only)")
+	fs.BoolVar(&cfg.SinkFsync, "sink-fsync", false, "fsync each --sink file after writing it")
+	rangeSize := fs.Uint("range-size", 0, "fetch a random byte range of this many KiB from each object instead of the whole object")
+	connAffinity := fs.Bool("conn-affinity", false, "give each goroutine its own dedicated connection")
+	hedgeAfter := fs.Duration("hedge-after", 0, "launch a hedged request on a fresh connection if no response by then (0 to disable)")
+	fs.DurationVar(&cfg.RequestTimeout, "request-timeout", 0, "deadline for each GetObject including its body; timed-out objects are counted and skipped (0 for no limit)")
+	pricingFile := fs.String("pricing", "", "YAML file of S3 prices (get_per_1000, put_per_1000, transfer_per_gib) for cost estimates (default: us-east-1 Standard, no transfer charge)")
+	fs.DurationVar(&cfg.Cooldown, "cooldown", 0, "pause between iterations when --count > 1")
+	fs.DurationVar(&cfg.CooldownJitter, "cooldown-jitter", 0, "add a random pause of up to this much to --cooldown")
+	fs.BoolVar(&cfg.FreshClient, "fresh-client", true, "build new S3 clients, and so new connections, for each iteration")
+	fs.DurationVar(&cfg.MaxDuration, "max-duration", 0, "stop the benchmark after this long, emitting a truncated datapoint (0 for no limit)")
+	maxMemory := fs.Uint("max-memory", 0, "soft memory limit in MiB (0 for none)")
+	addResultFlags(fs, cfg)
+	fs.BoolVar(&cfg.DryRun, "dry-run", false, "validate flags, list the file set and print the plan without downloading")
+	parseFlags(fs, args)
+
+	validateS3Flags(cfg)
+	validateResultFlags(cfg)
+
+	var err error
+	cfg.FileSetName = *fileSetName
+	cfg.SetWeights, err = parseSetMix(cfg.FileSetName)
+	if err != nil {
+		fatalf("error parsing set: %v", err)
+	}
+
+	// Lists of sets or goroutine counts make a matrix, run one combination
+	// at a time.
+	sets := []string{cfg.FileSetName}
+	if cfg.SetWeights == nil && strings.Contains(cfg.FileSetName, ",") {
+		sets = strings.Split(cfg.FileSetName, ",")
+		cfg.MatrixSets = sets
+	}
+	for _, set := range sets {
+		if _, ok := fileSets[set]; !ok && cfg.SetWeights == nil {
+			fatalf("unknown file set '%s'", set)
+		}
+	}
+	if len(*goroutines) == 0 {
+		fatalf("goroutines must be given")
+	}
+	maxGoroutines := 0
+	for _, n := range *goroutines {
+		if n < 1 {
+			fatalf("goroutines must be at least 1")
+		}
+		if int(n) > maxGoroutines {
+			maxGoroutines = int(n)
+		}
+	}
+	if len(*goroutines) > 1 {
+		for _, n := range *goroutines {
+			cfg.MatrixGoroutines = append(cfg.MatrixGoroutines, int(n))
+		}
+	}
+	if cfg.DryRun && (cfg.MatrixSets != nil || cfg.MatrixGoroutines != nil) {
+		fatalf("--dry-run plans a single set and goroutine count, not a matrix")
+	}
+
+	// A ramp is a duration run whose worker pool grows step by step.
+	if *ramp != "" {
+		if fs.Changed("duration") || fs.Changed("goroutines") {
+			fatalf("--ramp sets the duration and goroutines; don't also give --duration or --goroutines")
+		}
+		cfg.Ramp, err = parseRamp(*ramp)
+		if err != nil {
+			fatalf("error parsing ramp: %v", err)
+		}
+		for _, step := range cfg.Ramp {
+			cfg.Duration += step.Duration
+		}
+		maxGoroutines = cfg.Ramp[len(cfg.Ramp)-1].Goroutines
+		*goroutines = []uint{uint(maxGoroutines)}
+	}
+
+	// A duration replaces the download size entirely.
+	dlSize := int(*downloadSize) * MiB
+	if cfg.Duration > 0 {
+		if fs.Changed("download") {
+			fatalf("only one of --download and --duration may be given")
+		}
+		dlSize = 0
+	}
+
+	for _, set := range sets {
+		cfg.FileSetName = set
+		minSize := minObjectSize(cfg)
+
+		// A mix just stops drawing objects once it has enough.
+		if cfg.SetWeights == nil && dlSize%minSize != 0 {
+			fatalf("downloadMB (%d MiB) must be a multiple of the file set size (%d)", *downloadSize, minSize)
+		}
+
+		reqSize := meanObjectSize(cfg)
+		if *rangeSize > 0 {
+			cfg.RangeSizeBytes = int(*rangeSize) * KiB
+			if cfg.RangeSizeBytes > minSize {
+				fatalf("range-size (%d KiB) is larger than the file set size (%d)", *rangeSize, minSize)
+			}
+			if dlSize%cfg.RangeSizeBytes != 0 {
+				fatalf("downloadMB (%d MiB) must be a multiple of range-size (%d KiB)", *downloadSize, *rangeSize)
+			}
+			reqSize = cfg.RangeSizeBytes
+		}
+
+		dlCount := dlSize / reqSize
+		if cfg.Duration == 0 && maxGoroutines > dlCount {
+			fatalf("goroutines (%d) is greater than files to download (%d) from %s", maxGoroutines, dlCount, set)
+		}
+	}
+	cfg.FileSetName = sets[0]
+
+	if _, ok := metadataOps[cfg.Op]; !ok && cfg.Op != "get" {
+		fatalf("unknown op '%s'", cfg.Op)
+	}
+
+	cfg.ZipfS, err = parseDistribution(cfg.Distribution)
+	if err != nil {
+		fatalf("error parsing distribution: %v", err)
+	}
+
+	if cfg.WriteRatio < 0 || cfg.WriteRatio > 1 {
+		fatalf("write-ratio must be between 0 and 1")
+	}
+	if cfg.WriteRatio > 0 && path.Clean(cfg.ScratchPrefix) == path.Clean(cfg.Prefix) {
+		fatalf("scratch-prefix must differ from prefix")
+	}
+
+	if cfg.Rate < 0 || cfg.TargetMiBs < 0 {
+		fatalf("rate and target-mibs must not be negative")
+	}
+	if cfg.Rate > 0 && cfg.TargetMiBs > 0 {
+		fatalf("only one of --rate and --target-mibs may be given")
+	}
+
+	if cfg.Warmup < 0 || cfg.WarmupRequests < 0 {
+		fatalf("warmup and warmup-requests must not be negative")
+	}
+	if cfg.Warmup > 0 && cfg.WarmupRequests > 0 {
+		fatalf("only one of --warmup and --warmup-requests may be given")
+	}
+
+	if cfg.LatencyWindow < 0 || cfg.Heatmap < 0 || cfg.Worst < 0 {
+		fatalf("latency-window, heatmap and worst must not be negative")
+	}
+	if cfg.RawSample <= 0 || cfg.RawSample > 1 {
+		fatalf("sample-rate must be greater than 0 and at most 1")
+	}
+	if cfg.RawFormat != "json" && cfg.RawFormat != "parquet" {
+		fatalf("unknown raw format '%s'", cfg.RawFormat)
+	}
+	if cfg.StoreRequests && cfg.Store == "" {
+		fatalf("store-requests needs --store")
+	}
+
+	cfg.SinkDir, err = parseSink(*sink)
+	if err != nil {
+		fatalf("error with sink: %v", err)
+	}
+	if cfg.SinkDir == "" && (cfg.SinkDirect || cfg.SinkFsync) {
+		fatalf("--sink-direct and --sink-fsync need --sink disk:<dir>")
+	}
+
+	cfg.ManagerPartSize = int(*managerPartSize) * MiB
+	switch cfg.Client {
+	case "sdk":
+	case "manager":
+		if cfg.Op != "get" || *hedgeAfter > 0 || cfg.SinkDir != "" {
+			fatalf("--client manager only makes GETs, can't hedge them, and needs --sink discard")
+		}
+		if cfg.ManagerPartSize < 1 || cfg.ManagerParts < 1 {
+			fatalf("manager-part-size and manager-concurrency must be at least 1")
+		}
+	case "sdkv1":
+		if cfg.Op != "get" || cfg.WriteRatio > 0 || *hedgeAfter > 0 || cfg.SignatureVersion == "v2" {
+			fatalf("--client sdkv1 only makes GETs, can't hedge them, and signs with SigV4")
+		}
+	case "crt":
+		if !crtAvailable {
+			fatalf("--client crt needs s3skunk built with -tags crt, cgo and aws-c-s3 installed")
+		}
+		if cfg.Op != "get" || *hedgeAfter > 0 || cfg.SinkDir != "" || cfg.SignatureVersion == "v2" || *connAffinity {
+			fatalf("--client crt only makes GETs, can't hedge them, needs --sink discard, signs with SigV4 and manages its own connections")
+		}
+		if cfg.ManagerPartSize < 1 || cfg.CRTTargetGbps <= 0 {
+			fatalf("manager-part-size must be at least 1 and crt-target-gbps positive")
+		}
+	default:
+		fatalf("unknown client '%s'", cfg.Client)
+	}
+
+	cfg.Pricing, err = loadPricing(*pricingFile)
+	if err != nil {
+		fatalf("error reading pricing: %v", err)
+	}
+
+	cfg.ThinkTime, cfg.ThinkTimeExp, err = parseThinkTime(*thinkTime)
+	if err != nil {
+		fatalf("error parsing think-time: %v", err)
+	}
+	if cfg.ThinkTime > 0 && (cfg.Rate > 0 || cfg.TargetMiBs > 0) {
+		fatalf("--think-time is for closed-loop runs; it can't be combined with --rate or --target-mibs")
+	}
+
+	if cfg.GoMaxProcs < 0 {
+		fatalf("gomaxprocs must not be negative")
+	}
+	if *count < 1 {
+		fatalf("count must be at least 1")
+	}
+
+	cfg.ConnAffinity = *connAffinity
+	cfg.Count = int(*count)
+	cfg.DownloadSizeBytes = dlSize
+	cfg.EC2Instance = *instance
+	cfg.Goroutines = int((*goroutines)[0])
+	cfg.HedgeAfter = *hedgeAfter
+	cfg.MaxMemoryBytes = int64(*maxMemory) * MiB
+
+	// Interim reports are a ramp that never grows.
+	if cfg.ReportInterval > 0 {
+		if cfg.Ramp != nil || !fs.Changed("duration") {
+			fatalf("--report-interval needs --duration, and can't be combined with --ramp")
+		}
+		if cfg.MatrixGoroutines != nil {
+			fatalf("--report-interval can't be combined with a list of goroutines")
+		}
+		for left := cfg.Duration; left > 0; left -= cfg.ReportInterval {
+			d := cfg.ReportInterval
+			if left < d {
+				d = left
+			}
+			cfg.Ramp = append(cfg.Ramp, rampStep{Goroutines: cfg.Goroutines, Duration: d})
+		}
+	}
+
+	// Checked at the most goroutines of a list or ramp, not just the first.
+	if cfg.MaxMemoryBytes > 0 {
+		if est := estimatePeakBufferBytes(cfg, maxGoroutines); est > cfg.MaxMemoryBytes {
+			fatalf("estimated peak buffer usage (%d MiB) exceeds max-memory (%d MiB)", est/MiB, *maxMemory)
+		}
+	}
+
+	return cfg
+}
+
+// estimatePeakBufferBytes estimates how much memory in-flight downloads can
+// hold at once: goroutines × part size × parts in flight per goroutine.  Each
+// download is a single part streamed through io.Copy's buffer, or the disk
+// sink's, except with --client manager, whose parts are each streamed
+// through an io.Copy buffer.  --client crt buffers parts outside the Go
+// heap, so they aren't counted.
+func estimatePeakBufferBytes(cfg *myConfig, goroutines int) int64 {
+	partSize := int64(copyBufferSize)
+	if cfg.SinkDir != "" {
+		partSize = sinkBufferSize + directAlign
+	}
+	parts := int64(1)
+	if cfg.Client == "manager" {
+		parts = int64(cfg.ManagerParts)
+	}
+	return int64(goroutines) * partSize * parts
+}
+
+type Datapoint struct {
+	ID            resultID    `json:"_id"` // set when emitted
+	SchemaVersion int         // of this layout; see schema.go
+	requests      []rawRecord // with --store-requests, for the store only
+
+	// Fixed at run time by config
+	AddressingStyle  string // "virtual" or "path"
+	AMI              string
+	Client           string // how GETs were made: "sdk", "manager" for the transfer manager, "sdkv1" for aws-sdk-go v1, or "crt" for the AWS CRT
+	ConnAffinity     bool
+	CPUSet           string  // --cpuset, if any
+	CRTTargetGbps    float64 `json:",omitempty"` // --client crt only
+	DeleteBatchSize  int     // delete-batch only
+	DisableKeepAlive bool
+	Distribution     string  // "uniform" or "zipf:<s>"
+	DurationSecs     float64 // --duration; 0 for a fixed download size
+	EC2Instance      string
+	Endpoint         string // "" for the default AWS endpoint
+	EndpointAZ       string // AZ of a zonal VPC interface endpoint
+	FileSizeBytes    int    // for scatter plotting; the weighted mean for a mix
+	FileSizeLabel    string // for data series labeling
+	FreshClient      bool   // false if connections carried over from a previous iteration
+	GoMaxProcs       int    // effective GOMAXPROCS
+	Goroutines       int
+	HedgeAfterSecs   float64 // 0 if not hedging
+	IdleConnsPerHost int
+	InstanceAZ       string
+	InstanceRegion   string
+	Labels           map[string]string  `json:",omitempty"`
+	ListMaxKeys      int                // list only
+	MaxMemoryBytes   int64              // 0 if unlimited
+	Operation        string             // "download", "upload", "multipart", "split-download", or a metadata --op like "head"
+	PartConcurrency  int                // multipart, and downloads with --client manager
+	PartSizeBytes    int                // multipart, downloads with --client manager or crt, and copy of objects large enough for parts
+	PlannedSizeBytes int                // downloads: --download, which TotalSizeBytes falls short of if requests failed; 0 for duration runs
+	RangeSizeBytes   int                // 0 for whole-object GETs
+	RateRPS          float64            // --rate; 0 for closed loop
+	RawSampleRate    float64            // --sample-rate of --raw-output and --store-requests records; 0 if neither
+	Reassemble       bool               // split-download only
+	Seed             int64              // for reproducing the shuffle
+	SelectExpression string             // select only
+	SetWeights       map[string]float64 `json:",omitempty"` // for a mix of file sets
+	SignatureVersion string
+	Sink             string // "disk" with --sink disk:<dir>; empty if bodies are discarded
+	SinkDirect       bool
+	SinkFsync        bool
+	TargetMiBs       float64 // --target-mibs; 0 if not pacing
+	ThinkTimeExp     bool
+	ThinkTimeSecs    float64 // --think-time, or its mean if exponential
+	TotalSizeBytes   int
+	WriteRatio       float64 // --write-ratio; 0 for read only
+
+	// Calculated during execution
+	StartTime      time.Time
+	ElapsedSecs    float64
+	P50Latency     float64 // Req to response headers, or first record for select; open loop counts from scheduled arrival
+	P95Latency     float64
+	P99Latency     float64
+	Quantiles      map[string]float64 // --quantiles, keyed like "0.999"
+	RequestsPerSec float64            // completed reads (or list pages) per second
+	KeysPerSec     float64            // list and delete only
+	BytesReturned  int64              // select only; TotalSizeBytes is bytes scanned
+	ThroughputMiBs float64            // TotalSizeBytes / MiB / ElapsedSecs
+	Truncated      bool               // --max-duration hit
+	ShortReads     int                // downloads only: GET bodies cut off before their Content-Length
+	RampStep       int                // 1-based step of --ramp or --report-interval; the stats above cover it alone
+	CacheWindow    int                // cache only: 1-based window of repeated fetches, 0 for distinct keys
+
+	// Only on the summary of a --count run, whose throughput and latencies
+	// above are the means over its iterations
+	Iterations     int                      `json:",omitempty"`
+	IterationStats map[string]iterationStat `json:",omitempty"`
+
+	// Downloads only: throughput of GET bodies in each whole second
+	ThroughputMinMiBs float64
+	ThroughputMaxMiBs float64
+	ThroughputSeries  []float64 `json:",omitempty"` // with --throughput-series
+
+	// Downloads only: spread between the fastest and slowest goroutines
+	WorkerMinRequests   int64
+	WorkerMaxRequests   int64
+	WorkerMinMiBs       float64
+	WorkerMaxMiBs       float64
+	WorkerMinP50Latency float64
+	WorkerMaxP50Latency float64
+	Workers             []workerSummary `json:",omitempty"` // with --per-worker
+
+	// Exact, where the quantiles above are estimates
+	RequestCount int // requests with a recorded latency
+	MinLatency   float64
+	MaxLatency   float64
+	MeanLatency  float64
+	StdDev       float64 // sample standard deviation of latency
+
+	// Estimated from the digest, to tell uniformly slow from mostly fine with
+	// a few disasters
+	TrimmedMeanLatency float64 // mean between p5 and p95
+	MADLatency         float64 // median absolute deviation
+	Outliers           int     // over the median plus 3 scaled MADs
+
+	// With --worst, the slowest GETs, slowest first
+	WorstRequests []slowRequest `json:",omitempty"`
+
+	// Downloads only: request to the last byte of the body, where the
+	// latencies above stop at the response headers, i.e. time to first byte
+	FullP50Latency float64
+	FullP95Latency float64
+	FullP99Latency float64
+	FullQuantiles  map[string]float64 `json:",omitempty"`
+
+	// Open loop only: scheduled arrival to when a worker sent the request.
+	// The latencies above include it, so that a backlog isn't hidden by
+	// coordinated omission; subtract it for the service time alone.
+	QueueP50Delay float64
+	QueueP99Delay float64
+	QueueMaxDelay float64
+
+	// With --histogram, latencies to response headers in fixed buckets that
+	// merge across runs by adding counts
+	LatencyHistogram []histogramBucket `json:",omitempty"`
+
+	// With --latency-window, latency to response headers over the run
+	LatencyWindows []latencyWindow `json:",omitempty"`
+
+	// With --heatmap, latency to response headers by time slot and bucket
+	LatencyHeatmap []heatmapCell `json:",omitempty"`
+
+	// With --slo, whether this result met each objective
+	SLO *sloResult `json:",omitempty"`
+
+	// Per file set --quantiles, for a mix
+	SetQuantiles map[string]map[string]float64 `json:",omitempty"`
+
+	// Connection setup, from httptrace
+	ConnsEstablished  int
+	ConnsPerSec       float64 // ConnsEstablished / ElapsedSecs
+	MeanConnectSecs   float64 // TCP connect
+	MeanHandshakeSecs float64 // TLS handshake
+	ObjectsPerConn    float64 // Requests served per distinct connection
+
+	// Request phases, from httptrace.  DNS, connect and TLS are only for new
+	// connections; server is from the request being written to the first
+	// response byte, and TTFB from the request starting to that byte.
+	DNSP50Latency     float64
+	DNSP99Latency     float64
+	ConnectP50Latency float64
+	ConnectP99Latency float64
+	TLSP50Latency     float64
+	TLSP99Latency     float64
+	ServerP50Latency  float64
+	ServerP99Latency  float64
+	TTFBP50Latency    float64
+	TTFBP99Latency    float64
+
+	// Connection reuse, by request attempt; time to first byte is split by
+	// whether a request's first attempt got a reused connection
+	ReusedConnRequests int
+	NewConnRequests    int
+	ConnReuseRate      float64 // ReusedConnRequests out of all
+	ReusedConnP50TTFB  float64
+	NewConnP50TTFB     float64
+	NewConnP50Penalty  float64 // NewConnP50TTFB - ReusedConnP50TTFB, when there are both
+
+	// On Linux, TCP_INFO of each connection, sampled at the end and as they
+	// close; delivery rate is the kernel's recent estimate for each
+	ConnsSampled         int
+	ConnRTTP50Latency    float64 // smoothed RTT
+	ConnRTTP99Latency    float64
+	ConnRetransSegs      int
+	ConnRetransRate      float64 // of segments sent
+	ConnDeliveryMeanMiBs float64
+	ConnDeliveryMaxMiBs  float64
+
+	// Downloads on Linux only: CPU use while in flight.  Process figures are
+	// percent of one CPU, like top; system ones are of all CPUs.  Peaks are
+	// over a second.
+	ProcessCPUPct     float64
+	ProcessCPUPeakPct float64
+	ProcessUserPct    float64
+	ProcessSysPct     float64
+	SystemUserPct     float64
+	SystemSysPct      float64
+	SystemIOWaitPct   float64
+	SystemBusyPeakPct float64
+
+	// Downloads only: Go allocation and garbage collection while in flight;
+	// the peak heap is sampled once a second
+	AllocBytes       uint64
+	Mallocs          uint64
+	NumGC            uint32
+	GCPauseTotalSecs float64
+	GCPauseMaxSecs   float64 // of the last 256 collections at most
+	PeakHeapBytes    uint64
+
+	// Downloads on Linux only: system-wide NIC counters, over all interfaces
+	// but loopback, and TCP retransmits.  NICRxBytes above TotalSizeBytes is
+	// protocol overhead, retransmission or other traffic.
+	NICRxBytes     uint64
+	NICRxPackets   uint64
+	NICRxDrops     uint64
+	NICTxBytes     uint64
+	NICTxPackets   uint64
+	NICTxDrops     uint64
+	TCPRetransSegs uint64
+	TCPRetransRate float64 // of segments sent
+
+	// Downloads on Linux only: ethtool's *_allowance_exceeded counters, such
+	// as ENA's bw_in_allowance_exceeded and pps_allowance_exceeded on EC2,
+	// keyed by interface and counter, like "eth0/bw_in_allowance_exceeded".
+	// Any above zero mean the instance's network allowances, not S3, limited
+	// the run.
+	NICAllowanceExceeded map[string]uint64 `json:",omitempty"`
+
+	// Downloads only: estimated from --pricing
+	RequestCostUSD   float64
+	TransferCostUSD  float64
+	EstimatedCostUSD float64
+
+	// Request hedging
+	HedgedRequests int
+	HedgeWinRate   float64 // Fraction of hedged requests won by the hedge
+
+	// Errors
+	Timeouts     int            // GetObjects that hit --request-timeout and were skipped, or unresolved consistency probes
+	Requests     int            // downloads only: reads and writes made, whether or not they failed
+	Errors       int            // failed requests, after SDK retries, including timeouts
+	ErrorRate    float64        // Errors out of all requests made
+	ErrorCounts  map[string]int `json:",omitempty"` // Errors by category: throttle, timeout, 5xx, 4xx, connection, other
+	StatusCounts map[int]int    `json:",omitempty"` // HTTP status of every attempt, including SDK retries
+	Retries      int            // downloads only: attempts the SDK retried
+	SlowDowns    int            // 503 SlowDown responses, retried or not
+	BackoffSecs  float64        // total SDK backoff before retries, over all workers
+
+	// Consistency probes; the latencies above are from PUT done to new content seen
+	StaleReads int
+
+	// Mixed workloads; the latencies above are then for reads only
+	Writes          int
+	WriteSizeBytes  int // bytes PUT; TotalSizeBytes and ThroughputMiBs are reads only
+	WriteP50Latency float64
+	WriteP95Latency float64
+	WriteP99Latency float64
+	WriteQuantiles  map[string]float64 `json:",omitempty"`
+}
+
+// baseDatapoint fills in the fields of a datapoint that come straight from
+// the configuration.
+func baseDatapoint(cfg *myConfig) Datapoint {
+	addressing := "virtual"
+	if cfg.PathStyle {
+		addressing = "path"
+	}
+	var sinkName string
+	if cfg.SinkDir != "" {
+		sinkName = "disk"
+	}
+	var rawSample float64
+	if cfg.RawOutput != "" || cfg.StoreRequests {
+		rawSample = cfg.RawSample
+	}
+	// Only downloads have a choice of client.
+	client := "sdk"
+	if cfg.Client != "" {
+		client = cfg.Client
+	}
+	var partSize, partConcurrency int
+	var crtTargetGbps float64
+	switch cfg.Client {
+	case "manager":
+		partSize, partConcurrency = cfg.ManagerPartSize, cfg.ManagerParts
+	case "crt":
+		partSize, crtTargetGbps = cfg.ManagerPartSize, cfg.CRTTargetGbps
+	}
+
+	return Datapoint{
+		AddressingStyle:  addressing,
+		AMI:              cfg.AMI,
+		Client:           client,
+		ConnAffinity:     cfg.ConnAffinity,
+		CPUSet:           cfg.CPUSet,
+		CRTTargetGbps:    crtTargetGbps,
+		DisableKeepAlive: cfg.DisableKeepAlive,
+		Distribution:     cfg.Distribution,
+		DurationSecs:     cfg.Duration.Seconds(),
+		EC2Instance:      cfg.EC2Instance,
+		Endpoint:         cfg.Endpoint,
+		EndpointAZ:       cfg.EndpointAZ,
+		FileSizeBytes:    meanObjectSize(cfg),
+		FileSizeLabel:    cfg.FileSetName,
+		FreshClient:      cfg.FreshClient,
+		GoMaxProcs:       runtime.GOMAXPROCS(0),
+		Goroutines:       cfg.Goroutines,
+		HedgeAfterSecs:   cfg.HedgeAfter.Seconds(),
+		IdleConnsPerHost: cfg.IdleConnsPerHost,
+		InstanceAZ:       cfg.InstanceAZ,
+		InstanceRegion:   cfg.InstanceRegion,
+		Labels:           cfg.Labels,
+		MaxMemoryBytes:   cfg.MaxMemoryBytes,
+		PartConcurrency:  partConcurrency,
+		PartSizeBytes:    partSize,
+		RangeSizeBytes:   cfg.RangeSizeBytes,
+		RateRPS:          cfg.Rate,
+		RawSampleRate:    rawSample,
+		Reassemble:       cfg.Reassemble,
+		Seed:             cfg.Seed,
+		SetWeights:       cfg.SetWeights,
+		SignatureVersion: cfg.SignatureVersion,
+		Sink:             sinkName,
+		SinkDirect:       cfg.SinkDirect,
+		SinkFsync:        cfg.SinkFsync,
+		TargetMiBs:       cfg.TargetMiBs,
+		ThinkTimeExp:     cfg.ThinkTimeExp,
+		ThinkTimeSecs:    cfg.ThinkTime.Seconds(),
+		WriteRatio:       cfg.WriteRatio,
+	}
+}
+
+func (dp *Datapoint) setLatencies(td *latencyDigest, quantiles []float64) {
+	dp.P50Latency = quantile(td.TDigest, 0.50)
+	dp.P95Latency = quantile(td.TDigest, 0.95)
+	dp.P99Latency = quantile(td.TDigest, 0.99)
+	dp.Quantiles = quantileMap(td.TDigest, quantiles)
+	dp.MinLatency = td.min
+	dp.MaxLatency = td.max
+	dp.MeanLatency = td.mean
+	dp.StdDev = td.stdDev()
+	dp.RequestCount = td.count
+	dp.TrimmedMeanLatency = td.trimmedMean(0.05)
+	dp.MADLatency = td.mad()
+	dp.Outliers = td.outliers()
+}
+
+func (dp *Datapoint) setFullLatencies(td *latencyDigest, quantiles []float64) {
+	dp.FullP50Latency = quantile(td.TDigest, 0.50)
+	dp.FullP95Latency = quantile(td.TDigest, 0.95)
+	dp.FullP99Latency = quantile(td.TDigest, 0.99)
+	dp.FullQuantiles = quantileMap(td.TDigest, quantiles)
+}
+
+func (dp *Datapoint) setWriteLatencies(td *latencyDigest, quantiles []float64) {
+	dp.WriteP50Latency = quantile(td.TDigest, 0.50)
+	dp.WriteP95Latency = quantile(td.TDigest, 0.95)
+	dp.WriteP99Latency = quantile(td.TDigest, 0.99)
+	dp.WriteQuantiles = quantileMap(td.TDigest, quantiles)
+}
+
+func (dp *Datapoint) setQueueDelays(td *latencyDigest) {
+	dp.QueueP50Delay = quantile(td.TDigest, 0.50)
+	dp.QueueP99Delay = quantile(td.TDigest, 0.99)
+	dp.QueueMaxDelay = td.max
+}
+
+func (dp *Datapoint) setConnStats(ct *connTracker, elapsedSec float64) {
+	dp.ConnsEstablished = ct.ConnsEstablished()
+	dp.ConnsPerSec = float64(ct.ConnsEstablished()) / elapsedSec
+	dp.MeanConnectSecs = ct.MeanConnectSecs()
+	dp.MeanHandshakeSecs = ct.MeanHandshakeSecs()
+	dp.ObjectsPerConn = ct.ObjectsPerConn()
+	ct.setPhases(dp)
+	ct.setReuse(dp)
+	tcpConns.setTCPInfo(dp)
+}
+
+// listS3Files lists every object of the run's file set, or of each set in a
+// mix.
+func listS3Files(cfg *myConfig, s3Client *s3.Client) ([]string, error) {
+	var files []string
+	for _, name := range setNames(cfg) {
+		setFiles, err := listSetFiles(cfg, s3Client, name)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, setFiles...)
+	}
+	return files, nil
+}
+
+func listSetFiles(cfg *myConfig, s3Client *s3.Client, set string) ([]string, error) {
+	files := make([]string, 0, 1024)
+
+	req := &s3.ListObjectsV2Input{
+		Bucket: aws.String(cfg.Bucket),
+		Prefix: aws.String(path.Join(cfg.Prefix, set)),
+	}
+
+	// listobjects from S3
+	p := s3.NewListObjectsV2Paginator(s3Client, req)
+
+	// Iterate through the S3 object pages, printing each object returned.
+	var i int
+	for p.HasMorePages() {
+		i++
+		page, err := p.NextPage(context.Background())
+		if err != nil {
+			fatalf("failed to get page %v, %v", i, err)
+		}
+
+		// objects found
+		for _, obj := range page.Contents {
+			files = append(files, *obj.Key)
+			if obj.ETag != nil {
+				listedETags.Store(*obj.Key, *obj.ETag)
+			}
+		}
+	}
+
+	return files, nil
+}
+
+// requestSize is how many bytes each GET fetches: a whole object, or just
+// --range-size of it.
+func requestSize(cfg *myConfig) int {
+	if cfg.RangeSizeBytes > 0 {
+		return cfg.RangeSizeBytes
+	}
+	return meanObjectSize(cfg)
+}
+
+func buildDownloadList(cfg *myConfig, s3Client *s3.Client, rng *rand.Rand) ([]string, error) {
+	if cfg.SetWeights != nil {
+		return buildMixedDownloadList(cfg, s3Client, rng)
+	}
+
+	// Download file candidates from s3
+	fileList, err := listS3Files(cfg, s3Client)
+	if err != nil {
+		return nil, err
+	}
+
+	// shuffle result
+	rng.Shuffle(len(fileList), func(i, j int) {
+		fileList[i], fileList[j] = fileList[j], fileList[i]
+	})
+
+	if len(fileList) == 0 {
+		return nil, fmt.Errorf("no S3 files found for file set %s under s3://%s/%s (see the list-sets command)", cfg.FileSetName, cfg.Bucket, cfg.Prefix)
+	}
+
+	// Duration runs cycle through a set-sized list for as long as needed.
+	numFilesNeeded := len(fileList)
+	if cfg.Duration == 0 {
+		numFilesNeeded = cfg.DownloadSizeBytes / requestSize(cfg)
+	}
+	if numFilesNeeded == 0 {
+		fatalf("config results in zero files needed for download; WTF")
+	}
+
+	files := make([]string, 0, numFilesNeeded)
+	pick := newKeyPicker(cfg, rng, len(fileList))
+	for len(files) < numFilesNeeded {
+		files = append(files, fileList[pick()])
+	}
+	return files, nil
+}
+
+// runState is shared by all the download workers in a single run.
+type runState struct {
+	cfg         *myConfig
+	hedgeClient *s3.Client // only set when hedging
+	crt         *crtClient // only with --client crt
+	hedges      *hedgeStats
+	latency     chan float64 // to response headers
+	fullLatency *stepDigest  // to the last byte of the body, for GETs
+	queueDelay  *stepDigest  // scheduled arrival to send; only open loop
+	errors      *errorStats
+	tracker     *connTracker
+	raw         *rawRecorder      // only with --raw-output
+	hist        *latencyHistogram // only with --histogram
+	throughput  *throughputSampler
+	cpu         *cpuSampler // nil where CPU times can't be read
+	mem         *memSampler
+	net         *netDelta        // nil where NIC counters can't be read
+	windows     *windowedLatency // only with --latency-window
+	heatmap     *latencyHeatmap  // only with --heatmap
+	workers     []*workerStats   // by worker; nil until it starts
+	worst       *worstRequests   // only with --worst
+	tui         *tuiDisplay      // only with --tui
+
+	writeLatency chan float64 // PUTs, with --write-ratio
+	setLatency   *setDigests  // only for a mix of file sets
+
+	bytesMoved int64 // atomic; GET body bytes read, used for duration runs, or when truncated or timing out
+	bytesPut   int64 // atomic; --write-ratio PUT bytes, kept out of bytesMoved
+	streamed   int64 // atomic; GET body bytes as they arrive, for per-second throughput
+	completed  int64 // atomic; reads, not counting --write-ratio PUTs
+	requests   int64 // atomic; reads and writes, whether or not they failed
+	shortReads int64 // atomic; GET bodies that ended before their Content-Length
+	timeouts   int64 // atomic
+	writes     int64 // atomic
+}
+
+// quantile reads q out of a digest, or 0 if it's empty; the digest's NaN
+// can't be encoded as JSON.
+func quantile(td *tdigest.TDigest, q float64) float64 {
+	if td.Count() == 0 {
+		return 0
+	}
+	return td.Quantile(q)
+}
+
+// quantileMap reads the requested quantiles out of a digest.
+func quantileMap(td *tdigest.TDigest, qs []float64) map[string]float64 {
+	m := make(map[string]float64, len(qs))
+	for _, q := range qs {
+		m[strconv.FormatFloat(q, 'f', -1, 64)] = quantile(td, q)
+	}
+	return m
+}
+
+// workItem is one object to fetch, or with put set, to upload.  In
+// open-loop mode, arrival is when the request was scheduled, so latency
+// includes any time spent queued behind busy workers.
+type workItem struct {
+	key     string
+	arrival time.Time
+	put     bool
+}
+
+func downloader(ctx context.Context, rs *runState, ws *workerStats, s3Client *s3.Client, v1Client *s3v1.S3, rng *rand.Rand, putBuf []byte, sink *bodySink, work chan workItem) {
+	var thinking bool
+	for job := range work {
+		// Think between requests, not before the first.
+		if thinking {
+			rs.think(ctx, rng)
+		}
+		thinking = rs.cfg.ThinkTime > 0
+		start := time.Now()
+		if !job.arrival.IsZero() {
+			rs.queueDelay.Add(start.Sub(job.arrival).Seconds())
+			start = job.arrival
+		}
+		expInFlight.Add(1)
+		rs.fetch(ctx, ws, s3Client, v1Client, rng, putBuf, sink, job, start)
+		expInFlight.Add(-1)
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// fetch makes one request of a downloader: a GET, a metadata op, or with
+// --write-ratio, a PUT.  GETs go through v1Client instead with --client
+// sdkv1.  It returns early, with nothing recorded, if ctx ends.
+func (rs *runState) fetch(ctx context.Context, ws *workerStats, s3Client *s3.Client, v1Client *s3v1.S3, rng *rand.Rand, putBuf []byte, sink *bodySink, job workItem, start time.Time) {
+	f := job.key
+
+	// With --otlp-endpoint, a request's span starts when it was due, so
+	// open-loop queueing shows in traces.
+	name := "GetObject"
+	if job.put {
+		name = "PutObject"
+	} else if _, ok := metadataOps[rs.cfg.Op]; ok {
+		name = rs.cfg.Op
+	}
+	ctx, span := tracer.Start(ctx, name, trace.WithTimestamp(start),
+		trace.WithAttributes(attribute.String("s3.bucket", rs.cfg.Bucket), attribute.String("s3.key", f)))
+	defer span.End()
+
+	if job.put {
+		rs.putObject(ctx, s3Client, f, putBuf, start)
+		return
+	}
+	req := &s3.GetObjectInput{
+		Bucket: aws.String(rs.cfg.Bucket),
+		Key:    aws.String(f),
+	}
+	if rs.cfg.RangeSizeBytes > 0 {
+		objSize := fileSets[keySet(rs.cfg, f)].Size
+		off := rng.Intn(objSize - rs.cfg.RangeSizeBytes + 1)
+		req.Range = aws.String(fmt.Sprintf("bytes=%d-%d", off, off+rs.cfg.RangeSizeBytes-1))
+	}
+	reqCtx := ctx
+	timeoutCancel := func() {}
+	if rs.cfg.RequestTimeout > 0 {
+		reqCtx, timeoutCancel = context.WithTimeout(ctx, rs.cfg.RequestTimeout)
+	}
+	// The Downloader's part GETs, and a hedge, each need a trace of their
+	// own, started when they're sent.
+	hedgeCtx := withConnTracker(reqCtx, rs.tracker)
+	if rs.cfg.Client == "manager" {
+		reqCtx = hedgeCtx
+	} else {
+		reqCtx = httptrace.WithClientTrace(reqCtx, rs.tracker.clientTrace())
+	}
+
+	switch rs.cfg.Client {
+	case "manager":
+		rs.managedGet(ctx, reqCtx, span, ws, s3Client, req, start)
+		timeoutCancel()
+		return
+	case "sdkv1":
+		rs.getV1(ctx, reqCtx, span, ws, v1Client, req, sink, start)
+		timeoutCancel()
+		return
+	case "crt":
+		rs.crtGet(ctx, reqCtx, span, ws, rs.crt, req, start)
+		timeoutCancel()
+		return
+	}
+
+	if op, ok := metadataOps[rs.cfg.Op]; ok {
+		err := op(reqCtx, s3Client, rs.cfg.Bucket, f)
+		expRequests.Add(1)
+		statsd.Count("requests", 1, "")
+		atomic.AddInt64(&rs.requests, 1)
+		if err != nil {
+			timedOut := errors.Is(reqCtx.Err(), context.DeadlineExceeded)
+			timeoutCancel()
+			if ctx.Err() != nil {
+				return
+			}
+			spanError(span, err)
+			if timedOut {
+				rs.recordTimeout(f)
+				return
+			}
+			rs.recordError(fmt.Sprintf("on %s of %s", rs.cfg.Op, f), err)
+			return
+		}
+		timeoutCancel()
+		ws.Add(rs.recordLatency(f, start), 0)
+		return
+	}
+
+	var resp *s3.GetObjectOutput
+	var err error
+	cancel := func() {}
+	if rs.hedgeClient != nil {
+		resp, cancel, err = hedgedGetObject(reqCtx, hedgeCtx, s3Client, rs.hedgeClient, req, rs.cfg.HedgeAfter, rs.hedges)
+	} else {
+		resp, err = s3Client.GetObject(reqCtx, req)
+	}
+	expRequests.Add(1)
+	statsd.Count("requests", 1, "")
+	atomic.AddInt64(&rs.requests, 1)
+	if err != nil {
+		rs.getFailed(ctx, reqCtx, span, f, start, err)
+		cancel()
+		timeoutCancel()
+		return
+	}
+	setResponseIDs(span, resp.ResultMetadata)
+	secs := rs.recordLatency(f, start)
+	if rs.worst != nil {
+		rs.worst.Add(f, secs, resp.ResultMetadata)
+	}
+	rec := rawRecord{Key: f, Start: start, TTFBSecs: time.Since(start).Seconds()}
+	n, err := sink.write(&countingReader{r: resp.Body, n: &rs.streamed})
+	rs.readDone(ctx, reqCtx, span, ws, secs, n, resp.ContentLength, rec, &resp.ResultMetadata, err)
+	resp.Body.Close()
+	cancel()
+	timeoutCancel()
+}
+
+// getFailed records a GET of key, made by any --client, that failed before
+// there was a body to read.  Running out of --max-duration isn't an error;
+// it just stops.
+func (rs *runState) getFailed(ctx, reqCtx context.Context, span trace.Span, key string, start time.Time, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	spanError(span, err)
+	if errors.Is(reqCtx.Err(), context.DeadlineExceeded) {
+		rs.recordTimeout(key)
+		if rs.raw != nil {
+			rec := rawRecord{Key: key, Start: start, TTFBSecs: time.Since(start).Seconds(), TimedOut: true}
+			if err := rs.raw.record(rec, nil); err != nil {
+				fatalf("error writing raw output: %v", err)
+			}
+		}
+		return
+	}
+	rs.recordError("downloading "+key, err)
+}
+
+// readDone records a GET, made by any --client, whose body has been read:
+// n bytes of it, out of contentLength if that's known (-1 if not), with
+// err from reading.  rec is the raw record so far, with its key, start and
+// time to first byte; metadata fills in its attempts and status, if there's
+// a response to have them from.
+func (rs *runState) readDone(ctx, reqCtx context.Context, span trace.Span, ws *workerStats, secs float64, n, contentLength int64, rec rawRecord, metadata *middleware.Metadata, err error) {
+	expBytesRead.Add(n)
+	statsd.Count("bytes_read", n, "")
+	atomic.AddInt64(&rs.bytesMoved, n)
+	if contentLength >= 0 && n < contentLength && ctx.Err() == nil {
+		atomic.AddInt64(&rs.shortReads, 1)
+	}
+	ws.Add(secs, n)
+	span.SetAttributes(attribute.Int64("s3.bytes", n))
+
+	rec.SizeBytes = n
+	switch {
+	case err == nil:
+		rec.TotalSecs = time.Since(rec.Start).Seconds()
+		rs.fullLatency.Add(rec.TotalSecs)
+	case ctx.Err() != nil:
+	case errors.Is(reqCtx.Err(), context.DeadlineExceeded):
+		spanError(span, err)
+		rs.recordTimeout(rec.Key)
+		rec.TimedOut = true
+	default:
+		spanError(span, err)
+		rs.recordError("reading "+rec.Key, err)
+	}
+	if rs.raw != nil && ctx.Err() == nil {
+		if err := rs.raw.record(rec, metadata); err != nil {
+			fatalf("error writing raw output: %v", err)
+		}
+	}
+}
+
+// recordLatency records a completed request for key that started at start,
+// and returns its latency.
+func (rs *runState) recordLatency(key string, start time.Time) float64 {
+	secs := time.Since(start).Seconds()
+	rs.latency <- secs
+	promLatency.Observe(secs)
+	statsd.Timing("request.latency", secs)
+	if rs.hist != nil {
+		rs.hist.Add(secs)
+	}
+	if rs.windows != nil {
+		rs.windows.Add(secs)
+	}
+	if rs.heatmap != nil {
+		rs.heatmap.Add(secs)
+	}
+	if rs.tui != nil {
+		rs.tui.Add(secs)
+	}
+	dash.Add(secs)
+	if rs.setLatency != nil {
+		rs.setLatency.Add(keySet(rs.cfg, key), secs)
+	}
+	atomic.AddInt64(&rs.completed, 1)
+	return secs
+}
+
+// putObject uploads buf as key, for the write share of a mixed workload.
+func (rs *runState) putObject(ctx context.Context, s3Client *s3.Client, key string, buf []byte, start time.Time) {
+	reqCtx := httptrace.WithClientTrace(ctx, rs.tracker.clientTrace())
+	_, err := s3Client.PutObject(reqCtx, &s3.PutObjectInput{
+		Bucket:        aws.String(rs.cfg.Bucket),
+		Key:           aws.String(key),
+		Body:          bytes.NewReader(buf),
+		ContentLength: int64(len(buf)),
+	})
+	expRequests.Add(1)
+	statsd.Count("requests", 1, "")
+	atomic.AddInt64(&rs.requests, 1)
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		rs.recordError("uploading "+key, err)
+		return
+	}
+	rs.writeLatency <- time.Since(start).Seconds()
+	expBytesWritten.Add(int64(len(buf)))
+	statsd.Count("bytes_written", int64(len(buf)), "")
+	atomic.AddInt64(&rs.bytesPut, int64(len(buf)))
+	atomic.AddInt64(&rs.writes, 1)
+}
+
+// recordError counts a failed request, described by what, and carries on
+// without it, unless --fail-on-error.  Only the first error of each
+// category is a warning, so a throttled run doesn't drown in them.
+func (rs *runState) recordError(what string, err error) {
+	expErrors.Add(1)
+	if rs.cfg.FailOnError {
+		fatalf("error %s: %v", what, err)
+	}
+	category, first := rs.errors.Add(err)
+	statsd.Count("request.errors", 1, "category:"+category)
+	if first {
+		warnf("error %s (%s; further ones are debug messages): %v", what, category, err)
+	} else {
+		debugf("error %s (%s): %v", what, category, err)
+	}
+}
+
+// recordTimeout counts a GetObject that ran past --request-timeout.  The
+// object is skipped rather than failing the run.
+func (rs *runState) recordTimeout(key string) {
+	expTimeouts.Add(1)
+	statsd.Count("request.timeouts", 1, "")
+	atomic.AddInt64(&rs.timeouts, 1)
+	if _, first := rs.errors.Add(context.DeadlineExceeded); first {
+		warnf("request for %s timed out after %v (further ones are debug messages)", key, rs.cfg.RequestTimeout)
+	} else {
+		debugf("request for %s timed out after %v", key, rs.cfg.RequestTimeout)
+	}
+}
+
+// benchClients are the S3 clients used for one or more iterations.
+type benchClients struct {
+	s3      *s3.Client
+	workers []*s3.Client // one per goroutine
+	v1      []*s3v1.S3   // one per goroutine; nil unless --client sdkv1
+	crt     *crtClient   // shared by all goroutines; nil unless --client crt
+	hedge   *s3.Client   // nil unless hedging
+}
+
+func newBenchClients(cfg *myConfig) *benchClients {
+	s3Client, err := configS3(cfg)
+	if err != nil {
+		fatalf("error configuring S3: %v", err)
+	}
+	bc := &benchClients{s3: s3Client}
+
+	// With connection affinity, each worker gets its own client (and thus
+	// its own connection pool); otherwise they all share one.
+	bc.workers = make([]*s3.Client, cfg.Goroutines)
+	for i := range bc.workers {
+		if !cfg.ConnAffinity {
+			bc.workers[i] = s3Client
+			continue
+		}
+		bc.workers[i], err = configS3(cfg)
+		if err != nil {
+			fatalf("error configuring S3: %v", err)
+		}
+	}
+
+	// Likewise for v1 clients.
+	if cfg.Client == "sdkv1" {
+		bc.v1 = make([]*s3v1.S3, cfg.Goroutines)
+		for i := range bc.v1 {
+			if i > 0 && !cfg.ConnAffinity {
+				bc.v1[i] = bc.v1[0]
+				continue
+			}
+			bc.v1[i], err = configS3V1(cfg)
+			if err != nil {
+				fatalf("error configuring S3 with aws-sdk-go v1: %v", err)
+			}
+		}
+	}
+
+	// The CRT client runs its own connection pool for every worker.
+	if cfg.Client == "crt" {
+		bc.crt, err = newCRTClient(cfg)
+		if err != nil {
+			fatalf("error configuring the AWS CRT's S3 client: %v", err)
+		}
+	}
+
+	// Hedged requests go through a client without keep-alive so each hedge
+	// gets a fresh connection.
+	if cfg.HedgeAfter > 0 {
+		hedgeCfg := *cfg
+		hedgeCfg.DisableKeepAlive = true
+		hedgeCfg.ConnAffinity = false
+		bc.hedge, err = configS3(&hedgeCfg)
+		if err != nil {
+			fatalf("error configuring S3: %v", err)
+		}
+	}
+
+	return bc
+}
+
+// run downloads the planned objects once, after any warmup, and emits a
+// datapoint, which it also returns.  A ramp emits one per step and returns
+// one of only its throughput over them all.
+func run(ctx context.Context, cfg *myConfig, bc *benchClients, rng *rand.Rand) Datapoint {
+	if cfg.Warmup > 0 || cfg.WarmupRequests > 0 {
+		warmup(ctx, cfg, bc)
+	}
+	datapoint := measure(ctx, cfg, bc, rng)
+	if cfg.Ramp == nil {
+		emitDatapoint(cfg, datapoint)
+	}
+	return datapoint
+}
+
+// measure is run without the warmup or emitting the result.
+func measure(ctx context.Context, cfg *myConfig, bc *benchClients, rng *rand.Rand) Datapoint {
+	// Build a list of files from fileset equal to total download size
+	downloadList, err := buildDownloadList(cfg, bc.s3, rng)
+	if err != nil {
+		fatalf("error building file list: %v", err)
+	}
+	debugf("downloading %d files from %s with %d goroutines", len(downloadList), cfg.FileSetName, cfg.Goroutines)
+
+	// Let channels be buffered by goroutine count, but not ridiculously to
+	// avoid blowing up memory
+	chanSize := cfg.Goroutines
+	if chanSize > 1024 {
+		chanSize = 1024
+	}
+
+	// With --rate, requests arrive on a Poisson schedule from their own
+	// PRNG, seeded from the main one, regardless of how fast they complete.
+	// With --target-mibs they arrive evenly spaced at whatever rate carries
+	// that throughput.
+	var gap func() time.Duration
+	switch {
+	case cfg.Rate > 0:
+		arrivals := rand.New(rand.NewSource(rng.Int63()))
+		gap = func() time.Duration {
+			return time.Duration(arrivals.ExpFloat64() / cfg.Rate * float64(time.Second))
+		}
+	case cfg.TargetMiBs > 0:
+		interval := time.Duration(float64(requestSize(cfg)) / (cfg.TargetMiBs * MiB) * float64(time.Second))
+		gap = func() time.Duration { return interval }
+	}
+
+	// With --write-ratio, that share of operations become PUTs of new keys
+	// under a scratch prefix, chosen by another PRNG seeded from the main one.
+	var writes *rand.Rand
+	runPrefix := path.Join(cfg.ScratchPrefix, strconv.FormatInt(time.Now().UnixNano(), 10))
+	if cfg.WriteRatio > 0 {
+		writes = rand.New(rand.NewSource(rng.Int63()))
+	}
+
+	// Use goroutine to pump file list into a channel.  With --duration, keep
+	// cycling through the list until the timer fires; requests already
+	// handed out still complete.
+	work := make(chan workItem, chanSize)
+	go func() {
+		defer close(work)
+		var expired <-chan time.Time
+		if cfg.Duration > 0 {
+			t := time.NewTimer(cfg.Duration)
+			defer t.Stop()
+			expired = t.C
+		}
+		next := time.Now()
+		var puts int
+		for {
+			for _, f := range downloadList {
+				job := workItem{key: f}
+				if writes != nil && writes.Float64() < cfg.WriteRatio {
+					// Not the set's name, which for a --mix is the whole mix.
+					job = workItem{key: seedKey(runPrefix, "writes", puts), put: true}
+					puts++
+				}
+				if gap != nil {
+					next = next.Add(gap())
+					job.arrival = next
+					select {
+					case <-time.After(time.Until(next)):
+					case <-ctx.Done():
+						return
+					case <-expired:
+						return
+					}
+				}
+				select {
+				case work <- job:
+				case <-ctx.Done():
+					return
+				case <-expired:
+					return
+				}
+			}
+			if cfg.Duration == 0 {
+				return
+			}
+		}
+	}()
+
+	// Collect latencies
+	latency := make(chan float64, chanSize)
+	td := newStepDigest()
+	latencyDone := td.collect(latency)
+
+	// And write latencies, kept apart from reads
+	writeLatency := make(chan float64, chanSize)
+	wtd := newStepDigest()
+	writeLatencyDone := wtd.collect(writeLatency)
+
+	rs := &runState{
+		cfg:          cfg,
+		hedgeClient:  bc.hedge,
+		crt:          bc.crt,
+		fullLatency:  newStepDigest(),
+		queueDelay:   newStepDigest(),
+		errors:       newErrorStats(),
+		hedges:       &hedgeStats{},
+		latency:      latency,
+		tracker:      newConnTracker(),
+		writeLatency: writeLatency,
+	}
+	if cfg.SetWeights != nil {
+		rs.setLatency = newSetDigests()
+	}
+	if cfg.Histogram {
+		rs.hist = newLatencyHistogram()
+	}
+	if cfg.RawOutput != "" || cfg.StoreRequests {
+		rs.raw, err = newRawRecorder(cfg)
+		if err != nil {
+			fatalf("error opening raw output: %v", err)
+		}
+		defer func() {
+			if err := rs.raw.Close(); err != nil {
+				fatalf("error writing raw output: %v", err)
+			}
+		}()
+	}
+
+	if cfg.WriteRatio > 0 && !cfg.KeepScratch {
+		defer func() {
+			n, err := deletePrefix(bc.s3, cfg.Bucket, runPrefix+"/")
+			if err != nil {
+				fatalf("error cleaning up s3://%s/%s: %v", cfg.Bucket, runPrefix, err)
+			}
+			debugf("deleted %d written objects", n)
+		}()
+	}
+
+	// Each worker uploads its own random buffer, filled before timing starts.
+	putBufs := make([][]byte, cfg.Goroutines)
+	if cfg.WriteRatio > 0 {
+		for i := range putBufs {
+			putBufs[i] = make([]byte, requestSize(cfg))
+			rand.New(rand.NewSource(cfg.Seed + int64(i))).Read(putBufs[i])
+		}
+	}
+
+	// Record start time just before goroutines start, and don't count
+	// statuses from listing the file set.
+	attemptStatuses.take()
+	sdkRetries.take()
+	tcpConns.reset()
+	startTime := time.Now()
+	rs.throughput = startThroughputSampler(&rs.streamed)
+	rs.cpu = startCPUSampler()
+	rs.mem = startMemSampler()
+	rs.net = startNetDelta()
+	if cfg.LatencyWindow > 0 {
+		rs.windows = newWindowedLatency(cfg.LatencyWindow)
+	}
+	if cfg.Heatmap > 0 {
+		rs.heatmap = newLatencyHeatmap(cfg.Heatmap)
+	}
+	if cfg.Worst > 0 {
+		rs.worst = newWorstRequests(cfg.Worst)
+	}
+	if cfg.TUI {
+		rs.tui = startTUI(rs)
+	}
+
+	// Start worker goroutines to download files from channel.  Don't want to
+	// synchronize their start because we won't do that in practice in ADL.
+	var wg sync.WaitGroup
+	sinks := make([]*bodySink, cfg.Goroutines)
+	rs.workers = make([]*workerStats, cfg.Goroutines)
+	startWorker := func(i int) {
+		wg.Add(1)
+		sinks[i] = newBodySink(cfg, i)
+		rs.workers[i] = newWorkerStats()
+		// With ranges or exponential think times, each worker gets its own
+		// PRNG, seeded from the main one so a run is repeatable from --seed.
+		var wrng *rand.Rand
+		if cfg.RangeSizeBytes > 0 || cfg.ThinkTimeExp {
+			wrng = rand.New(rand.NewSource(rng.Int63()))
+		}
+		var v1 *s3v1.S3
+		if bc.v1 != nil {
+			v1 = bc.v1[i]
+		}
+		go func(ws *workerStats, c *s3.Client, v1 *s3v1.S3, wrng *rand.Rand, putBuf []byte, sink *bodySink) {
+			defer wg.Done()
+			downloader(ctx, rs, ws, c, v1, wrng, putBuf, sink, work)
+		}(rs.workers[i], bc.workers[i], v1, wrng, putBufs[i], sinks[i])
+	}
+	// With --ramp, workers join a step at a time and each step emits its
+	// own datapoint.
+	var steps []Datapoint
+	if cfg.Ramp != nil {
+		steps = rampSteps(ctx, cfg, rs, td, wtd, startWorker, wg.Wait)
+	} else {
+		for i := 0; i < cfg.Goroutines; i++ {
+			startWorker(i)
+		}
+	}
+
+	// Wait for all downloads to finish
+	wg.Wait()
+	elapsedSec := time.Since(startTime).Seconds()
+	rs.throughput.Stop()
+	if rs.tui != nil {
+		rs.tui.Stop()
+	}
+	if rs.cpu != nil {
+		rs.cpu.Stop()
+	}
+	rs.mem.Stop()
+
+	for _, sink := range sinks {
+		if sink == nil {
+			continue
+		}
+		if err := sink.remove(); err != nil {
+			warnf("error removing %s: %v", sink.path, err)
+		}
+	}
+
+	// Wait for latency calculations
+	close(latency)
+	<-latencyDone
+	close(writeLatency)
+	<-writeLatencyDone
+
+	if cfg.Ramp != nil {
+		return rampTotal(steps)
+	}
+
+	// Emit statistics (JSON for mongoimport or --mongodb-uri, or line
+	// protocol for InfluxDB) to graph results
+	datapoint := rs.takeDatapoint(ctx, td, wtd, false, startTime, elapsedSec)
+	if cfg.Duration == 0 {
+		datapoint.PlannedSizeBytes = cfg.DownloadSizeBytes
+	}
+	return datapoint
+}
+
+// takeDatapoint builds a datapoint from what rs has recorded since the last
+// one, over elapsedSec from start, and resets it for the next.  Within a
+// ramp step, latencies still buffered on their way to td and wtd are added
+// first.
+func (rs *runState) takeDatapoint(ctx context.Context, td, wtd *stepDigest, step bool, start time.Time, elapsedSec float64) Datapoint {
+	cfg := rs.cfg
+	take := (*stepDigest).take
+	if step {
+		take = (*stepDigest).takeStep
+	}
+
+	datapoint := baseDatapoint(cfg)
+	datapoint.Operation = "download"
+	if cfg.Op != "get" {
+		datapoint.Operation = cfg.Op
+	}
+
+	datapoint.StartTime = start
+	datapoint.ElapsedSecs = elapsedSec
+	datapoint.setLatencies(take(td), cfg.Quantiles)
+	if cfg.Op == "get" {
+		datapoint.setFullLatencies(rs.fullLatency.take(), cfg.Quantiles)
+	}
+	if cfg.Rate > 0 || cfg.TargetMiBs > 0 {
+		datapoint.setQueueDelays(rs.queueDelay.take())
+	}
+	datapoint.RequestsPerSec = float64(atomic.SwapInt64(&rs.completed, 0)) / elapsedSec
+	datapoint.setThroughputSeries(rs.throughput.take(), cfg.ThroughputSeries)
+	datapoint.setWorkerStats(rs.workers, elapsedSec, cfg.PerWorker)
+	datapoint.setConnStats(rs.tracker, elapsedSec)
+	rs.tracker.reset()
+	if rs.cpu != nil {
+		rs.cpu.setCPU(&datapoint)
+	}
+	rs.mem.setMem(&datapoint)
+	if rs.net != nil {
+		rs.net.setNet(&datapoint)
+	}
+
+	if rs.hist != nil {
+		datapoint.LatencyHistogram = rs.hist.take()
+	}
+	if rs.windows != nil {
+		datapoint.LatencyWindows = rs.windows.take()
+	}
+	if rs.heatmap != nil {
+		datapoint.LatencyHeatmap = rs.heatmap.take()
+	}
+	if rs.worst != nil {
+		datapoint.WorstRequests = rs.worst.take()
+	}
+	datapoint.HedgedRequests, datapoint.HedgeWinRate = rs.hedges.take()
+	datapoint.Timeouts = int(atomic.SwapInt64(&rs.timeouts, 0))
+	datapoint.setErrors(rs.errors.take(), attemptStatuses.take(), atomic.SwapInt64(&rs.requests, 0))
+	datapoint.setRetries()
+	if rs.setLatency != nil {
+		datapoint.SetQuantiles = rs.setLatency.Quantiles(cfg.Quantiles)
+		rs.setLatency.reset()
+	}
+
+	if cfg.WriteRatio > 0 {
+		datapoint.Writes = int(atomic.SwapInt64(&rs.writes, 0))
+		datapoint.WriteSizeBytes = int(atomic.SwapInt64(&rs.bytesPut, 0))
+		datapoint.setWriteLatencies(take(wtd), cfg.Quantiles)
+	}
+
+	// Throughput is always from the bytes actually read, since failed,
+	// timed-out, short or cut-short downloads mean the plan wasn't; duration
+	// runs have no plan, mixes overshoot it by part of an object and
+	// metadata ops read no bodies.
+	datapoint.Truncated = ctx.Err() != nil
+	datapoint.TotalSizeBytes = int(atomic.SwapInt64(&rs.bytesMoved, 0))
+	datapoint.ThroughputMiBs = float64(datapoint.TotalSizeBytes) / MiB / elapsedSec
+	datapoint.ShortReads = int(atomic.SwapInt64(&rs.shortReads, 0))
+	if rs.raw != nil {
+		datapoint.requests = rs.raw.take()
+	}
+	datapoint.setCost(cfg.Pricing)
+
+	return datapoint
+}
+
+// runDownload is the download benchmark: it fetches a file set with a pool of
+// goroutines and emits a datapoint for each of --count iterations.
+func runDownload(args []string) int {
+	cfg := parseDownloadFlags(args)
+	if cfg.DryRun {
+		return dryRun(cfg)
+	}
+
+	prepareRun(cfg)
+
+	// One PRNG for the whole invocation, so iterations differ from each
+	// other but the sequence is reproducible from the seed.
+	rng := rand.New(rand.NewSource(cfg.Seed))
+
+	// The deadline covers all iterations together, not each one.
+	ctx := context.Background()
+	if cfg.MaxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.MaxDuration)
+		defer cancel()
+	}
+
+	if cfg.MatrixSets != nil || cfg.MatrixGoroutines != nil {
+		return runMatrix(ctx, cfg, rng)
+	}
+	runIterations(ctx, cfg, rng)
+	return 0
+}
+
+// runIterations runs --count iterations of one configuration, with
+// --cooldown between them, and returns their datapoints.  With more than
+// one, it also emits a summary of them all, which isn't returned.
+func runIterations(ctx context.Context, cfg *myConfig, rng *rand.Rand) []Datapoint {
+	var dps []Datapoint
+	var bc *benchClients
+	for i := 0; i < cfg.Count; i++ {
+		if i > 0 && !cooldown(ctx, cfg) {
+			warnf("max-duration of %v reached before iteration %d of %d", cfg.MaxDuration, i+1, cfg.Count)
+			break
+		}
+		if bc == nil || cfg.FreshClient {
+			bc = newBenchClients(cfg)
+		}
+		dps = append(dps, run(ctx, cfg, bc, rng))
+		if ctx.Err() != nil {
+			warnf("max-duration of %v reached during iteration %d of %d", cfg.MaxDuration, i+1, cfg.Count)
+			break
+		}
+	}
+	if len(dps) > 1 && cfg.Ramp == nil {
+		emitDatapoint(cfg, summarizeIterations(cfg, dps))
+	}
+	return dps
+}
+
+// prepareRun does the setup shared by benchmark commands before their
+// first iteration: recording where we're running, applying resource limits
+// and readying the output file.
+func prepareRun(cfg *myConfig) {
+	info := detectInstance()
+	if cfg.EC2Instance == "" {
+		cfg.EC2Instance = info.Type
+	}
+	cfg.InstanceAZ = info.AZ
+	cfg.InstanceRegion = info.Region
+	cfg.AMI = info.AMI
+
+	if cfg.MaxMemoryBytes > 0 {
+		debug.SetMemoryLimit(cfg.MaxMemoryBytes)
+	}
+
+	applyCPULimits(cfg)
+
+	if err := prepareOutput(cfg); err != nil {
+		fatalf("error preparing output file: %v", err)
+	}
+}
+
+// applyCPULimits applies --cpuset and --gomaxprocs.  The runtime doesn't
+// notice affinity changes, so a CPU set also lowers GOMAXPROCS to match
+// unless it was given explicitly.
+func applyCPULimits(cfg *myConfig) {
+	if cfg.CPUSet != "" {
+		cpus, err := parseCPUSet(cfg.CPUSet)
+		if err != nil {
+			fatalf("error parsing cpuset: %v", err)
+		}
+		if err := setCPUAffinity(cpus); err != nil {
+			fatalf("error setting CPU affinity to %s: %v", cfg.CPUSet, err)
+		}
+		if cfg.GoMaxProcs == 0 {
+			runtime.GOMAXPROCS(len(cpus))
+		}
+	}
+	if cfg.GoMaxProcs > 0 {
+		runtime.GOMAXPROCS(cfg.GoMaxProcs)
+	}
+}
+
+// cooldown waits out --cooldown plus up to --cooldown-jitter between
+// iterations.  It returns false if the context expired first.
+func cooldown(ctx context.Context, cfg *myConfig) bool {
+	d := cfg.Cooldown
+	if cfg.CooldownJitter > 0 {
+		// Not the seeded PRNG: jitter shouldn't change which files are fetched.
+		d += time.Duration(rand.Int63n(int64(cfg.CooldownJitter)))
+	}
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	debugf("cooling down for %v", d)
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
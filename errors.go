@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// Error categories for Datapoint.ErrorCounts.
+const (
+	errThrottle   = "throttle"   // 503 SlowDown, 429, or a throttling error code
+	errTimeout    = "timeout"    // --request-timeout
+	errServer     = "5xx"        // other server errors
+	errClient     = "4xx"        // e.g. NoSuchKey or AccessDenied
+	errConnection = "connection" // reset, refused or cut short
+	errOther      = "other"
+)
+
+// classifyError puts the final error of a request, after SDK retries, in
+// one of the categories above.
+func classifyError(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "SlowDown", "Throttling", "ThrottlingException", "RequestLimitExceeded", "TooManyRequests":
+			return errThrottle
+		}
+	}
+	var respErr *smithyhttp.ResponseError
+	if errors.As(err, &respErr) {
+		switch status := respErr.HTTPStatusCode(); {
+		case status == 503 || status == 429:
+			return errThrottle
+		case status >= 500:
+			return errServer
+		case status >= 400:
+			return errClient
+		}
+	}
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return errTimeout
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.EPIPE), errors.Is(err, io.ErrUnexpectedEOF), errors.As(err, &netErr):
+		return errConnection
+	}
+	return errOther
+}
+
+// errorStats counts failed requests by category.
+type errorStats struct {
+	sync.Mutex
+	counts map[string]int
+	warned map[string]bool // categories already logged at warning level
+}
+
+func newErrorStats() *errorStats {
+	return &errorStats{counts: make(map[string]int), warned: make(map[string]bool)}
+}
+
+// Add counts err and reports whether it's the first of its category, which
+// is worth a warning where the rest aren't.
+func (es *errorStats) Add(err error) (string, bool) {
+	category := classifyError(err)
+	es.Lock()
+	defer es.Unlock()
+	es.counts[category]++
+	first := !es.warned[category]
+	es.warned[category] = true
+	return category, first
+}
+
+// total returns the errors counted since the last take.
+func (es *errorStats) total() int {
+	es.Lock()
+	defer es.Unlock()
+	n := 0
+	for _, c := range es.counts {
+		n += c
+	}
+	return n
+}
+
+// take returns the counts so far and starts afresh.
+func (es *errorStats) take() map[string]int {
+	es.Lock()
+	defer es.Unlock()
+	counts := es.counts
+	es.counts = make(map[string]int)
+	return counts
+}
+
+// statusCounts counts the HTTP status of every attempt, including those the
+// SDK retried, which the final result of a request hides.
+type statusCounts struct {
+	sync.Mutex
+	counts map[int]int
+}
+
+var attemptStatuses = &statusCounts{counts: make(map[int]int)}
+
+func (sc *statusCounts) add(status int) {
+	sc.Lock()
+	defer sc.Unlock()
+	sc.counts[status]++
+}
+
+// take returns the counts so far and starts afresh.
+func (sc *statusCounts) take() map[int]int {
+	sc.Lock()
+	defer sc.Unlock()
+	counts := sc.counts
+	sc.counts = make(map[int]int)
+	return counts
+}
+
+// addStatusRecorder is an S3 API option that counts each attempt's HTTP
+// status in attemptStatuses, and SlowDown errors in sdkRetries.  Deserialize
+// runs once per attempt, inside the retry loop; this goes first in the step
+// so that it sees the error the operation's deserializer makes.
+func addStatusRecorder(stack *middleware.Stack) error {
+	return stack.Deserialize.Add(middleware.DeserializeMiddlewareFunc("RecordStatus",
+		func(ctx context.Context, in middleware.DeserializeInput, next middleware.DeserializeHandler) (middleware.DeserializeOutput, middleware.Metadata, error) {
+			out, metadata, err := next.HandleDeserialize(ctx, in)
+			if resp, ok := out.RawResponse.(*smithyhttp.Response); ok {
+				attemptStatuses.add(resp.StatusCode)
+			}
+			var apiErr smithy.APIError
+			if errors.As(err, &apiErr) && apiErr.ErrorCode() == "SlowDown" {
+				sdkRetries.addSlowDown()
+			}
+			return out, metadata, err
+		}), middleware.Before)
+}
+
+// setErrors reports failed requests out of all those made, and the status
+// of every attempt.
+func (dp *Datapoint) setErrors(counts map[string]int, statuses map[int]int, requests int64) {
+	dp.Requests = int(requests)
+	for _, n := range counts {
+		dp.Errors += n
+	}
+	if requests > 0 {
+		dp.ErrorRate = float64(dp.Errors) / float64(requests)
+	}
+	if dp.Errors > 0 {
+		dp.ErrorCounts = counts
+	}
+	if len(statuses) > 0 {
+		dp.StatusCounts = statuses
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// grafanaMetrics are the Datapoint fields Grafana can chart, the numeric
+// ones, by their field index.
+var grafanaMetrics = func() map[string]int {
+	metrics := map[string]int{}
+	t := reflect.TypeOf(Datapoint{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		switch f.Type.Kind() {
+		case reflect.Int, reflect.Int64, reflect.Float64:
+			if f.IsExported() && f.Name != "SchemaVersion" {
+				metrics[f.Name] = i
+			}
+		}
+	}
+	return metrics
+}()
+
+// grafanaServer answers Grafana's JSON datasource plugin from a --store
+// database, so results can be charted on lasting Grafana dashboards without
+// another database.  Each query reads the store afresh, so it picks up
+// results as benchmarks add them.
+//
+// A query's target is a metric, any numeric Datapoint field such as
+// ThroughputMiBs or P99Latency, charted at each result's StartTime.  Its
+// payload may narrow the results as report --filter does, with a value or a
+// list of alternatives for each name (instance, set, op and so on), and
+// split them into series with "groupBy", by default as report does.  Ad hoc
+// filters narrow them too.
+type grafanaServer struct {
+	store string
+}
+
+func (g *grafanaServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		// The plugin's connection test.
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintln(w, "OK")
+	})
+	mux.HandleFunc("/metrics", g.serveMetrics)
+	mux.HandleFunc("/search", g.serveSearch) // the older SimpleJson plugin's /metrics
+	mux.HandleFunc("/query", g.serveQuery)
+	mux.HandleFunc("/variable", g.serveVariable)
+	mux.HandleFunc("/tag-keys", g.serveTagKeys)
+	mux.HandleFunc("/tag-values", g.serveTagValues)
+	return mux
+}
+
+func grafanaReply(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		warnf("error answering Grafana: %v", err)
+	}
+}
+
+// grafanaRequest decodes a request body into v.  Bodies Grafana leaves empty
+// are fine.
+func grafanaRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func grafanaMetricNames() []string {
+	names := make([]string, 0, len(grafanaMetrics))
+	for name := range grafanaMetrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (g *grafanaServer) serveMetrics(w http.ResponseWriter, _ *http.Request) {
+	type metric struct {
+		Label string `json:"label"`
+		Value string `json:"value"`
+	}
+	metrics := []metric{}
+	for _, name := range grafanaMetricNames() {
+		metrics = append(metrics, metric{Label: name, Value: name})
+	}
+	grafanaReply(w, metrics)
+}
+
+func (g *grafanaServer) serveSearch(w http.ResponseWriter, _ *http.Request) {
+	grafanaReply(w, grafanaMetricNames())
+}
+
+// grafanaStrings reads a payload value that's a string or a list of them.
+func grafanaStrings(raw json.RawMessage) ([]string, error) {
+	var one string
+	if err := json.Unmarshal(raw, &one); err == nil {
+		return []string{one}, nil
+	}
+	var list []string
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil, fmt.Errorf("%s is not a string or a list of strings", raw)
+	}
+	return list, nil
+}
+
+type grafanaSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"` // value, Unix milliseconds
+}
+
+func (g *grafanaServer) serveQuery(w http.ResponseWriter, r *http.Request) {
+	var q struct {
+		Range struct {
+			From, To time.Time
+		}
+		Targets []struct {
+			Target  string
+			Hide    bool
+			Payload map[string]json.RawMessage
+		}
+		AdhocFilters []struct {
+			Key, Operator, Value string
+		}
+	}
+	if !grafanaRequest(w, r, &q) {
+		return
+	}
+
+	var adhoc []string
+	for _, f := range q.AdhocFilters {
+		if f.Operator != "=" {
+			http.Error(w, fmt.Sprintf("ad hoc filter operator '%s' isn't supported; only '='", f.Operator), http.StatusBadRequest)
+			return
+		}
+		adhoc = append(adhoc, f.Key+"="+f.Value)
+	}
+
+	dps, err := readStoredDatapointsBetween(g.store, q.Range.From, q.Range.To)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	series := []grafanaSeries{}
+	for _, t := range q.Targets {
+		if t.Hide || t.Target == "" {
+			continue
+		}
+		s, err := grafanaQuerySeries(dps, t.Target, t.Payload, adhoc)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		series = append(series, s...)
+	}
+	grafanaReply(w, series)
+}
+
+// grafanaQuerySeries charts metric over dps, narrowed by the payload's and
+// the ad hoc filters, with a series for each group of the payload's
+// groupBy names.
+func grafanaQuerySeries(dps []Datapoint, metric string, payload map[string]json.RawMessage, adhoc []string) ([]grafanaSeries, error) {
+	field, ok := grafanaMetrics[metric]
+	if !ok {
+		return nil, fmt.Errorf("unknown metric '%s'", metric)
+	}
+
+	groupBy := reportGroupBy
+	filters := append([]string{}, adhoc...)
+	for name, raw := range payload {
+		values, err := grafanaStrings(raw)
+		if err != nil {
+			return nil, fmt.Errorf("payload %s: %w", name, err)
+		}
+		if name == "groupBy" {
+			groupBy = nil
+			for _, v := range values {
+				groupBy = append(groupBy, strings.Split(v, ",")...)
+			}
+			continue
+		}
+		for _, v := range values {
+			filters = append(filters, name+"="+v)
+		}
+	}
+	dps, err := filterDatapoints(dps, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	// Results are in time order, so each series is too.
+	bySeries := map[string]*grafanaSeries{}
+	var names []string
+	for _, dp := range dps {
+		parts := []string{metric}
+		for _, name := range groupBy {
+			if v := reportDimension(strings.TrimSpace(name))(dp); v != "" {
+				parts = append(parts, v)
+			}
+		}
+		name := strings.Join(parts, " ")
+		s := bySeries[name]
+		if s == nil {
+			s = &grafanaSeries{Target: name, Datapoints: [][2]float64{}}
+			bySeries[name] = s
+			names = append(names, name)
+		}
+		var value float64
+		if v := reflect.ValueOf(dp).Field(field); v.Kind() == reflect.Float64 {
+			value = v.Float()
+		} else {
+			value = float64(v.Int())
+		}
+		s.Datapoints = append(s.Datapoints, [2]float64{value, float64(dp.StartTime.UnixMilli())})
+	}
+	sort.Strings(names)
+	series := make([]grafanaSeries, len(names))
+	for i, name := range names {
+		series[i] = *bySeries[name]
+	}
+	return series, nil
+}
+
+// values returns the distinct values of a --filter name across the store,
+// for template variables and ad hoc filters.
+func (g *grafanaServer) values(name string) ([]string, error) {
+	dps, err := readStoredDatapoints(g.store)
+	if err != nil {
+		return nil, err
+	}
+	get := reportDimension(name)
+	seen := map[string]bool{}
+	values := []string{}
+	for _, dp := range dps {
+		if v := get(dp); v != "" && !seen[v] {
+			seen[v] = true
+			values = append(values, v)
+		}
+	}
+	sort.Slice(values, func(i, j int) bool { return lessValue(values[i], values[j]) })
+	return values, nil
+}
+
+// serveVariable lists the values of the name a template variable's query
+// gives, e.g. instance.
+func (g *grafanaServer) serveVariable(w http.ResponseWriter, r *http.Request) {
+	var q struct {
+		Payload json.RawMessage
+	}
+	if !grafanaRequest(w, r, &q) {
+		return
+	}
+	// The payload is the query's text, or an object with it as target.
+	var name string
+	if err := json.Unmarshal(q.Payload, &name); err != nil {
+		var p struct{ Target string }
+		json.Unmarshal(q.Payload, &p)
+		name = p.Target
+	}
+	values, err := g.values(strings.TrimSpace(name))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	type option struct {
+		Text  string `json:"__text"`
+		Value string `json:"__value"`
+	}
+	options := []option{}
+	for _, v := range values {
+		options = append(options, option{Text: v, Value: v})
+	}
+	grafanaReply(w, options)
+}
+
+// serveTagKeys lists the names ad hoc filters can use: the report
+// dimensions and the --label keys in the store.
+func (g *grafanaServer) serveTagKeys(w http.ResponseWriter, _ *http.Request) {
+	dps, err := readStoredDatapoints(g.store)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	seen := map[string]bool{}
+	for name := range reportDimensions {
+		seen[name] = true
+	}
+	for _, dp := range dps {
+		for k := range dp.Labels {
+			seen[k] = true
+		}
+	}
+	type key struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	keys := []key{}
+	for name := range seen {
+		keys = append(keys, key{Type: "string", Text: name})
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Text < keys[j].Text })
+	grafanaReply(w, keys)
+}
+
+func (g *grafanaServer) serveTagValues(w http.ResponseWriter, r *http.Request) {
+	var q struct{ Key string }
+	if !grafanaRequest(w, r, &q) {
+		return
+	}
+	values, err := g.values(q.Key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	type value struct {
+		Text string `json:"text"`
+	}
+	tagValues := []value{}
+	for _, v := range values {
+		tagValues = append(tagValues, value{Text: v})
+	}
+	grafanaReply(w, tagValues)
+}
+
+// runServe serves a --store database to Grafana's JSON datasource plugin
+// until it's stopped.
+func runServe(args []string) int {
+	fs := pflag.NewFlagSet("serve", pflag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: s3skunk serve [flags] <results.db>\n")
+		fs.PrintDefaults()
+	}
+	addr := fs.String("addr", "localhost:6070", "address to serve the Grafana JSON datasource API on")
+	parseFlags(fs, args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	g := &grafanaServer{store: fs.Arg(0)}
+	if _, err := readStoredDatapoints(g.store); err != nil {
+		fatalf("error reading %s: %v", g.store, err)
+	}
+
+	infof("serving %s to Grafana's JSON datasource at http://%s", g.store, *addr)
+	fatalf("error serving: %v", http.ListenAndServe(*addr, g.handler()))
+	return 1
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// heatSubBuckets splits each power of two of microseconds into this many
+// latency buckets for a heatmap, so each is at most 25% wide.
+const heatSubBuckets = 4
+
+// heatmapCell counts the requests completing in one time slot with latency
+// in [LowerMicros, UpperMicros).
+type heatmapCell struct {
+	StartSecs   float64 // of the time slot, from the start of the run or step
+	LowerMicros int64
+	UpperMicros int64
+	Count       int64
+}
+
+type heatKey struct {
+	slot  int
+	lower int64
+}
+
+// latencyHeatmap counts latencies by time slot and latency bucket, for
+// rendering latency over time as a heatmap.
+type latencyHeatmap struct {
+	sync.Mutex
+	start  time.Time
+	width  time.Duration
+	counts map[heatKey]int64
+}
+
+func newLatencyHeatmap(width time.Duration) *latencyHeatmap {
+	return &latencyHeatmap{start: time.Now(), width: width, counts: make(map[heatKey]int64)}
+}
+
+func (hm *latencyHeatmap) Add(secs float64) {
+	micros := int64(secs * 1e6)
+	if micros < 0 {
+		micros = 0
+	}
+	lower, _ := logLinearBucket(micros, heatSubBuckets)
+	hm.Lock()
+	defer hm.Unlock()
+	hm.counts[heatKey{slot: int(time.Since(hm.start) / hm.width), lower: lower}]++
+}
+
+// take returns the non-empty cells so far, by time and then latency, and
+// starts afresh from now.
+func (hm *latencyHeatmap) take() []heatmapCell {
+	hm.Lock()
+	counts := hm.counts
+	hm.counts = make(map[heatKey]int64)
+	hm.start = time.Now()
+	hm.Unlock()
+
+	cells := make([]heatmapCell, 0, len(counts))
+	for k, n := range counts {
+		_, upper := logLinearBucket(k.lower, heatSubBuckets)
+		cells = append(cells, heatmapCell{
+			StartSecs:   (time.Duration(k.slot) * hm.width).Seconds(),
+			LowerMicros: k.lower,
+			UpperMicros: upper,
+			Count:       n,
+		})
+	}
+	sort.Slice(cells, func(i, j int) bool {
+		if cells[i].StartSecs != cells[j].StartSecs {
+			return cells[i].StartSecs < cells[j].StartSecs
+		}
+		return cells[i].LowerMicros < cells[j].LowerMicros
+	})
+	return cells
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// hedgeStats counts how often requests were hedged and how often the hedge
+// beat the original request.
+type hedgeStats struct {
+	hedged int64
+	wins   int64
+}
+
+// take returns the hedged count and win rate so far and starts counting
+// afresh.
+func (hs *hedgeStats) take() (int, float64) {
+	hedged := atomic.SwapInt64(&hs.hedged, 0)
+	wins := atomic.SwapInt64(&hs.wins, 0)
+	if hedged == 0 {
+		return 0, 0
+	}
+	return int(hedged), float64(wins) / float64(hedged)
+}
+
+type getResult struct {
+	resp  *s3.GetObjectOutput
+	err   error
+	hedge bool
+}
+
+// hedgedGetObject issues a GetObject and, if no response has arrived within
+// hedgeAfter, races a second attempt using hedgeClient, which is expected to
+// dial a fresh connection.  The hedge is made with hedgeCtx rather than ctx,
+// so that it isn't traced as part of the original request.  The first
+// successful response wins and the other attempt is cancelled.  The returned
+// cancel func must be called once the winner's body has been consumed.
+func hedgedGetObject(ctx, hedgeCtx context.Context, client, hedgeClient *s3.Client, req *s3.GetObjectInput, hedgeAfter time.Duration, stats *hedgeStats) (*s3.GetObjectOutput, context.CancelFunc, error) {
+	pctx, pcancel := context.WithCancel(ctx)
+	results := make(chan getResult, 2)
+	go func() {
+		resp, err := client.GetObject(pctx, req)
+		results <- getResult{resp: resp, err: err}
+	}()
+
+	timer := time.NewTimer(hedgeAfter)
+	defer timer.Stop()
+	select {
+	case r := <-results:
+		return r.resp, pcancel, r.err
+	case <-timer.C:
+	}
+
+	atomic.AddInt64(&stats.hedged, 1)
+	hctx, hcancel := context.WithCancel(hedgeCtx)
+	go func() {
+		resp, err := hedgeClient.GetObject(hctx, req)
+		results <- getResult{resp: resp, err: err, hedge: true}
+	}()
+
+	// Take the first success; if the first back failed, the other attempt
+	// is all we have left.  If both failed, the primary's error is the one
+	// returned and the hedge hasn't won.
+	winner := <-results
+	pending := true
+	if winner.err != nil {
+		first := winner
+		winner = <-results
+		pending = false
+		if winner.err != nil && winner.hedge {
+			winner = first
+		}
+	}
+
+	if winner.hedge && winner.err == nil {
+		atomic.AddInt64(&stats.wins, 1)
+		pcancel()
+	} else {
+		hcancel()
+	}
+
+	// Don't leak the loser's body if it manages to respond anyway.
+	if pending {
+		go func() {
+			if r := <-results; r.resp != nil {
+				r.resp.Body.Close()
+			}
+		}()
+	}
+
+	cancel := func() {
+		pcancel()
+		hcancel()
+	}
+	return winner.resp, cancel, winner.err
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"math/bits"
+	"sort"
+	"sync"
+)
+
+// histSubBuckets is how many equal buckets each power of two of
+// microseconds is split into, which bounds a bucket's width to under 1% of
+// its values.
+const histSubBuckets = 128
+
+// histogramBucket is one bucket of a latency histogram, in microseconds,
+// covering [LowerMicros, UpperMicros).
+type histogramBucket struct {
+	LowerMicros int64
+	UpperMicros int64
+	Count       int64
+}
+
+// latencyHistogram counts latencies in fixed log-linear buckets, in the
+// style of HdrHistogram.  Bucket bounds don't depend on the data, so
+// histograms from different runs merge by adding counts.
+type latencyHistogram struct {
+	sync.Mutex
+	counts map[int64]int64 // by bucket lower bound
+}
+
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{counts: make(map[int64]int64)}
+}
+
+// histBucket returns the bounds of the histogram bucket holding micros.
+func histBucket(micros int64) (int64, int64) {
+	return logLinearBucket(micros, histSubBuckets)
+}
+
+// logLinearBucket returns the bounds of the bucket holding micros when each
+// power of two from sub up is split into sub equal buckets, sub being a
+// power of two; below sub, buckets are 1µs wide.
+func logLinearBucket(micros, sub int64) (int64, int64) {
+	if micros < sub {
+		return micros, micros + 1
+	}
+	shift := bits.Len64(uint64(micros)) - bits.Len64(uint64(sub))
+	lower := micros >> shift << shift
+	return lower, lower + 1<<shift
+}
+
+func (h *latencyHistogram) Add(secs float64) {
+	micros := int64(secs * 1e6)
+	if micros < 0 {
+		micros = 0
+	}
+	lower, _ := histBucket(micros)
+	h.Lock()
+	defer h.Unlock()
+	h.counts[lower]++
+}
+
+// take returns the non-empty buckets so far, in order, and starts afresh.
+func (h *latencyHistogram) take() []histogramBucket {
+	h.Lock()
+	counts := h.counts
+	h.counts = make(map[int64]int64)
+	h.Unlock()
+
+	buckets := make([]histogramBucket, 0, len(counts))
+	for lower, n := range counts {
+		_, upper := histBucket(lower)
+		buckets = append(buckets, histogramBucket{LowerMicros: lower, UpperMicros: upper, Count: n})
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].LowerMicros < buckets[j].LowerMicros })
+	return buckets
+}
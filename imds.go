@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+)
+
+// imdsTimeout bounds each metadata lookup so runs off EC2 aren't held up.
+const imdsTimeout = 2 * time.Second
+
+// getInstanceMetadata fetches a single IMDS path, e.g.
+// "placement/availability-zone".
+func getInstanceMetadata(client *imds.Client, path string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), imdsTimeout)
+	defer cancel()
+
+	resp, err := client.GetMetadata(ctx, &imds.GetMetadataInput{Path: path})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Content.Close()
+
+	b, err := io.ReadAll(resp.Content)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// instanceInfo describes the EC2 instance we're running on.
+type instanceInfo struct {
+	Type   string
+	AZ     string
+	Region string
+	AMI    string
+}
+
+// detectInstance queries IMDS (v2, via the SDK client) for details of this
+// EC2 instance.  Anything that can't be determined is "unknown".
+func detectInstance() instanceInfo {
+	info := instanceInfo{
+		Type:   "unknown",
+		AZ:     "unknown",
+		Region: "unknown",
+		AMI:    "unknown",
+	}
+
+	client := imds.New(imds.Options{})
+	lookups := []struct {
+		path string
+		dst  *string
+	}{
+		{"instance-type", &info.Type},
+		{"placement/availability-zone", &info.AZ},
+		{"placement/region", &info.Region},
+		{"ami-id", &info.AMI},
+	}
+	for _, l := range lookups {
+		v, err := getInstanceMetadata(client, l.path)
+		if err != nil {
+			// Most likely not on EC2; don't wait out a timeout for
+			// every remaining lookup.
+			warnf("could not read %s from IMDS: %v", l.path, err)
+			break
+		}
+		*l.dst = v
+	}
+
+	return info
+}
+
+// Zonal VPC interface endpoint names embed the AZ, e.g.
+// vpce-0123-abcd-us-east-1a.s3.us-east-1.vpce.amazonaws.com
+var vpceZonalRE = regexp.MustCompile(`vpce-[0-9a-z]+-[0-9a-z]+-([a-z]{2}(?:-[a-z]+)+-\d[a-z])\.`)
+
+// endpointAZ extracts the AZ from a zonal interface endpoint URL, returning
+// "" for regional or non-VPC endpoints.
+func endpointAZ(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return ""
+	}
+	m := vpceZonalRE.FindStringSubmatch(u.Hostname())
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"math"
+
+	"github.com/influxdata/tdigest"
+)
+
+// latencyDigest is a t-digest of latencies in seconds that also keeps the
+// exact count, min, max, mean and variance, which the digest can only
+// approximate.
+type latencyDigest struct {
+	*tdigest.TDigest
+	count    int
+	min, max float64
+	mean, m2 float64 // Welford's running mean and sum of squared deviations
+}
+
+func newLatencyDigest() *latencyDigest {
+	return &latencyDigest{TDigest: tdigest.NewWithCompression(1000)}
+}
+
+func (ld *latencyDigest) Add(secs float64) {
+	ld.TDigest.Add(secs, 1)
+	ld.count++
+	if ld.count == 1 || secs < ld.min {
+		ld.min = secs
+	}
+	if ld.count == 1 || secs > ld.max {
+		ld.max = secs
+	}
+	delta := secs - ld.mean
+	ld.mean += delta / float64(ld.count)
+	ld.m2 += delta * (secs - ld.mean)
+}
+
+// stdDev returns the sample standard deviation, or 0 for fewer than two
+// latencies.
+func (ld *latencyDigest) stdDev() float64 {
+	if ld.count < 2 {
+		return 0
+	}
+	return math.Sqrt(ld.m2 / float64(ld.count-1))
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/spf13/pflag"
+)
+
+// seedSubdirs is how many subdirectories seedKey spreads a set over, which
+// gives concurrent listers disjoint prefixes to work on.
+const seedSubdirs = 256
+
+func parseListFlags(args []string) *myConfig {
+	cfg := &myConfig{}
+	fs := pflag.NewFlagSet("list", pflag.ExitOnError)
+	addS3Flags(fs, cfg)
+	fs.IntVar(&cfg.Count, "count", 1, "number of datapoints to generate per combination")
+	fs.StringVar(&cfg.EC2Instance, "instance", "", "EC2 instance type (default: detected from instance metadata)")
+	fs.StringVar(&cfg.FileSetName, "set", "K064", "file set to list")
+	fs.IntSliceVar(&cfg.ListMaxKeys, "max-keys", []int{100, 250, 500, 1000}, "page sizes to sweep")
+	fs.IntSliceVar(&cfg.Listers, "listers", []int{1, 4, 16}, "concurrent listers to sweep, each taking whole subdirectories of the set")
+	addResultFlags(fs, cfg)
+	parseFlags(fs, args)
+
+	validateS3Flags(cfg)
+	validateResultFlags(cfg)
+
+	if _, ok := fileSets[cfg.FileSetName]; !ok {
+		fatalf("unknown file set '%s'", cfg.FileSetName)
+	}
+	for _, mk := range cfg.ListMaxKeys {
+		if mk < 1 || mk > ListPageSize {
+			fatalf("max-keys must be between 1 and %d", ListPageSize)
+		}
+	}
+	for _, n := range cfg.Listers {
+		if n < 1 || n > seedSubdirs {
+			fatalf("listers must be between 1 and %d", seedSubdirs)
+		}
+	}
+
+	return cfg
+}
+
+// listOnce lists the whole file set with the given page size, split across
+// listers by subdirectory, and emits a datapoint.
+func listOnce(cfg *myConfig, s3Client *s3.Client, maxKeys, listers int) {
+	work := make(chan string, seedSubdirs)
+	for i := 0; i < seedSubdirs; i++ {
+		work <- path.Join(cfg.Prefix, cfg.FileSetName, fmt.Sprintf("%02x", i)) + "/"
+	}
+	close(work)
+
+	td := newLatencyDigest()
+	var tdMu sync.Mutex
+	var keys, pages int64
+
+	tracker := newConnTracker()
+	ctx := withConnTracker(context.Background(), tracker)
+	startTime := time.Now()
+
+	var wg sync.WaitGroup
+	for i := 0; i < listers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for prefix := range work {
+				p := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+					Bucket:  aws.String(cfg.Bucket),
+					Prefix:  aws.String(prefix),
+					MaxKeys: int32(maxKeys),
+				})
+				for p.HasMorePages() {
+					start := time.Now()
+					page, err := p.NextPage(ctx)
+					expRequests.Add(1)
+					if err != nil {
+						expErrors.Add(1)
+						fatalf("error listing s3://%s/%s: %v", cfg.Bucket, prefix, err)
+					}
+					secs := time.Since(start).Seconds()
+					tdMu.Lock()
+					td.Add(secs)
+					tdMu.Unlock()
+					atomic.AddInt64(&pages, 1)
+					atomic.AddInt64(&keys, int64(len(page.Contents)))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsedSec := time.Since(startTime).Seconds()
+
+	if keys == 0 {
+		warnf("no keys found under s3://%s/%s (see the list-sets command)", cfg.Bucket, path.Join(cfg.Prefix, cfg.FileSetName))
+	}
+
+	datapoint := baseDatapoint(cfg)
+	datapoint.Operation = "list"
+	datapoint.Goroutines = listers
+	datapoint.ListMaxKeys = maxKeys
+
+	datapoint.StartTime = startTime
+	datapoint.ElapsedSecs = elapsedSec
+	datapoint.setLatencies(td, cfg.Quantiles)
+	datapoint.RequestsPerSec = float64(pages) / elapsedSec
+	datapoint.KeysPerSec = float64(keys) / elapsedSec
+	datapoint.setConnStats(tracker, elapsedSec)
+
+	emitDatapoint(cfg, datapoint)
+}
+
+// runList benchmarks ListObjectsV2 itself: per-page latency and keys per
+// second over a file set, across page sizes and numbers of concurrent
+// listers.
+func runList(args []string) int {
+	cfg := parseListFlags(args)
+	prepareRun(cfg)
+
+	for _, mk := range cfg.ListMaxKeys {
+		for _, n := range cfg.Listers {
+			for i := 0; i < cfg.Count; i++ {
+				s3Client, err := configS3(cfg)
+				if err != nil {
+					fatalf("error configuring S3: %v", err)
+				}
+				debugf("listing %s with max-keys %d and %d listers", cfg.FileSetName, mk, n)
+				listOnce(cfg, s3Client, mk, n)
+			}
+		}
+	}
+
+	return 0
+}
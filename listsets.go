@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/spf13/pflag"
+)
+
+type setSummary struct {
+	Objects    int
+	TotalBytes int64
+}
+
+// summarizeSets lists everything under the prefix and totals objects and
+// bytes by file set, i.e. the first path element after the prefix.
+func summarizeSets(cfg *myConfig, s3Client *s3.Client) (map[string]*setSummary, error) {
+	prefix := strings.TrimSuffix(cfg.Prefix, "/") + "/"
+	p := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(cfg.Bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	sets := make(map[string]*setSummary)
+	for p.HasMorePages() {
+		page, err := p.NextPage(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			name := strings.SplitN(strings.TrimPrefix(*obj.Key, prefix), "/", 2)[0]
+			if sets[name] == nil {
+				sets[name] = &setSummary{}
+			}
+			sets[name].Objects++
+			sets[name].TotalBytes += obj.Size
+		}
+	}
+
+	return sets, nil
+}
+
+// runListSets prints the file sets actually present in the bucket, so you can
+// check what's been seeded before picking --set.
+func runListSets(args []string) int {
+	cfg := &myConfig{}
+	fs := pflag.NewFlagSet("list-sets", pflag.ExitOnError)
+	addS3Flags(fs, cfg)
+	parseFlags(fs, args)
+
+	validateS3Flags(cfg)
+
+	s3Client, err := configS3(cfg)
+	if err != nil {
+		fatalf("error configuring S3: %v", err)
+	}
+
+	sets, err := summarizeSets(cfg, s3Client)
+	if err != nil {
+		fatalf("error listing s3://%s/%s: %v", cfg.Bucket, cfg.Prefix, err)
+	}
+	if len(sets) == 0 {
+		warnf("no file sets found under s3://%s/%s", cfg.Bucket, cfg.Prefix)
+		return 1
+	}
+
+	names := make([]string, 0, len(sets))
+	for name := range sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "SET\tOBJECTS\tTOTAL MiB\tNOTE")
+	for _, name := range names {
+		var note string
+		if _, ok := fileSets[name]; !ok {
+			note = "not a known file set"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%.1f\t%s\n", name, sets[name].Objects, float64(sets[name].TotalBytes)/MiB, note)
+	}
+	tw.Flush()
+
+	return 0
+}
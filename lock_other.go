@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// lockFile is a no-op where flock isn't available.
+func lockFile(f *os.File) error { return nil }
+
+func unlockFile(f *os.File) error { return nil }
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// Diagnostics always go to stderr through these helpers, so that stdout only
+// ever carries results and can be piped straight to mongoimport or jq.
+// Fatal errors go through fatalf, which is never filtered.
+
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// minLogLevel is the least severe level that will be logged.
+var minLogLevel = new(slog.LevelVar)
+
+// logger writes diagnostics, as text until setLogFormat says otherwise.
+var logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: minLogLevel}))
+
+// setLogLevel sets the least severe level that will be logged.
+func setLogLevel(name string) error {
+	level, ok := logLevels[name]
+	if !ok {
+		return fmt.Errorf("unknown log level '%s'", name)
+	}
+	minLogLevel.Set(level)
+	return nil
+}
+
+// setLogFormat sets whether diagnostics are logfmt-style text or JSON, one
+// object per line.
+func setLogFormat(name string) error {
+	opts := &slog.HandlerOptions{Level: minLogLevel}
+	switch name {
+	case "text":
+		logger = slog.New(slog.NewTextHandler(os.Stderr, opts))
+	case "json":
+		logger = slog.New(slog.NewJSONHandler(os.Stderr, opts))
+	default:
+		return fmt.Errorf("unknown log format '%s'", name)
+	}
+	return nil
+}
+
+func logAt(level slog.Level, format string, args ...interface{}) {
+	if !logger.Enabled(context.Background(), level) {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	if level >= slog.LevelError {
+		notify.noteError(msg)
+	}
+	logger.Log(context.Background(), level, msg)
+}
+
+func debugf(format string, args ...interface{}) { logAt(slog.LevelDebug, format, args...) }
+func infof(format string, args ...interface{})  { logAt(slog.LevelInfo, format, args...) }
+func warnf(format string, args ...interface{})  { logAt(slog.LevelWarn, format, args...) }
+func errorf(format string, args ...interface{}) { logAt(slog.LevelError, format, args...) }
+
+// fatalHooks run before fatalf exits, to write out results still buffered,
+// so a run that fails partway keeps what it measured.  Hooks log their own
+// errors; they mustn't call fatalf.
+var (
+	fatalHooksMu sync.Mutex
+	fatalHooks   []func()
+)
+
+// onFatal registers f to run if the process exits through fatalf.
+func onFatal(f func()) {
+	fatalHooksMu.Lock()
+	defer fatalHooksMu.Unlock()
+	fatalHooks = append(fatalHooks, f)
+}
+
+// fatalf logs an error that ends the run, runs the onFatal hooks, sends the
+// --notify-url failure notification, and exits 1.  The hooks run once;
+// other goroutines failing meanwhile wait for them before exiting.
+func fatalf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	logger.Error(msg, "fatal", true)
+	fatalHooksMu.Lock()
+	for _, f := range fatalHooks {
+		f()
+	}
+	fatalHooks = nil
+	fatalHooksMu.Unlock()
+	notify.send(1, msg)
+	os.Exit(1)
+}
+
+// addRequestLogs adds middleware that logs each attempt the SDK makes at a
+// request at debug level, with what AWS support asks for when escalating
+// one: the key, the attempt number, its time to response headers, and the
+// request IDs.
+func addRequestLogs(stack *middleware.Stack) error {
+	if err := stack.Initialize.Add(middleware.InitializeMiddlewareFunc("RequestLog",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			rl := &requestLog{}
+			// Object operations' inputs have a Key; others log none.
+			if v := reflect.ValueOf(in.Parameters); v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Struct {
+				if f := v.Elem().FieldByName("Key"); f.IsValid() {
+					if key, ok := f.Interface().(*string); ok && key != nil {
+						rl.key = *key
+					}
+				}
+			}
+			return next.HandleInitialize(context.WithValue(ctx, requestLogKey{}, rl), in)
+		}), middleware.Before); err != nil {
+		return err
+	}
+	return stack.Finalize.Insert(middleware.FinalizeMiddlewareFunc("LogAttempt",
+		func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+			// Attempts of a request are made one after another, so the
+			// count needs no lock.
+			rl, _ := ctx.Value(requestLogKey{}).(*requestLog)
+			if rl == nil {
+				rl = &requestLog{}
+			}
+			rl.attempts++
+			start := time.Now()
+			out, metadata, err := next.HandleFinalize(ctx, in)
+
+			attrs := []any{
+				"op", awsmiddleware.GetOperationName(ctx),
+				"attempt", rl.attempts,
+				"secs", time.Since(start).Seconds(),
+			}
+			if rl.key != "" {
+				attrs = append(attrs, "key", rl.key)
+			}
+			if resp, ok := awsmiddleware.GetRawResponse(metadata).(*smithyhttp.Response); ok {
+				attrs = append(attrs, "status", resp.StatusCode)
+			}
+			if id, ok := awsmiddleware.GetRequestIDMetadata(metadata); ok {
+				attrs = append(attrs, "request_id", id)
+			}
+			if id, ok := s3.GetHostIDMetadata(metadata); ok {
+				attrs = append(attrs, "extended_request_id", id)
+			}
+			if err != nil {
+				attrs = append(attrs, "error", err.Error())
+			}
+			logger.Debug("s3 attempt", attrs...)
+			return out, metadata, err
+		}), "Retry", middleware.After)
+}
+
+// requestLog is what addRequestLogs knows of a request across its attempts.
+type requestLog struct {
+	key      string
+	attempts int
+}
+
+type requestLogKey struct{}
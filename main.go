@@ -2,27 +2,25 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"log"
-	"math/rand"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
-	"runtime"
-	"sync"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/influxdata/tdigest"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/spf13/pflag"
-
-	_ "net/http/pprof"
 )
 
 const (
@@ -30,10 +28,14 @@ const (
 	MiB = 1024 * KiB
 )
 
+// copyBufferSize is the buffer io.Copy allocates for each download.
+const copyBufferSize = 32 * KiB
+
+// Defaults for where the file sets live.
 const (
-	S3Region = "us-east-1"
-	S3Bucket = "david.golden"
-	S3Prefix = "randomdata"
+	DefaultS3Region = "us-east-1"
+	DefaultS3Bucket = "david.golden"
+	DefaultS3Prefix = "randomdata"
 )
 
 type fileSet struct {
@@ -82,265 +84,436 @@ var fileSets = map[string]fileSet{
 }
 
 type myConfig struct {
+	AMI               string
+	AssertSpecs       []string
+	Asserts           []assertion
+	Bucket            string
+	CacheHotKeys      int
+	CacheRounds       int
+	CacheWindows      int
+	Client            string
+	ConnAffinity      bool
+	Cooldown          time.Duration
+	CooldownJitter    time.Duration
+	CopyObjects       int
+	CopyPartSizeBytes int
+	CopySets          []string
 	Count             int
+	CPUSet            string
+	CRTTargetGbps     float64
+	CredentialsFile   string
+	DeleteBatchSize   int
+	DeleteObjects     int
+	DisableKeepAlive  bool
+	Distribution      string
 	DownloadSizeBytes int
+	Duration          time.Duration
+	DryRun            bool
 	EC2Instance       string
+	Endpoint          string
+	EndpointAZ        string
+	ExternalID        string
+	FailOnError       bool
 	FileSetName       string
+	FreshClient       bool
+	GoMaxProcs        int
 	Goroutines        int
+	Heatmap           time.Duration
+	HedgeAfter        time.Duration
+	Histogram         bool
+	IdleConnsPerHost  int
+	InstanceAZ        string
+	InstanceRegion    string
+	KeepScratch       bool
+	Key               string
+	Labels            map[string]string
+	ManagerPartSize   int // bytes
+	ManagerParts      int // in flight per object
+	LatencyWindow     time.Duration
+	ListMaxKeys       []int
+	Listers           []int
+	MatrixGoroutines  []int
+	MatrixSets        []string
+	MaxDuration       time.Duration
+	MaxMemoryBytes    int64
+	MetricsNamespace  string
+	MongoDBCollection string
+	MongoDBURI        string
+	ObjectSizeBytes   int
+	Op                string
+	OutputFile        string
+	OutputFormat      string
+	OutputMode        string
+	PartConcurrency   []int
+	PartSizes         []int
+	PathStyle         bool
+	PerWorker         bool
+	Pricing           pricing
+	Prefix            string
+	ProbeNewKeys      bool
+	Probes            int
+	Profile           string
+	Quantiles         []float64
+	Ramp              []rampStep
+	RangeCounts       []int
+	RangeSizeBytes    int
+	Rate              float64
+	RawFormat         string
+	RawOutput         string
+	RawSample         float64
+	Reassemble        bool
+	Region            string
+	ReportInterval    time.Duration
+	RequestTimeout    time.Duration
+	RoleARN           string
+	RoleSessionName   string
+	ScratchPrefix     string
+	Seed              int64
+	SelectExpression  string
+	SelectFormat      string
+	SelectQueries     int
+	SetWeights        map[string]float64
+	SignatureVersion  string
+	SinkDir           string
+	SinkDirect        bool
+	SinkFsync         bool
+	SLOs              []assertion
+	SLOSpecs          []string
+	Store             string
+	StoreRequests     bool
+	TargetMiBs        float64
+	ThinkTime         time.Duration
+	ThinkTimeExp      bool
+	ThroughputSeries  bool
+	TUI               bool
+	UploadSizeBytes   int
+	Warmup            time.Duration
+	WarmupRequests    int
+	Worst             int
+	WriteRatio        float64
+	ZipfS             float64
 }
 
-func parseFlags() *myConfig {
-	count := pflag.Uint("count", 1, "number of datapoints to generate")
-	instance := pflag.String("instance", "unknown", "EC2 instance type")
-	goroutines := pflag.Uint("goroutines", uint(runtime.NumCPU()), "parallel downloads")
-	fileSetName := pflag.String("set", "M001", "file set to download")
-	downloadSize := pflag.Uint("download", 256, "total size to download in MiB")
-	pflag.Parse()
+// flagAliases maps old flag names to the ones that replaced them.
+var flagAliases = map[string]string{
+	"endpoint": "endpoint-url",
+}
 
-	fileSet, ok := fileSets[*fileSetName]
-	if !ok {
-		log.Fatalf("unknown file set '%s'", *fileSetName)
+// addS3Flags registers the flags that control how we talk to S3, which are
+// common to all commands.
+func addS3Flags(fs *pflag.FlagSet, cfg *myConfig) {
+	fs.SetNormalizeFunc(func(_ *pflag.FlagSet, name string) pflag.NormalizedName {
+		if alias, ok := flagAliases[name]; ok {
+			name = alias
+		}
+		return pflag.NormalizedName(name)
+	})
+
+	fs.StringVar(&cfg.Bucket, "bucket", DefaultS3Bucket, "S3 bucket holding the file sets")
+	fs.StringVar(&cfg.Prefix, "prefix", DefaultS3Prefix, "key prefix under which file sets live")
+	fs.StringVar(&cfg.Region, "region", DefaultS3Region, "AWS region of the bucket")
+	fs.StringVar(&cfg.Profile, "profile", "", "shared config profile to take credentials from")
+	fs.StringVar(&cfg.CredentialsFile, "credentials-file", "", "shared credentials file (default ~/.aws/credentials)")
+	fs.StringVar(&cfg.RoleARN, "role-arn", "", "IAM role to assume with STS for S3 access")
+	fs.StringVar(&cfg.ExternalID, "external-id", "", "external ID for --role-arn, if the role requires one")
+	fs.StringVar(&cfg.RoleSessionName, "session-name", "s3skunk", "session name for --role-arn")
+	fs.StringVar(&cfg.Endpoint, "endpoint-url", "", "custom S3 endpoint URL, e.g. MinIO, LocalStack, Ceph RGW or a VPC interface endpoint")
+	fs.StringVar(&cfg.EndpointAZ, "endpoint-az", "", "AZ of --endpoint-url (default: parsed from zonal VPC endpoint name)")
+	fs.BoolVar(&cfg.PathStyle, "path-style", false, "use path-style addressing (most S3-compatible stores need this)")
+	fs.StringVar(&cfg.SignatureVersion, "signature", "v4", "request signing version (v4 or v2)")
+	fs.BoolVar(&cfg.DisableKeepAlive, "disable-keepalive", false, "use a new connection for every request")
+	fs.IntVar(&cfg.IdleConnsPerHost, "idle-conns-per-host", awshttp.DefaultHTTPTransportMaxIdleConnsPerHost, "max idle connections kept per host")
+}
+
+// validateS3Flags checks the flags registered by addS3Flags after parsing.
+func validateS3Flags(cfg *myConfig) {
+	switch cfg.SignatureVersion {
+	case "v4":
+	case "v2":
+		// AWS has retired SigV2 for S3, so only allow it against a custom
+		// endpoint.  Our signer can't handle virtual-hosted buckets.
+		if cfg.Endpoint == "" || isAWSEndpoint(cfg.Endpoint) {
+			fatalf("signature v2 is not supported by AWS S3; use --endpoint-url to target an S3-compatible store")
+		}
+		if !cfg.PathStyle {
+			fatalf("signature v2 requires --path-style")
+		}
+	default:
+		fatalf("unknown signature version '%s'", cfg.SignatureVersion)
 	}
 
-	dlSize := int(*downloadSize) * MiB
-	if dlSize%fileSet.Size != 0 {
-		log.Fatalf("downloadMB (%d MiB) must be a multiple of the file set size (%d)", *downloadSize, fileSet.Size)
+	if cfg.IdleConnsPerHost < 0 {
+		fatalf("idle-conns-per-host must not be negative")
 	}
 
-	dlCount := dlSize / fileSet.Size
-	if int(*goroutines) > dlCount {
-		log.Fatalf("goroutines (%d) is greater than files to download (%d)", *goroutines, dlCount)
+	if cfg.RoleARN == "" && cfg.ExternalID != "" {
+		fatalf("external-id requires role-arn")
 	}
 
-	return &myConfig{
-		Count:             int(*count),
-		DownloadSizeBytes: dlSize,
-		EC2Instance:       *instance,
-		FileSetName:       *fileSetName,
-		Goroutines:        int(*goroutines),
+	if cfg.Endpoint != "" {
+		u, err := url.Parse(cfg.Endpoint)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			fatalf("endpoint-url '%s' must be an absolute URL like http://localhost:9000", cfg.Endpoint)
+		}
+	}
+
+	if cfg.EndpointAZ == "" {
+		cfg.EndpointAZ = endpointAZ(cfg.Endpoint)
 	}
 }
 
-type Datapoint struct {
-	// Fixed at run time by config
-	EC2Instance    string
-	FileSizeBytes  int    // for scatter plotting
-	FileSizeLabel  string // for data series labeling
-	Goroutines     int
-	TotalSizeBytes int
-
-	// Calculated during execution
-	ElapsedSecs    float64
-	P50Latency     float64 // Req to response, without reading full body
-	P95Latency     float64
-	P99Latency     float64
-	ThroughputMiBs float64 // TotalSizeBytes / MiB / ElapsedSecs
+// isAWSEndpoint reports whether endpoint is one of AWS's own hosts, which
+// don't accept SigV2, rather than an S3-compatible store's.
+func isAWSEndpoint(endpoint string) bool {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, suffix := range []string{"amazonaws.com", "amazonaws.com.cn"} {
+		if host == suffix || strings.HasSuffix(host, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// newHTTPClient makes the HTTP client for an S3 client, with the
+// connection settings from cfg.
+func newHTTPClient(cfg *myConfig) *awshttp.BuildableClient {
+	return awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+		tr.DisableKeepAlives = cfg.DisableKeepAlive
+		tr.DialContext = tcpConns.wrapDial(tr.DialContext)
+		tr.MaxIdleConnsPerHost = cfg.IdleConnsPerHost
+		if cfg.ConnAffinity {
+			// Each client is owned by a single worker, so one connection
+			// is all it should ever need.
+			tr.MaxConnsPerHost = 1
+			tr.MaxIdleConnsPerHost = 1
+		}
+	})
 }
 
 func configS3(cfg *myConfig) (*s3.Client, error) {
-	// customClient := awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
-	// 	tr.MaxIdleConnsPerHost = 1024
-	// 	tr.IdleConnTimeout = 1 * time.Minute
-	// })
-
-	awscfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion(S3Region),
-		// config.WithHTTPClient(customClient),
-	)
+	awscfg, err := loadAWSConfig(cfg, cfg.Region, newHTTPClient(cfg))
 	if err != nil {
 		return nil, err
 	}
 
 	s3Client := s3.NewFromConfig(awscfg, func(o *s3.Options) {
-		o.Retryer = retry.NewStandard(func(o *retry.StandardOptions) {
+		o.APIOptions = append(o.APIOptions, addStatusRecorder, addRequestTraces)
+		if tracerProvider != nil {
+			o.APIOptions = append(o.APIOptions, addAttemptSpans)
+		}
+		if logger.Enabled(context.Background(), slog.LevelDebug) {
+			o.APIOptions = append(o.APIOptions, addRequestLogs)
+		}
+		o.Retryer = countingRetryer{retry.NewStandard(func(o *retry.StandardOptions) {
 			o.RateLimiter = &nopRateLimiter{}
 			o.MaxAttempts = 10
-		})
+		})}
+		o.UsePathStyle = cfg.PathStyle
+		if cfg.Endpoint != "" {
+			o.EndpointResolver = s3.EndpointResolverFromURL(cfg.Endpoint)
+		}
+		if cfg.SignatureVersion == "v2" {
+			o.HTTPSignerV4 = &sigV2Signer{}
+		}
 	})
 
 	return s3Client, nil
 }
 
-func listS3Files(cfg *myConfig, s3Client *s3.Client) ([]string, error) {
-	files := make([]string, 0, 1024)
-
-	req := &s3.ListObjectsV2Input{
-		Bucket: aws.String(S3Bucket),
-		Prefix: aws.String(path.Join(S3Prefix, cfg.FileSetName)),
+// loadAWSConfig loads the AWS config for region, with the credentials the
+// flags call for, using client for HTTP if it isn't nil.
+func loadAWSConfig(cfg *myConfig, region string, client aws.HTTPClient) (aws.Config, error) {
+	opts := []func(*config.LoadOptions) error{
+		config.WithRegion(region),
 	}
-
-	// listobjects from S3
-	p := s3.NewListObjectsV2Paginator(s3Client, req)
-
-	// Iterate through the S3 object pages, printing each object returned.
-	var i int
-	for p.HasMorePages() {
-		i++
-		page, err := p.NextPage(context.Background())
-		if err != nil {
-			log.Fatalf("failed to get page %v, %v", i, err)
-		}
-
-		// objects found
-		for _, obj := range page.Contents {
-			files = append(files, *obj.Key)
-		}
+	if client != nil {
+		opts = append(opts, config.WithHTTPClient(client))
 	}
-
-	// shuffle result
-	rand.Shuffle(len(files), func(i, j int) {
-		files[i], files[j] = files[j], files[i]
-	})
-
-	return files, nil
-}
-
-func buildDownloadList(cfg *myConfig, s3Client *s3.Client) ([]string, error) {
-	// Download file candidates from s3
-	fileList, err := listS3Files(cfg, s3Client)
-	if err != nil {
-		return nil, err
+	if cfg.Profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(cfg.Profile))
 	}
-	if len(fileList) == 0 {
-		return nil, errors.New("no S3 files found for file set")
+	if cfg.CredentialsFile != "" {
+		opts = append(opts, config.WithSharedCredentialsFiles([]string{cfg.CredentialsFile}))
 	}
 
-	numFilesNeeded := cfg.DownloadSizeBytes / fileSets[cfg.FileSetName].Size
-	if numFilesNeeded == 0 {
-		log.Fatal("config results in zero files needed for download; WTF")
+	awscfg, err := config.LoadDefaultConfig(context.TODO(), opts...)
+	if err != nil {
+		return aws.Config{}, err
 	}
 
-	files := make([]string, 0, numFilesNeeded)
-
-	for {
-		for _, f := range fileList {
-			files = append(files, f)
-			if len(files) >= numFilesNeeded {
-				return files, nil
+	// Wrap whatever credentials we found in an assumed role, if requested.
+	if cfg.RoleARN != "" {
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(awscfg), cfg.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = cfg.RoleSessionName
+			if cfg.ExternalID != "" {
+				o.ExternalID = aws.String(cfg.ExternalID)
 			}
-		}
+		})
+		awscfg.Credentials = aws.NewCredentialsCache(provider)
 	}
+
+	return awscfg, nil
 }
 
-func downloader(s3Client *s3.Client, work chan string, latency chan float64) {
-	for f := range work {
-		start := time.Now()
-		req := &s3.GetObjectInput{
-			Bucket: aws.String(S3Bucket),
-			Key:    aws.String(f),
-		}
-		resp, err := s3Client.GetObject(context.Background(), req)
-		if err != nil {
-			log.Fatalf("error downloading %s: %v", f, err)
-		}
-		latency <- time.Since(start).Seconds()
-		defer resp.Body.Close()
-		io.Copy(io.Discard, resp.Body)
-	}
+type command struct {
+	run     func(args []string) int
+	summary string
 }
 
-func run(cfg *myConfig) int {
+var commands = map[string]command{
+	"cache": {
+		run:     runCache,
+		summary: "compare repeated fetches of a few hot keys with fetches of distinct keys",
+	},
+	"clean": {
+		run:     runClean,
+		summary: "delete a file set from the bucket",
+	},
+	"compare": {
+		run:     runCompare,
+		summary: "test whether two results files differ by more than noise",
+	},
+	"consistency": {
+		run:     runConsistency,
+		summary: "measure how long a PUT takes to be visible to readers",
+	},
+	"copy": {
+		run:     runCopy,
+		summary: "compare server-side copy with copying through the client",
+	},
+	"delete": {
+		run:     runDelete,
+		summary: "benchmark DeleteObject and batched DeleteObjects on scratch objects",
+	},
+	"download": {
+		run:     runDownload,
+		summary: "benchmark concurrent downloads of a file set (default)",
+	},
+	"list": {
+		run:     runList,
+		summary: "benchmark ListObjectsV2 across page sizes and concurrent listers",
+	},
+	"list-sets": {
+		run:     runListSets,
+		summary: "show the file sets present in the bucket, with sizes",
+	},
+	"multipart": {
+		run:     runMultipart,
+		summary: "benchmark multipart uploads across part sizes and concurrency",
+	},
+	"plot": {
+		run:     runPlot,
+		summary: "draw throughput and latency CDF charts from results files as images",
+	},
+	"report": {
+		run:     runReport,
+		summary: "aggregate results files by instance, set and the like, or render them as an HTML report",
+	},
+	"seed": {
+		run:     runSeed,
+		summary: "upload a file set of random data to the bucket",
+	},
+	"select": {
+		run:     runSelect,
+		summary: "benchmark S3 Select time to first record and scan throughput",
+	},
+	"serve": {
+		run:     runServe,
+		summary: "serve a --store database to Grafana's JSON datasource plugin",
+	},
+	"split-download": {
+		run:     runSplitDownload,
+		summary: "benchmark one large object downloaded as concurrent ranges",
+	},
+	"sweep": {
+		run:     runSweep,
+		summary: "download at rising concurrency and recommend where throughput levels off",
+	},
+	"upload": {
+		run:     runUpload,
+		summary: "benchmark concurrent uploads to a scratch prefix",
+	},
+}
 
-	// Configure S3 client
-	s3Client, err := configS3(cfg)
-	if err != nil {
-		log.Fatalf("error configuring S3: %v", err)
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [command] [flags]\n\ncommands:\n", path.Base(os.Args[0]))
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
 	}
-
-	// Build a list of files from fileset equal to total download size
-	downloadList, err := buildDownloadList(cfg, s3Client)
-	if err != nil {
-		log.Fatalf("error building file list: %v", err)
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].summary)
 	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> --help' for a command's flags.\n", path.Base(os.Args[0]))
+	fmt.Fprintf(os.Stderr, "Any flag can also be set from the environment, e.g. --max-memory with %s.\n", envName("max-memory"))
+}
 
-	// Let channels be buffered by goroutine count, but not ridiculously to
-	// avoid blowing up memory
-	chanSize := cfg.Goroutines
-	if chanSize > 1024 {
-		chanSize = 1024
-	}
+// commandName is the command being run.
+var commandName string
 
-	// Use goroutine to pump file list into a channel
-	work := make(chan string, chanSize)
-	go func() {
-		for _, f := range downloadList {
-			work <- f
-		}
-		close(work)
-	}()
-
-	// Collect latencies
-	latency := make(chan float64, chanSize)
-	latencyDone := make(chan struct{})
-	td := tdigest.NewWithCompression(1000)
-	go func() {
-		for v := range latency {
-			td.Add(v, 1)
-		}
-		close(latencyDone)
-	}()
-
-	// Record start time just before goroutines start.
-	startTime := time.Now()
-
-	// Start worker goroutines to download files from channel.  Don't want to
-	// synchronize their start because we won't do that in practice in ADL.
-	var wg sync.WaitGroup
-	for i := 0; i < cfg.Goroutines; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			downloader(s3Client, work, latency)
-		}()
+// closeOutputs writes out the results still buffered for MongoDB, the
+// store and Parquet --raw-output, and closes them.  It's run at exit, and by
+// fatalf, so a run that fails partway keeps what it measured.
+func closeOutputs() error {
+	var errs []error
+	if err := closeResults(); err != nil {
+		errs = append(errs, fmt.Errorf("error inserting results into MongoDB: %w", err))
 	}
-
-	// Wait for all downloads to finish
-	wg.Wait()
-	elapsedSec := time.Since(startTime).Seconds()
-
-	// Wait for latency calculations
-	close(latency)
-	<-latencyDone
-
-	// Emit statistics as JSON (for later mongoimport to graph results)
-
-	datapoint := Datapoint{
-		// Defined
-		EC2Instance:    cfg.EC2Instance,
-		FileSizeBytes:  fileSets[cfg.FileSetName].Size,
-		FileSizeLabel:  cfg.FileSetName,
-		Goroutines:     cfg.Goroutines,
-		TotalSizeBytes: cfg.DownloadSizeBytes,
-
-		// Calculated
-		ElapsedSecs:    elapsedSec,
-		P50Latency:     td.Quantile(0.50),
-		P95Latency:     td.Quantile(0.95),
-		P99Latency:     td.Quantile(0.99),
-		ThroughputMiBs: float64(cfg.DownloadSizeBytes) / MiB / elapsedSec,
+	if err := closeStore(); err != nil {
+		errs = append(errs, fmt.Errorf("error closing result store: %w", err))
 	}
+	if err := closeRawParquet(); err != nil {
+		errs = append(errs, fmt.Errorf("error writing raw output: %w", err))
+	}
+	return errors.Join(errs...)
+}
 
-	jb, err := json.Marshal(datapoint)
-	if err != nil {
-		log.Fatalf("error encoding datapoint to JSON: %v", err)
+func main() {
+	// Without a command name, default to downloading so existing scripts
+	// that only pass flags keep working.
+	name := "download"
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
 	}
 
-	fmt.Println(string(jb))
+	if name == "help" {
+		usage()
+		os.Exit(0)
+	}
 
-	return 0
-}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command '%s'\n\n", name)
+		usage()
+		os.Exit(2)
+	}
 
-func main() {
-	go func() {
-		log.Println(http.ListenAndServe("localhost:6060", nil))
-	}()
-
-	cfg := parseFlags()
-	var ec int
-	for i := 0; i < cfg.Count; i++ {
-		ec += run(cfg)
+	commandName = name
+	onFatal(func() {
+		if err := closeOutputs(); err != nil {
+			errorf("%v", err)
+		}
+		stopStatsd()
+	})
+	code := cmd.run(args)
+	if err := closeOutputs(); err != nil {
+		fatalf("%v", err)
+	}
+	if err := shutdownTracing(); err != nil {
+		warnf("error exporting spans: %v", err)
+	}
+	stopStatsd()
+	if code == 0 && assertionsFailed.Load() {
+		code = 1
 	}
-	os.Exit(ec)
+	notify.send(code, "")
+	os.Exit(code)
 }
 
 type nopRateLimiter struct{}
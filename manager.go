@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// discardWriterAt is where the transfer manager's parts go with --client
+// manager: nowhere, but counted as they arrive, like a discarded body.
+type discardWriterAt struct {
+	streamed *int64
+	once     sync.Once
+	first    func() // called at the first bytes of the object
+}
+
+func (w *discardWriterAt) WriteAt(p []byte, _ int64) (int, error) {
+	w.once.Do(w.first)
+	atomic.AddInt64(w.streamed, int64(len(p)))
+	return len(p), nil
+}
+
+// managedGet downloads an object through the transfer manager's Downloader
+// instead of a single GetObject, for --client manager.  The Downloader
+// fetches the first part to learn the object's size, then the rest as
+// concurrent ranged GETs, each counted as a request.  It doesn't expose
+// responses, so latency is to the first bytes of the body rather than to
+// the headers, and raw records have no attempts or status.  Each part is
+// traced as a request of its own, so connection phases and reuse are per
+// part.
+func (rs *runState) managedGet(ctx, reqCtx context.Context, span trace.Span, ws *workerStats, s3Client *s3.Client, req *s3.GetObjectInput, start time.Time) {
+	f := aws.ToString(req.Key)
+	d := manager.NewDownloader(s3Client, func(d *manager.Downloader) {
+		d.PartSize = int64(rs.cfg.ManagerPartSize)
+		d.Concurrency = rs.cfg.ManagerParts
+	})
+	var secs float64
+	started := false
+	w := &discardWriterAt{streamed: &rs.streamed, first: func() {
+		secs = rs.recordLatency(f, start)
+		started = true
+	}}
+	n, err := d.Download(reqCtx, w, req)
+
+	// A range is fetched whole, as one GET.
+	parts := int64(1)
+	if req.Range == nil && n > int64(rs.cfg.ManagerPartSize) {
+		parts = (n + int64(rs.cfg.ManagerPartSize) - 1) / int64(rs.cfg.ManagerPartSize)
+	}
+	expRequests.Add(parts)
+	statsd.Count("requests", parts, "")
+	atomic.AddInt64(&rs.requests, parts)
+
+	if err == nil {
+		// An empty object has no bytes to wait for.
+		w.once.Do(w.first)
+	}
+	if !started {
+		rs.getFailed(ctx, reqCtx, span, f, start, err)
+		return
+	}
+	if rs.worst != nil {
+		rs.worst.Add(f, secs, middleware.Metadata{})
+	}
+
+	// The Downloader doesn't say how long the object was, so short reads
+	// can't be told apart.
+	span.SetAttributes(attribute.Int64("s3.parts", parts))
+	rec := rawRecord{Key: f, Start: start, TTFBSecs: secs}
+	rs.readDone(ctx, reqCtx, span, ws, secs, n, -1, rec, nil, err)
+}
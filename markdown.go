@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// markdownHeader is the header row for --format markdown.  With it
+// written once per output, an invocation's results make one table.
+func markdownHeader() string {
+	return "| set | op | goroutines | MiB/s | p50 ms | p95 ms | p99 ms |\n" +
+		"|---|---|--:|--:|--:|--:|--:|"
+}
+
+// formatMarkdown renders a datapoint as a row of a Markdown table, to paste
+// into tickets and design docs.  A --count summary's figures are means, so
+// its row says how many iterations it covers and the throughput's standard
+// deviation.
+func formatMarkdown(dp Datapoint) (string, error) {
+	set := dp.FileSizeLabel
+	tput := fmt.Sprintf("%.1f", dp.ThroughputMiBs)
+	if dp.Iterations > 0 {
+		set += fmt.Sprintf(" (mean of %d)", dp.Iterations)
+		tput += fmt.Sprintf(" ± %.1f", dp.IterationStats["ThroughputMiBs"].StdDev)
+	}
+	cells := []string{
+		set,
+		dp.Operation,
+		fmt.Sprint(dp.Goroutines),
+		tput,
+		fmt.Sprintf("%.2f", dp.P50Latency*1000),
+		fmt.Sprintf("%.2f", dp.P95Latency*1000),
+		fmt.Sprintf("%.2f", dp.P99Latency*1000),
+	}
+	for i, c := range cells {
+		cells[i] = strings.ReplaceAll(c, "|", `\|`)
+	}
+	return "| " + strings.Join(cells, " | ") + " |", nil
+}
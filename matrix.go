@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"text/tabwriter"
+)
+
+// runMatrix runs every combination of --set and --goroutines lists, each
+// for --count iterations, then prints a table of mean throughput per
+// combination to stderr so it doesn't mix with the datapoints.
+func runMatrix(ctx context.Context, cfg *myConfig, rng *rand.Rand) int {
+	sets := cfg.MatrixSets
+	if sets == nil {
+		sets = []string{cfg.FileSetName}
+	}
+	counts := cfg.MatrixGoroutines
+	if counts == nil {
+		counts = []int{cfg.Goroutines}
+	}
+
+	throughput := make(map[string]map[int]float64, len(sets))
+	first := true
+	for _, set := range sets {
+		throughput[set] = make(map[int]float64, len(counts))
+		for _, n := range counts {
+			if !first && !cooldown(ctx, cfg) {
+				break
+			}
+			first = false
+
+			comboCfg := *cfg
+			comboCfg.FileSetName = set
+			comboCfg.Goroutines = n
+			debugf("matrix: %s with %d goroutines", set, n)
+
+			dps := runIterations(ctx, &comboCfg, rng)
+			var sum float64
+			for _, dp := range dps {
+				sum += dp.ThroughputMiBs
+			}
+			if len(dps) > 0 {
+				throughput[set][n] = sum / float64(len(dps))
+			}
+		}
+		if ctx.Err() != nil {
+			warnf("max-duration of %v reached; matrix is incomplete", cfg.MaxDuration)
+			break
+		}
+	}
+
+	tw := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(tw, "MiB/s\t")
+	for _, n := range counts {
+		fmt.Fprintf(tw, "%d\t", n)
+	}
+	fmt.Fprintln(tw)
+	for _, set := range sets {
+		fmt.Fprintf(tw, "%s\t", set)
+		for _, n := range counts {
+			cell := "-"
+			if v, ok := throughput[set][n]; ok {
+				cell = strconv.FormatFloat(v, 'f', 1, 64)
+			}
+			fmt.Fprintf(tw, "%s\t", cell)
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+
+	return 0
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// memSampler tracks allocation and garbage collection during a run.  Many
+// goroutines fetching big objects can put enough pressure on the GC to cap
+// throughput.  ReadMemStats stops the world briefly, so the heap is only
+// sampled once a second for its peak.
+type memSampler struct {
+	sync.Mutex
+	start    runtime.MemStats
+	peakHeap uint64
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func startMemSampler() *memSampler {
+	ms := &memSampler{stop: make(chan struct{}), done: make(chan struct{})}
+	runtime.ReadMemStats(&ms.start)
+	ms.peakHeap = ms.start.HeapAlloc
+	go func() {
+		defer close(ms.done)
+		tick := time.NewTicker(time.Second)
+		defer tick.Stop()
+		var m runtime.MemStats
+		for {
+			select {
+			case <-tick.C:
+				runtime.ReadMemStats(&m)
+				ms.Lock()
+				if m.HeapAlloc > ms.peakHeap {
+					ms.peakHeap = m.HeapAlloc
+				}
+				ms.Unlock()
+			case <-ms.stop:
+				return
+			}
+		}
+	}()
+	return ms
+}
+
+// Stop ends sampling.
+func (ms *memSampler) Stop() {
+	close(ms.stop)
+	<-ms.done
+}
+
+// setMem reports allocation and GC since the sampler started or was last
+// taken, and starts afresh.
+func (ms *memSampler) setMem(dp *Datapoint) {
+	var now runtime.MemStats
+	runtime.ReadMemStats(&now)
+	ms.Lock()
+	defer ms.Unlock()
+
+	a := &ms.start
+	dp.AllocBytes = now.TotalAlloc - a.TotalAlloc
+	dp.Mallocs = now.Mallocs - a.Mallocs
+	dp.NumGC = now.NumGC - a.NumGC
+	dp.GCPauseTotalSecs = time.Duration(now.PauseTotalNs - a.PauseTotalNs).Seconds()
+	dp.PeakHeapBytes = ms.peakHeap
+	if now.HeapAlloc > dp.PeakHeapBytes {
+		dp.PeakHeapBytes = now.HeapAlloc
+	}
+
+	// PauseNs is a ring of the last 256 pauses; older ones are gone.
+	gcs := dp.NumGC
+	if gcs > uint32(len(now.PauseNs)) {
+		gcs = uint32(len(now.PauseNs))
+	}
+	var maxPause uint64
+	for i := uint32(0); i < gcs; i++ {
+		p := now.PauseNs[(now.NumGC-i+255)%256]
+		if p > maxPause {
+			maxPause = p
+		}
+	}
+	dp.GCPauseMaxSecs = time.Duration(maxPause).Seconds()
+
+	ms.start = now
+	ms.peakHeap = now.HeapAlloc
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// promBuckets are the upper bounds, in seconds, of the live latency
+// histogram served at /metrics.
+var promBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// promHistogram is a cumulative-over-the-process latency histogram in the
+// Prometheus style, for scraping long runs; unlike a Datapoint's, it's
+// never reset.
+type promHistogram struct {
+	sync.Mutex
+	counts []uint64 // by bucket, not cumulative; the last is +Inf
+	sum    float64
+}
+
+var promLatency = &promHistogram{counts: make([]uint64, len(promBuckets)+1)}
+
+func (h *promHistogram) Observe(secs float64) {
+	i := 0
+	for i < len(promBuckets) && secs > promBuckets[i] {
+		i++
+	}
+	h.Lock()
+	defer h.Unlock()
+	h.counts[i]++
+	h.sum += secs
+}
+
+// serveMetrics writes the live counters in the Prometheus text format.
+func serveMetrics(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	counter := func(name, help string, v int64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, v)
+	}
+	counter("s3skunk_requests_total", "Requests made.", expRequests.Value())
+	counter("s3skunk_bytes_read_total", "Body bytes read.", expBytesRead.Value())
+	counter("s3skunk_bytes_written_total", "Body bytes uploaded.", expBytesWritten.Value())
+	counter("s3skunk_errors_total", "Failed requests.", expErrors.Value())
+	counter("s3skunk_timeouts_total", "Requests that hit --request-timeout.", expTimeouts.Value())
+	fmt.Fprintf(w, "# HELP s3skunk_in_flight Requests in flight.\n# TYPE s3skunk_in_flight gauge\ns3skunk_in_flight %d\n", expInFlight.Value())
+
+	promLatency.Lock()
+	counts := append([]uint64(nil), promLatency.counts...)
+	sum := promLatency.sum
+	promLatency.Unlock()
+
+	const name = "s3skunk_request_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Download latency to response headers.\n# TYPE %s histogram\n", name, name)
+	var cum uint64
+	for i, le := range promBuckets {
+		cum += counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, strconv.FormatFloat(le, 'g', -1, 64), cum)
+	}
+	cum += counts[len(promBuckets)]
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, cum)
+	fmt.Fprintf(w, "%s_sum %s\n", name, strconv.FormatFloat(sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count %d\n", name, cum)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/influxdata/tdigest"
+)
+
+// parseSetMix parses a weighted mix of file sets like
+// "M001:0.7,M016:0.2,M064:0.1" into weights normalized to sum to 1.  A plain
+// set name isn't a mix, and gives nil.
+func parseSetMix(spec string) (map[string]float64, error) {
+	if !strings.Contains(spec, ":") {
+		return nil, nil
+	}
+
+	mix := make(map[string]float64)
+	var total float64
+	for _, part := range strings.Split(spec, ",") {
+		name, w, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return nil, fmt.Errorf("'%s' needs a weight, e.g. %s:0.5", part, name)
+		}
+		if _, ok := fileSets[name]; !ok {
+			return nil, fmt.Errorf("unknown file set '%s'", name)
+		}
+		if _, dup := mix[name]; dup {
+			return nil, fmt.Errorf("file set '%s' given twice", name)
+		}
+		weight, err := strconv.ParseFloat(w, 64)
+		if err != nil || weight <= 0 {
+			return nil, fmt.Errorf("bad weight '%s' for file set %s", w, name)
+		}
+		mix[name] = weight
+		total += weight
+	}
+
+	for name := range mix {
+		mix[name] /= total
+	}
+	return mix, nil
+}
+
+// setNames lists the file sets a run draws from, in sorted order.
+func setNames(cfg *myConfig) []string {
+	if cfg.SetWeights == nil {
+		return []string{cfg.FileSetName}
+	}
+	names := make([]string, 0, len(cfg.SetWeights))
+	for name := range cfg.SetWeights {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// meanObjectSize is the object size of the file set, or for a mix, the
+// weighted mean object size.
+func meanObjectSize(cfg *myConfig) int {
+	if cfg.SetWeights == nil {
+		return fileSets[cfg.FileSetName].Size
+	}
+	var mean float64
+	for name, w := range cfg.SetWeights {
+		mean += w * float64(fileSets[name].Size)
+	}
+	return int(mean)
+}
+
+// minObjectSize is the smallest object size a run can fetch.
+func minObjectSize(cfg *myConfig) int {
+	smallest := 0
+	for _, name := range setNames(cfg) {
+		if size := fileSets[name].Size; smallest == 0 || size < smallest {
+			smallest = size
+		}
+	}
+	return smallest
+}
+
+// keySet returns the file set a listed key belongs to.
+func keySet(cfg *myConfig, key string) string {
+	if cfg.SetWeights == nil {
+		return cfg.FileSetName
+	}
+	rel := strings.TrimPrefix(key, strings.TrimSuffix(cfg.Prefix, "/")+"/")
+	return strings.SplitN(rel, "/", 2)[0]
+}
+
+// buildMixedDownloadList draws keys from each file set of a mix in
+// proportion to its weight, until the download size is reached or, for
+// duration runs, until there are as many keys as the sets hold in total.
+func buildMixedDownloadList(cfg *myConfig, s3Client *s3.Client, rng *rand.Rand) ([]string, error) {
+	names := setNames(cfg)
+	lists := make(map[string][]string, len(names))
+	var available int
+	for _, name := range names {
+		files, err := listSetFiles(cfg, s3Client, name)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no S3 files found for file set %s under s3://%s/%s (see the list-sets command)", name, cfg.Bucket, cfg.Prefix)
+		}
+		rng.Shuffle(len(files), func(i, j int) {
+			files[i], files[j] = files[j], files[i]
+		})
+		lists[name] = files
+		available += len(files)
+	}
+
+	var files []string
+	picks := make(map[string]func() int, len(names))
+	for _, name := range names {
+		picks[name] = newKeyPicker(cfg, rng, len(lists[name]))
+	}
+	var total int
+	for {
+		if cfg.Duration > 0 && len(files) >= available {
+			return files, nil
+		}
+		if cfg.Duration == 0 && total >= cfg.DownloadSizeBytes {
+			return files, nil
+		}
+
+		// Pick a set by weight, then a key from it.
+		name := names[len(names)-1]
+		r := rng.Float64()
+		for _, n := range names {
+			if r < cfg.SetWeights[n] {
+				name = n
+				break
+			}
+			r -= cfg.SetWeights[n]
+		}
+		files = append(files, lists[name][picks[name]()])
+
+		if cfg.RangeSizeBytes > 0 {
+			total += cfg.RangeSizeBytes
+		} else {
+			total += fileSets[name].Size
+		}
+	}
+}
+
+// setDigests keeps a latency digest per file set for mixed runs.
+type setDigests struct {
+	sync.Mutex
+	digests map[string]*tdigest.TDigest
+}
+
+func newSetDigests() *setDigests {
+	return &setDigests{digests: make(map[string]*tdigest.TDigest)}
+}
+
+func (sd *setDigests) Add(set string, secs float64) {
+	sd.Lock()
+	defer sd.Unlock()
+	td := sd.digests[set]
+	if td == nil {
+		td = tdigest.NewWithCompression(1000)
+		sd.digests[set] = td
+	}
+	td.Add(secs, 1)
+}
+
+// reset drops all the digests, to start a fresh interval.
+func (sd *setDigests) reset() {
+	sd.Lock()
+	defer sd.Unlock()
+	sd.digests = make(map[string]*tdigest.TDigest)
+}
+
+// Quantiles reads the requested quantiles out of each set's digest.
+func (sd *setDigests) Quantiles(qs []float64) map[string]map[string]float64 {
+	sd.Lock()
+	defer sd.Unlock()
+	m := make(map[string]map[string]float64, len(sd.digests))
+	for set, td := range sd.digests {
+		m[set] = quantileMap(td, qs)
+	}
+	return m
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Batching and retry of --mongodb-uri inserts.  Results are flushed when a
+// batch fills, when the oldest has waited mongoFlushInterval, and at exit.
+const (
+	mongoBatchSize     = 100
+	mongoFlushInterval = 10 * time.Second
+	mongoAttempts      = 5
+	mongoTimeout       = 30 * time.Second
+)
+
+// resultID is a datapoint's MongoDB _id, an ObjectID made from its start
+// time when it's emitted.  It's in the JSON output as extended JSON, so a
+// mongoimport of results already inserted directly, or imported before,
+// fails on the duplicate _id rather than adding them again.
+type resultID primitive.ObjectID
+
+// newResultID makes a unique ID whose timestamp is t rather than now.  Many
+// results start in the same second (a --count summary shares its first
+// iteration's StartTime), so the rest of the ObjectID -- process-unique bytes
+// and a counter -- comes from a freshly generated one.
+func newResultID(t time.Time) resultID {
+	oid := primitive.NewObjectID()
+	binary.BigEndian.PutUint32(oid[0:4], uint32(t.Unix()))
+	return resultID(oid)
+}
+
+func (id resultID) Hex() string {
+	return primitive.ObjectID(id).Hex()
+}
+
+func (id resultID) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{"$oid": id.Hex()})
+}
+
+// UnmarshalJSON accepts extended JSON or a bare hex string.
+func (id *resultID) UnmarshalJSON(b []byte) error {
+	var ext struct {
+		OID string `json:"$oid"`
+	}
+	if err := json.Unmarshal(b, &ext.OID); err != nil {
+		if err := json.Unmarshal(b, &ext); err != nil {
+			return err
+		}
+	}
+	oid, err := primitive.ObjectIDFromHex(ext.OID)
+	if err != nil {
+		return err
+	}
+	*id = resultID(oid)
+	return nil
+}
+
+// mongoResults inserts datapoints into the --mongodb-collection.
+type mongoResults struct {
+	client *mongo.Client
+	coll   *mongo.Collection
+
+	sync.Mutex
+	pending []interface{}
+	timer   *time.Timer // flushes pending once the oldest has waited mongoFlushInterval
+	err     error       // from a background flush that gave up, for the next Add
+
+	inserting sync.Mutex // held by a flush while it inserts, so flushes go in turn
+}
+
+// results is the --mongodb-uri destination, connected on first use.
+var results *mongoResults
+
+// splitNamespace splits a "database.collection" name.
+func splitNamespace(ns string) (string, string, error) {
+	db, coll, ok := strings.Cut(ns, ".")
+	if !ok || db == "" || coll == "" {
+		return "", "", fmt.Errorf("'%s' is not of the form database.collection", ns)
+	}
+	return db, coll, nil
+}
+
+func connectMongo(cfg *myConfig) (*mongoResults, error) {
+	db, coll, err := splitNamespace(cfg.MongoDBCollection)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.MongoDBURI))
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		client.Disconnect(ctx)
+		return nil, err
+	}
+	return &mongoResults{client: client, coll: client.Database(db).Collection(coll)}, nil
+}
+
+// Add queues a datapoint, already formatted as JSON, for insertion.  The
+// document is parsed from the JSON, so it's the same as mongoimport would
+// make of the output.  Inserts happen in the background, so a slow or
+// unreachable MongoDB doesn't hold up the benchmark; should one give up,
+// its error is returned by the next Add.
+func (mr *mongoResults) Add(jb []byte) error {
+	var doc bson.D
+	if err := bson.UnmarshalExtJSON(jb, false, &doc); err != nil {
+		return err
+	}
+	mr.Lock()
+	defer mr.Unlock()
+	if err := mr.err; err != nil {
+		mr.err = nil
+		return err
+	}
+	mr.pending = append(mr.pending, doc)
+	if len(mr.pending) >= mongoBatchSize {
+		if mr.timer != nil {
+			mr.timer.Stop()
+			mr.timer = nil
+		}
+		go mr.flushBackground()
+	} else if mr.timer == nil {
+		mr.timer = time.AfterFunc(mongoFlushInterval, mr.flushBackground)
+	}
+	return nil
+}
+
+// flushBackground flushes when a batch fills or the oldest result has
+// waited mongoFlushInterval.  Should it fail, the results stay pending for
+// a later flush, or the one at exit, to retry: calling fatalf here would
+// deadlock with that flush.
+func (mr *mongoResults) flushBackground() {
+	if err := mr.Flush(); err != nil {
+		mr.Lock()
+		defer mr.Unlock()
+		warnf("error inserting %d results into MongoDB; will retry: %v", len(mr.pending), err)
+		mr.err = err
+	}
+}
+
+// Flush inserts pending datapoints, retrying with backoff.  mr is only
+// locked to take the batch, so Add carries on meanwhile.  A retry may find
+// some of a batch inserted already; their duplicate _ids are no error.
+func (mr *mongoResults) Flush() error {
+	mr.inserting.Lock()
+	defer mr.inserting.Unlock()
+
+	mr.Lock()
+	if mr.timer != nil {
+		mr.timer.Stop()
+		mr.timer = nil
+	}
+	batch := mr.pending
+	mr.pending = nil
+	mr.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	var err error
+	backoff := time.Second
+	for attempt := 1; attempt <= mongoAttempts; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+		_, err = mr.coll.InsertMany(ctx, batch, options.InsertMany().SetOrdered(false))
+		cancel()
+		if err == nil || onlyDuplicates(err) {
+			return nil
+		}
+		if attempt < mongoAttempts {
+			warnf("error inserting %d results (attempt %d of %d; retrying in %v): %v", len(batch), attempt, mongoAttempts, backoff, err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	mr.Lock()
+	mr.pending = append(batch, mr.pending...)
+	mr.Unlock()
+	return err
+}
+
+// onlyDuplicates reports whether every failure of an unordered insert was a
+// duplicate _id.
+func onlyDuplicates(err error) bool {
+	var bwe mongo.BulkWriteException
+	if !errors.As(err, &bwe) || bwe.WriteConcernError != nil || len(bwe.WriteErrors) == 0 {
+		return false
+	}
+	for _, we := range bwe.WriteErrors {
+		if we.Code != 11000 {
+			return false
+		}
+	}
+	return true
+}
+
+// insertResult sends a datapoint to --mongodb-uri, if given.
+func insertResult(cfg *myConfig, dp Datapoint) error {
+	if cfg.MongoDBURI == "" {
+		return nil
+	}
+	if results == nil {
+		mr, err := connectMongo(cfg)
+		if err != nil {
+			return fmt.Errorf("connecting: %w", err)
+		}
+		results = mr
+	}
+	jb, err := json.Marshal(dp)
+	if err != nil {
+		return err
+	}
+	return results.Add(jb)
+}
+
+// closeResults inserts any results still pending and disconnects.
+func closeResults() error {
+	if results == nil {
+		return nil
+	}
+	mr := results
+	results = nil
+	err := mr.Flush()
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	mr.client.Disconnect(ctx)
+	return err
+}
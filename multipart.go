@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/spf13/pflag"
+)
+
+// Multipart upload limits.
+const (
+	MinPartSize = 5 * MiB
+	MaxParts    = 10000
+)
+
+func parseMultipartFlags(args []string) *myConfig {
+	cfg := &myConfig{}
+	fs := pflag.NewFlagSet("multipart", pflag.ExitOnError)
+	addS3Flags(fs, cfg)
+	fs.IntVar(&cfg.Count, "count", 1, "number of datapoints to generate per combination")
+	fs.StringVar(&cfg.EC2Instance, "instance", "", "EC2 instance type (default: detected from instance metadata)")
+	objectSize := fs.Uint("object-size", 1024, "size of each uploaded object in MiB")
+	fs.IntSliceVar(&cfg.PartSizes, "part-sizes", []int{5, 8, 16, 32, 64, 128}, "part sizes in MiB to sweep")
+	fs.IntSliceVar(&cfg.PartConcurrency, "part-concurrency", []int{1, 2, 4, 8, 16}, "parts in flight per object to sweep")
+	fs.StringVar(&cfg.ScratchPrefix, "scratch-prefix", "s3skunk-scratch", "prefix to upload under; each object gets a fresh key")
+	fs.BoolVar(&cfg.KeepScratch, "keep", false, "don't delete uploaded objects")
+	addResultFlags(fs, cfg)
+	parseFlags(fs, args)
+
+	validateS3Flags(cfg)
+	validateResultFlags(cfg)
+
+	cfg.ObjectSizeBytes = int(*objectSize) * MiB
+	if cfg.ObjectSizeBytes == 0 {
+		fatalf("object-size must be at least 1 MiB")
+	}
+
+	for _, ps := range cfg.PartSizes {
+		size := ps * MiB
+		if size < MinPartSize {
+			fatalf("part size %d MiB is below the S3 minimum of %d MiB", ps, MinPartSize/MiB)
+		}
+		if parts := (cfg.ObjectSizeBytes + size - 1) / size; parts > MaxParts {
+			fatalf("part size %d MiB would need %d parts, more than the S3 limit of %d", ps, parts, MaxParts)
+		}
+	}
+	for _, pc := range cfg.PartConcurrency {
+		if pc < 1 {
+			fatalf("part concurrency must be at least 1")
+		}
+	}
+
+	// Keep the scratch area well away from the file sets themselves.
+	if path.Clean(cfg.ScratchPrefix) == path.Clean(cfg.Prefix) {
+		fatalf("scratch-prefix must differ from prefix")
+	}
+
+	return cfg
+}
+
+// multipartUpload uploads one object of cfg.ObjectSizeBytes in parts of
+// partSize, with up to concurrency parts in flight, all read from buf.  Part
+// latencies go to latency.
+func multipartUpload(ctx context.Context, cfg *myConfig, s3Client *s3.Client, key string, buf []byte, partSize, concurrency int, latency chan<- float64) error {
+	mpu, err := s3Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(cfg.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+
+	numParts := (cfg.ObjectSizeBytes + partSize - 1) / partSize
+	parts := make([]types.CompletedPart, numParts)
+	work := make(chan int, concurrency)
+	go func() {
+		for i := 0; i < numParts; i++ {
+			work <- i
+		}
+		close(work)
+	}()
+
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var partErr error
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range work {
+				// Every part is a slice of the same random buffer; the last
+				// one may be short.
+				size := partSize
+				if rem := cfg.ObjectSizeBytes - n*partSize; rem < size {
+					size = rem
+				}
+
+				start := time.Now()
+				resp, err := s3Client.UploadPart(ctx, &s3.UploadPartInput{
+					Bucket:        aws.String(cfg.Bucket),
+					Key:           aws.String(key),
+					UploadId:      mpu.UploadId,
+					PartNumber:    int32(n + 1),
+					Body:          bytes.NewReader(buf[:size]),
+					ContentLength: int64(size),
+				})
+				expRequests.Add(1)
+				if err != nil {
+					expErrors.Add(1)
+					errOnce.Do(func() { partErr = fmt.Errorf("part %d: %w", n+1, err) })
+					continue
+				}
+				latency <- time.Since(start).Seconds()
+				expBytesWritten.Add(int64(size))
+				parts[n] = types.CompletedPart{ETag: resp.ETag, PartNumber: int32(n + 1)}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if partErr != nil {
+		_, abortErr := s3Client.AbortMultipartUpload(context.Background(), &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(cfg.Bucket),
+			Key:      aws.String(key),
+			UploadId: mpu.UploadId,
+		})
+		if abortErr != nil {
+			warnf("error aborting multipart upload of %s: %v", key, abortErr)
+		}
+		return partErr
+	}
+
+	_, err = s3Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(cfg.Bucket),
+		Key:             aws.String(key),
+		UploadId:        mpu.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	return err
+}
+
+// runMultipartOnce uploads one object with the given part size and
+// concurrency and emits a datapoint for it.
+func runMultipartOnce(cfg *myConfig, s3Client *s3.Client, buf []byte, partSize, concurrency int) {
+	key := path.Join(cfg.ScratchPrefix, "multipart", strconv.FormatInt(time.Now().UnixNano(), 10))
+
+	latency := make(chan float64, concurrency)
+	latencyDone := make(chan struct{})
+	td := newLatencyDigest()
+	go func() {
+		for v := range latency {
+			td.Add(v)
+		}
+		close(latencyDone)
+	}()
+
+	tracker := newConnTracker()
+	ctx := withConnTracker(context.Background(), tracker)
+	startTime := time.Now()
+
+	err := multipartUpload(ctx, cfg, s3Client, key, buf, partSize, concurrency, latency)
+	if err != nil {
+		fatalf("error uploading %s: %v", key, err)
+	}
+
+	elapsedSec := time.Since(startTime).Seconds()
+	close(latency)
+	<-latencyDone
+
+	datapoint := baseDatapoint(cfg)
+	datapoint.Operation = "multipart"
+	datapoint.FileSizeBytes = cfg.ObjectSizeBytes
+	datapoint.Goroutines = concurrency
+	datapoint.PartConcurrency = concurrency
+	datapoint.PartSizeBytes = partSize
+	datapoint.TotalSizeBytes = cfg.ObjectSizeBytes
+
+	datapoint.StartTime = startTime
+	datapoint.ElapsedSecs = elapsedSec
+	datapoint.setLatencies(td, cfg.Quantiles)
+	datapoint.ThroughputMiBs = float64(cfg.ObjectSizeBytes) / MiB / elapsedSec
+	datapoint.setConnStats(tracker, elapsedSec)
+
+	emitDatapoint(cfg, datapoint)
+
+	if cfg.KeepScratch {
+		return
+	}
+	_, err = s3Client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String(cfg.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		fatalf("error cleaning up s3://%s/%s: %v", cfg.Bucket, key, err)
+	}
+}
+
+// runMultipart benchmarks multipart uploads of a single large object across
+// a matrix of part sizes and per-object part concurrency, to help choose
+// transfer manager settings.
+func runMultipart(args []string) int {
+	cfg := parseMultipartFlags(args)
+	prepareRun(cfg)
+
+	// One random buffer, as large as the biggest part, backs every part.
+	maxPart := 0
+	for _, ps := range cfg.PartSizes {
+		if ps*MiB > maxPart {
+			maxPart = ps * MiB
+		}
+	}
+	if maxPart > cfg.ObjectSizeBytes {
+		maxPart = cfg.ObjectSizeBytes
+	}
+	buf := make([]byte, maxPart)
+	rand.New(rand.NewSource(cfg.Seed)).Read(buf)
+
+	for _, ps := range cfg.PartSizes {
+		for _, pc := range cfg.PartConcurrency {
+			for i := 0; i < cfg.Count; i++ {
+				s3Client, err := configS3(cfg)
+				if err != nil {
+					fatalf("error configuring S3: %v", err)
+				}
+				debugf("multipart upload of %d MiB with %d MiB parts, %d in flight", cfg.ObjectSizeBytes/MiB, ps, pc)
+				runMultipartOnce(cfg, s3Client, buf, ps*MiB, pc)
+			}
+		}
+	}
+
+	return 0
+}
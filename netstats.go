@@ -0,0 +1,62 @@
+package main
+
+import "sync"
+
+// netCounters are cumulative counters for all network interfaces but
+// loopback, and for TCP as a whole.
+type netCounters struct {
+	rxBytes, rxPackets, rxDrops uint64
+	txBytes, txPackets, txDrops uint64
+	tcpOutSegs, tcpRetransSegs  uint64
+	allowance                   map[string]uint64 // ethtool counters, by name
+}
+
+// netDelta reports what the NICs and TCP stack did over a run, which shows
+// protocol overhead and retransmission that S3 payload bytes don't.  The
+// counters are system-wide, so other traffic on the host shows up too.  It
+// needs readNetCounters, which is Linux only.
+type netDelta struct {
+	sync.Mutex
+	start netCounters
+}
+
+// startNetDelta reads the counters to start from, or returns nil where they
+// can't be read.
+func startNetDelta() *netDelta {
+	start, ok := readNetCounters()
+	if !ok {
+		return nil
+	}
+	return &netDelta{start: start}
+}
+
+// setNet reports the counters since the start or the last take, and starts
+// afresh.
+func (nd *netDelta) setNet(dp *Datapoint) {
+	now, ok := readNetCounters()
+	if !ok {
+		return
+	}
+	nd.Lock()
+	defer nd.Unlock()
+	a := nd.start
+	dp.NICRxBytes = now.rxBytes - a.rxBytes
+	dp.NICRxPackets = now.rxPackets - a.rxPackets
+	dp.NICRxDrops = now.rxDrops - a.rxDrops
+	dp.NICTxBytes = now.txBytes - a.txBytes
+	dp.NICTxPackets = now.txPackets - a.txPackets
+	dp.NICTxDrops = now.txDrops - a.txDrops
+	dp.TCPRetransSegs = now.tcpRetransSegs - a.tcpRetransSegs
+	if out := now.tcpOutSegs - a.tcpOutSegs; out > 0 {
+		dp.TCPRetransRate = float64(dp.TCPRetransSegs) / float64(out)
+	}
+	for name, v := range now.allowance {
+		if start := a.allowance[name]; v >= start {
+			if dp.NICAllowanceExceeded == nil {
+				dp.NICAllowanceExceeded = make(map[string]uint64)
+			}
+			dp.NICAllowanceExceeded[name] = v - start
+		}
+	}
+	nd.start = now
+}
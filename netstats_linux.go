@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// readNetCounters sums /proc/net/dev over every interface but lo, and reads
+// TCP segment counts from /proc/net/snmp and each interface's allowance
+// counters from ethtool, where its driver has them.
+func readNetCounters() (netCounters, bool) {
+	nc := netCounters{allowance: make(map[string]uint64)}
+	var ifaces []string
+
+	dev, err := os.ReadFile("/proc/net/dev")
+	if err != nil {
+		return nc, false
+	}
+	// Two header lines, then "iface: rx bytes packets errs drop fifo frame
+	// compressed multicast, tx bytes packets errs drop ...".
+	lines := strings.Split(string(dev), "\n")
+	if len(lines) < 2 {
+		return nc, false
+	}
+	for _, line := range lines[2:] {
+		name, counters, ok := strings.Cut(line, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "lo" {
+			continue
+		}
+		f := strings.Fields(counters)
+		if len(f) < 12 {
+			continue
+		}
+		v := make([]uint64, 12)
+		for i := range v {
+			v[i], _ = strconv.ParseUint(f[i], 10, 64)
+		}
+		nc.rxBytes += v[0]
+		nc.rxPackets += v[1]
+		nc.rxDrops += v[3]
+		nc.txBytes += v[8]
+		nc.txPackets += v[9]
+		nc.txDrops += v[11]
+		ifaces = append(ifaces, name)
+	}
+	readAllowanceCounters(ifaces, nc.allowance)
+
+	snmp, err := os.ReadFile("/proc/net/snmp")
+	if err != nil {
+		return nc, false
+	}
+	// A "Tcp:" line of names, then one of values.
+	var names []string
+	for _, line := range strings.Split(string(snmp), "\n") {
+		if !strings.HasPrefix(line, "Tcp:") {
+			continue
+		}
+		f := strings.Fields(line)[1:]
+		if names == nil {
+			names = f
+			continue
+		}
+		for i, name := range names {
+			if i >= len(f) {
+				break
+			}
+			switch name {
+			case "OutSegs":
+				nc.tcpOutSegs, _ = strconv.ParseUint(f[i], 10, 64)
+			case "RetransSegs":
+				nc.tcpRetransSegs, _ = strconv.ParseUint(f[i], 10, 64)
+			}
+		}
+		break
+	}
+	return nc, true
+}
+
+// From linux/ethtool.h: the string set naming a driver's statistics, and
+// the length of each name.
+const (
+	ethSSStats    = 1
+	ethGStringLen = 32
+)
+
+// readAllowanceCounters sets the ethtool statistics ending in
+// "_allowance_exceeded" of each interface in counts, keyed by
+// "iface/statistic".  On EC2 these are
+// ENA's bw_in_allowance_exceeded, pps_allowance_exceeded and the like:
+// packets queued or dropped because the instance went over its network
+// allowances.  The ioctls need no privileges; interfaces whose drivers
+// have no statistics are skipped.
+func readAllowanceCounters(ifaces []string, counts map[string]uint64) {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return
+	}
+	defer unix.Close(fd)
+
+	ne := binary.NativeEndian
+	for _, iface := range ifaces {
+		// How many statistics there are...
+		info := make([]byte, 20)
+		ne.PutUint32(info[0:], unix.ETHTOOL_GSSET_INFO)
+		ne.PutUint64(info[8:], 1<<ethSSStats)
+		if ethtoolIoctl(fd, iface, info) != nil || ne.Uint64(info[8:]) == 0 {
+			continue
+		}
+		n := ne.Uint32(info[16:])
+		if n == 0 {
+			continue
+		}
+
+		// ...their names...
+		names := make([]byte, 12+n*ethGStringLen)
+		ne.PutUint32(names[0:], unix.ETHTOOL_GSTRINGS)
+		ne.PutUint32(names[4:], ethSSStats)
+		ne.PutUint32(names[8:], n)
+		if ethtoolIoctl(fd, iface, names) != nil {
+			continue
+		}
+
+		// ...and their values.
+		stats := make([]byte, 8+n*8)
+		ne.PutUint32(stats[0:], unix.ETHTOOL_GSTATS)
+		ne.PutUint32(stats[4:], n)
+		if ethtoolIoctl(fd, iface, stats) != nil {
+			continue
+		}
+		parseAllowanceCounters(iface, names, stats, counts)
+	}
+}
+
+// parseAllowanceCounters picks the allowance counters out of the replies to
+// ETHTOOL_GSTRINGS, names, and ETHTOOL_GSTATS, stats: a struct
+// ethtool_gstrings, its NUL-padded names ethGStringLen bytes each after a
+// 12-byte header, and a struct ethtool_stats, its values after an 8-byte
+// header.  Each reply has its own count, and only the names with values are
+// used.
+func parseAllowanceCounters(iface string, names, stats []byte, counts map[string]uint64) {
+	ne := binary.NativeEndian
+	if len(names) < 12 || len(stats) < 8 {
+		return
+	}
+	n := min(ne.Uint32(names[8:]), ne.Uint32(stats[4:]))
+	n = min(n, uint32((len(names)-12)/ethGStringLen), uint32((len(stats)-8)/8))
+	for i := uint32(0); i < n; i++ {
+		name := names[12+i*ethGStringLen:][:ethGStringLen]
+		if end := bytes.IndexByte(name, 0); end >= 0 {
+			name = name[:end]
+		}
+		if strings.HasSuffix(string(name), "_allowance_exceeded") {
+			counts[iface+"/"+string(name)] = ne.Uint64(stats[8+i*8:])
+		}
+	}
+}
+
+// ethtoolIoctl makes the SIOCETHTOOL ioctl for iface, with buf, which starts
+// with the ethtool command, as its data.
+func ethtoolIoctl(fd int, iface string, buf []byte) error {
+	// struct ifreq: the interface name, then a union holding the data
+	// pointer, padded to its largest member.
+	var ifr struct {
+		name [unix.IFNAMSIZ]byte
+		data unsafe.Pointer
+		_    [24]byte
+	}
+	if len(iface) >= len(ifr.name) {
+		return unix.EINVAL
+	}
+	copy(ifr.name[:], iface)
+	ifr.data = unsafe.Pointer(&buf[0])
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), unix.SIOCETHTOOL, uintptr(unsafe.Pointer(&ifr)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
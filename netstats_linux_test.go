@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// ethtoolReplies builds ETHTOOL_GSTRINGS and ETHTOOL_GSTATS replies as the
+// kernel fills them in, with nstats values of the statistics named.
+func ethtoolReplies(names []string, values []uint64, nstats uint32) ([]byte, []byte) {
+	ne := binary.NativeEndian
+	gstrings := make([]byte, 12+len(names)*ethGStringLen)
+	ne.PutUint32(gstrings[8:], uint32(len(names)))
+	for i, name := range names {
+		copy(gstrings[12+i*ethGStringLen:], name)
+	}
+	gstats := make([]byte, 8+len(values)*8)
+	ne.PutUint32(gstats[4:], nstats)
+	for i, v := range values {
+		ne.PutUint64(gstats[8+i*8:], v)
+	}
+	return gstrings, gstats
+}
+
+func TestParseAllowanceCounters(t *testing.T) {
+	names := []string{
+		"tx_timeout",
+		"bw_in_allowance_exceeded",
+		"bw_out_allowance_exceeded",
+		"pps_allowance_exceeded",
+		"conntrack_allowance_available",
+	}
+	values := []uint64{7, 100, 0, 1 << 40, 5}
+
+	tests := []struct {
+		name   string
+		nstats uint32
+		want   map[string]uint64
+	}{
+		{
+			name:   "all",
+			nstats: 5,
+			want: map[string]uint64{
+				"eth0/bw_in_allowance_exceeded":  100,
+				"eth0/bw_out_allowance_exceeded": 0,
+				"eth0/pps_allowance_exceeded":    1 << 40,
+			},
+		},
+		{
+			name:   "fewer values than names",
+			nstats: 2,
+			want: map[string]uint64{
+				"eth0/bw_in_allowance_exceeded": 100,
+			},
+		},
+		{
+			name:   "count beyond the buffer",
+			nstats: 99,
+			want: map[string]uint64{
+				"eth0/bw_in_allowance_exceeded":  100,
+				"eth0/bw_out_allowance_exceeded": 0,
+				"eth0/pps_allowance_exceeded":    1 << 40,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gstrings, gstats := ethtoolReplies(names, values, tt.nstats)
+			got := make(map[string]uint64)
+			parseAllowanceCounters("eth0", gstrings, gstats, got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAllowanceCountersPerInterface(t *testing.T) {
+	got := make(map[string]uint64)
+	gstrings, gstats := ethtoolReplies([]string{"pps_allowance_exceeded"}, []uint64{3}, 1)
+	parseAllowanceCounters("eth0", gstrings, gstats, got)
+	gstrings, gstats = ethtoolReplies([]string{"pps_allowance_exceeded"}, []uint64{4}, 1)
+	parseAllowanceCounters("eth1", gstrings, gstats, got)
+
+	want := map[string]uint64{
+		"eth0/pps_allowance_exceeded": 3,
+		"eth1/pps_allowance_exceeded": 4,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestParseAllowanceCountersShort(t *testing.T) {
+	got := make(map[string]uint64)
+	parseAllowanceCounters("eth0", make([]byte, 4), make([]byte, 4), got)
+	if len(got) != 0 {
+		t.Errorf("got %v from truncated replies", got)
+	}
+}
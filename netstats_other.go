@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+// readNetCounters isn't supported outside Linux, so NIC and TCP counters
+// aren't reported.
+func readNetCounters() (netCounters, bool) {
+	return netCounters{}, false
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// notifyMaxErrors bounds the errors listed in a notification.
+const notifyMaxErrors = 20
+
+// notifyTextResults bounds the results listed in a notification's text;
+// they're all in its results.
+const notifyTextResults = 10
+
+// notifyResult is a result's headline numbers, for a notification.
+type notifyResult struct {
+	Set            string
+	Operation      string
+	Goroutines     int
+	ThroughputMiBs float64
+	P50Latency     float64
+	P99Latency     float64
+	ErrorRate      float64
+	SLOPass        *bool `json:",omitempty"`
+}
+
+// notification is the body POSTed to --notify-url.  Text is a summary for
+// a Slack incoming webhook or any chat tool that takes the same; the other
+// fields are for webhooks that want to act on the outcome.
+type notification struct {
+	Text        string `json:"text"` // as Slack spells it
+	Status      string // "ok", or "failed" if the command exited non-zero
+	Command     string
+	ExitCode    int
+	Error       string `json:",omitempty"` // the fatal error, if that's what ended it
+	Host        string
+	Instance    string `json:",omitempty"`
+	InstanceAZ  string `json:",omitempty"`
+	ElapsedSecs float64
+	Results     []notifyResult
+	Errors      []string `json:",omitempty"` // errors logged along the way, such as failed --assert thresholds
+}
+
+// notifier sends a notification once the command finishes.  Long runs are
+// often left unattended, so it's sent whether the command succeeds or dies
+// with a fatal error.
+type notifier struct {
+	url     string
+	command string
+	start   time.Time
+	client  *http.Client
+
+	sync.Mutex
+	results  []notifyResult
+	errors   []string
+	instance string
+	az       string
+	once     sync.Once
+}
+
+// notify is the --notify-url notifier; nil without one.
+var notify *notifier
+
+// startNotify sets up notification for the running command.
+func startNotify(url string) {
+	notify = &notifier{
+		url:     url,
+		command: commandName,
+		start:   time.Now(),
+		results: []notifyResult{},
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// record adds an emitted result to the summary.
+func (n *notifier) record(dp Datapoint) {
+	if n == nil {
+		return
+	}
+	r := notifyResult{
+		Set:            dp.FileSizeLabel,
+		Operation:      dp.Operation,
+		Goroutines:     dp.Goroutines,
+		ThroughputMiBs: dp.ThroughputMiBs,
+		P50Latency:     dp.P50Latency,
+		P99Latency:     dp.P99Latency,
+		ErrorRate:      dp.ErrorRate,
+	}
+	if dp.SLO != nil {
+		pass := dp.SLO.Pass
+		r.SLOPass = &pass
+	}
+	n.Lock()
+	defer n.Unlock()
+	n.results = append(n.results, r)
+	if dp.EC2Instance != "" && dp.EC2Instance != "unknown" {
+		n.instance, n.az = dp.EC2Instance, dp.InstanceAZ
+	}
+}
+
+// noteError adds a logged error to the summary.
+func (n *notifier) noteError(msg string) {
+	if n == nil {
+		return
+	}
+	n.Lock()
+	defer n.Unlock()
+	if len(n.errors) < notifyMaxErrors {
+		n.errors = append(n.errors, msg)
+	}
+}
+
+// send POSTs the notification, once; later calls do nothing.  A failure to
+// notify is only a warning, as the run itself is done.
+func (n *notifier) send(code int, fatal string) {
+	if n == nil {
+		return
+	}
+	n.once.Do(func() {
+		body, err := json.Marshal(n.notification(code, fatal))
+		if err != nil {
+			warnf("error encoding notification: %v", err)
+			return
+		}
+		resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			warnf("error sending notification: %v", err)
+			return
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			warnf("error sending notification: %s", resp.Status)
+		}
+	})
+}
+
+func (n *notifier) notification(code int, fatal string) notification {
+	n.Lock()
+	defer n.Unlock()
+	host, _ := os.Hostname()
+	elapsed := time.Since(n.start)
+	nt := notification{
+		Status:      "ok",
+		Command:     n.command,
+		ExitCode:    code,
+		Error:       fatal,
+		Host:        host,
+		Instance:    n.instance,
+		InstanceAZ:  n.az,
+		ElapsedSecs: elapsed.Seconds(),
+		Results:     n.results,
+		Errors:      n.errors,
+	}
+	if code != 0 {
+		nt.Status = "failed"
+	}
+
+	where := host
+	if n.instance != "" {
+		where = fmt.Sprintf("%s (%s in %s)", host, n.instance, n.az)
+	}
+	var text strings.Builder
+	fmt.Fprintf(&text, "s3skunk %s on %s ", n.command, where)
+	if code == 0 {
+		fmt.Fprintf(&text, "finished after %s with %d results.", elapsed.Round(time.Second), len(n.results))
+	} else {
+		fmt.Fprintf(&text, "failed (exit %d) after %s with %d results.", code, elapsed.Round(time.Second), len(n.results))
+	}
+	if fatal != "" {
+		fmt.Fprintf(&text, "\nError: %s", fatal)
+	}
+	for i, r := range n.results {
+		if i == notifyTextResults {
+			fmt.Fprintf(&text, "\n... and %d more", len(n.results)-i)
+			break
+		}
+		fmt.Fprintf(&text, "\n%s %s x%d: %.1f MiB/s, p50 %.1f ms, p99 %.1f ms", r.Set, r.Operation, r.Goroutines,
+			r.ThroughputMiBs, r.P50Latency*1000, r.P99Latency*1000)
+		if r.ErrorRate > 0 {
+			fmt.Fprintf(&text, ", %.2f%% errors", r.ErrorRate*100)
+		}
+		if r.SLOPass != nil && !*r.SLOPass {
+			text.WriteString(", SLO missed")
+		}
+	}
+	for _, e := range n.errors {
+		fmt.Fprintf(&text, "\n%s", e)
+	}
+	if n.instance != "" {
+		text.WriteString("\nShut the instance down if you're done with it.")
+	}
+	nt.Text = text.String()
+	return nt
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// metadataOps are the --op choices besides "get": requests that return no
+// object body, so only their latency matters.
+var metadataOps = map[string]func(ctx context.Context, s3Client *s3.Client, bucket, key string) error{
+	"head":            headObject,
+	"conditional-get": conditionalGet,
+	"get-tagging":     getObjectTagging,
+	"get-acl":         getObjectACL,
+}
+
+func headObject(ctx context.Context, s3Client *s3.Client, bucket, key string) error {
+	_, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+func getObjectTagging(ctx context.Context, s3Client *s3.Client, bucket, key string) error {
+	_, err := s3Client.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+func getObjectACL(ctx context.Context, s3Client *s3.Client, bucket, key string) error {
+	_, err := s3Client.GetObjectAcl(ctx, &s3.GetObjectAclInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+// listedETags maps keys to the ETags seen when listing them, so conditional
+// GETs can revalidate without fetching anything first.
+var listedETags sync.Map
+
+// conditionalGet revalidates an object the way a cache would, with
+// If-None-Match set to the ETag from the listing, so S3 should answer 304 Not
+// Modified without a body.
+func conditionalGet(ctx context.Context, s3Client *s3.Client, bucket, key string) error {
+	etag, ok := listedETags.Load(key)
+	if !ok {
+		return errors.New("no ETag was listed for the object")
+	}
+	resp, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		IfNoneMatch: aws.String(etag.(string)),
+	})
+	var re *awshttp.ResponseError
+	if errors.As(err, &re) && re.HTTPStatusCode() == http.StatusNotModified {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return errors.New("expected 304 Not Modified but got the object")
+}
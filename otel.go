@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer makes a span for each request.  Until startTracing, it's a no-op.
+var tracer = otel.Tracer("github.com/xdg-go/s3skunk")
+
+// tracerProvider exports spans, with --otlp-endpoint.
+var tracerProvider *sdktrace.TracerProvider
+
+// startTracing exports a sample of request spans over OTLP/HTTP to
+// endpoint, an http:// or https:// URL of a collector.  The path defaults to
+// the standard /v1/traces.
+func startTracing(endpoint string, sample float64) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host)}
+	switch u.Scheme {
+	case "http":
+		opts = append(opts, otlptracehttp.WithInsecure())
+	case "https":
+	default:
+		return fmt.Errorf("'%s' is not an http:// or https:// URL", endpoint)
+	}
+	if u.Path != "" && u.Path != "/" {
+		opts = append(opts, otlptracehttp.WithURLPath(u.Path))
+	}
+
+	exp, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return err
+	}
+	tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithSampler(sdktrace.TraceIDRatioBased(sample)),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String("s3skunk"))),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	return nil
+}
+
+// shutdownTracing exports any spans still buffered.
+func shutdownTracing() error {
+	if tracerProvider == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return tracerProvider.Shutdown(ctx)
+}
+
+// spanError marks a request's span as failed.
+func spanError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// setResponseIDs adds the request IDs AWS support asks for to a request's
+// span.
+func setResponseIDs(span trace.Span, metadata middleware.Metadata) {
+	if id, ok := awsmiddleware.GetRequestIDMetadata(metadata); ok {
+		span.SetAttributes(attribute.String("aws.request_id", id))
+	}
+	if id, ok := s3.GetHostIDMetadata(metadata); ok {
+		span.SetAttributes(attribute.String("aws.s3.extended_request_id", id))
+	}
+}
+
+// addAttemptSpans adds middleware that makes a child span for each attempt
+// the SDK makes at a request, so retries show in a trace.
+func addAttemptSpans(stack *middleware.Stack) error {
+	return stack.Finalize.Insert(attemptSpans{}, "Retry", middleware.After)
+}
+
+type attemptSpans struct{}
+
+func (attemptSpans) ID() string { return "AttemptSpans" }
+
+func (attemptSpans) HandleFinalize(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (
+	out middleware.FinalizeOutput, metadata middleware.Metadata, err error,
+) {
+	ctx, span := tracer.Start(ctx, "attempt")
+	defer span.End()
+
+	out, metadata, err = next.HandleFinalize(ctx, in)
+	if resp, ok := awsmiddleware.GetRawResponse(metadata).(*smithyhttp.Response); ok {
+		span.SetAttributes(
+			attribute.Int("http.status_code", resp.StatusCode),
+			attribute.String("aws.request_id", resp.Header.Get("x-amz-request-id")),
+			attribute.String("aws.s3.extended_request_id", resp.Header.Get("x-amz-id-2")),
+		)
+	}
+	if err != nil {
+		spanError(span, err)
+	}
+	return out, metadata, err
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+	"sync"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/influxdata/tdigest"
+)
+
+// madScale turns a median absolute deviation into an estimate of the
+// standard deviation for normally distributed data.
+const madScale = 1.4826
+
+// trimmedMean returns the mean of the latencies between the trim and 1-trim
+// quantiles, estimated from the digest's centroids.
+func (ld *latencyDigest) trimmedMean(trim float64) float64 {
+	total := ld.Count()
+	if total == 0 {
+		return 0
+	}
+	lo, hi := trim*total, (1-trim)*total
+	var sum, weight, cum float64
+	for _, c := range ld.Centroids(nil) {
+		// The part of this centroid's weight inside [lo, hi].
+		w := math.Min(cum+c.Weight, hi) - math.Max(cum, lo)
+		cum += c.Weight
+		if w > 0 {
+			sum += c.Mean * w
+			weight += w
+		}
+	}
+	if weight == 0 {
+		return quantile(ld.TDigest, 0.5)
+	}
+	return sum / weight
+}
+
+// mad returns the median absolute deviation from the median latency,
+// estimated from the digest's centroids.
+func (ld *latencyDigest) mad() float64 {
+	if ld.Count() == 0 {
+		return 0
+	}
+	median := ld.Quantile(0.5)
+	devs := tdigest.NewWithCompression(1000)
+	for _, c := range ld.Centroids(nil) {
+		devs.Add(math.Abs(c.Mean-median), c.Weight)
+	}
+	return devs.Quantile(0.5)
+}
+
+// outliers estimates how many latencies are more than three scaled MADs
+// above the median, which unlike a threshold from the standard deviation
+// isn't dragged up by the outliers themselves.
+func (ld *latencyDigest) outliers() int {
+	total := ld.Count()
+	if total == 0 {
+		return 0
+	}
+	limit := ld.Quantile(0.5) + 3*madScale*ld.mad()
+	return int(math.Round(total * (1 - ld.CDF(limit))))
+}
+
+// slowRequest is one of the slowest GETs in a run, as included in JSON
+// results with --worst.
+type slowRequest struct {
+	Key       string
+	Latency   float64 // to response headers
+	RequestID string  `json:",omitempty"`
+	HostID    string  `json:",omitempty"` // S3's extended request ID
+}
+
+// slowHeap is a min-heap by latency, so the fastest of the worst is on top.
+type slowHeap []slowRequest
+
+func (h slowHeap) Len() int            { return len(h) }
+func (h slowHeap) Less(i, j int) bool  { return h[i].Latency < h[j].Latency }
+func (h slowHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *slowHeap) Push(x interface{}) { *h = append(*h, x.(slowRequest)) }
+func (h *slowHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// worstRequests keeps the k slowest GETs, with the request IDs needed to
+// ask AWS about them.
+type worstRequests struct {
+	sync.Mutex
+	k    int
+	heap slowHeap
+}
+
+func newWorstRequests(k int) *worstRequests {
+	return &worstRequests{k: k}
+}
+
+func (wr *worstRequests) Add(key string, secs float64, metadata middleware.Metadata) {
+	wr.Lock()
+	defer wr.Unlock()
+	if len(wr.heap) == wr.k && secs <= wr.heap[0].Latency {
+		return
+	}
+	req := slowRequest{Key: key, Latency: secs}
+	req.RequestID, _ = awsmiddleware.GetRequestIDMetadata(metadata)
+	req.HostID, _ = s3.GetHostIDMetadata(metadata)
+	heap.Push(&wr.heap, req)
+	if len(wr.heap) > wr.k {
+		heap.Pop(&wr.heap)
+	}
+}
+
+// take returns the slowest requests so far, slowest first, and starts
+// afresh.
+func (wr *worstRequests) take() []slowRequest {
+	wr.Lock()
+	defer wr.Unlock()
+	worst := []slowRequest(wr.heap)
+	wr.heap = nil
+	sort.Slice(worst, func(i, j int) bool { return worst[i].Latency > worst[j].Latency })
+	return worst
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// InfluxMeasurement is the measurement name used for line protocol output.
+const InfluxMeasurement = "s3bench"
+
+// InfluxSeriesMeasurement is the measurement for points within a run, from
+// --throughput-series and --latency-window, timestamped where they fall.
+const InfluxSeriesMeasurement = "s3bench_series"
+
+var outputFormats = map[string]func(Datapoint) (string, error){
+	"json":     formatJSON,
+	"influx":   formatInflux,
+	"csv":      formatCSV,
+	"markdown": formatMarkdown,
+}
+
+// outputHeaders are header lines for formats that need one, written before
+// the first result in an empty output.
+var outputHeaders = map[string]func() string{
+	"csv":      csvHeader,
+	"markdown": markdownHeader,
+}
+
+// wroteStdout records whether a result has gone to stdout yet, for headers.
+var wroteStdout bool
+
+// addResultFlags adds the flags shared by every command that emits
+// datapoints.
+func addResultFlags(fs *pflag.FlagSet, cfg *myConfig) {
+	fs.StringToStringVar(&cfg.Labels, "label", nil, "key=value label to attach to results (repeatable)")
+	fs.StringVar(&cfg.OutputFormat, "format", "json", "result format (json, influx, csv with a header row and only single-valued fields, or markdown for a summary table)")
+	fs.StringVar(&cfg.OutputFormat, "output-format", "json", "same as --format")
+	fs.MarkHidden("output-format")
+	fs.Float64SliceVar(&cfg.Quantiles, "quantiles", []float64{0.5, 0.95, 0.99}, "latency quantiles to report")
+	fs.Int64Var(&cfg.Seed, "seed", 0, "seed for shuffling and other random choices (0 picks one from the clock)")
+	fs.StringVar(&cfg.OutputFile, "output", "", "file to write results to, one per line (default stdout)")
+	fs.StringVar(&cfg.MetricsNamespace, "cloudwatch-namespace", "", "publish each result's throughput, latency quantiles and error rate as CloudWatch metrics in this namespace, by instance type, file set and operation")
+	fs.StringVar(&cfg.Store, "store", "", "SQLite database file to add each result to, for a queryable history of runs (created if need be)")
+	fs.StringVar(&cfg.MongoDBURI, "mongodb-uri", "", "MongoDB connection string to insert each result into, as well as writing it out")
+	fs.StringVar(&cfg.MongoDBCollection, "mongodb-collection", "s3bench.results", "database.collection for --mongodb-uri")
+	fs.StringVar(&cfg.OutputMode, "output-mode", "append", "whether --output is appended to or truncated first (append or truncate)")
+	fs.StringArrayVar(&cfg.SLOSpecs, "slo", nil, "service level objective to evaluate each result against, in --assert syntax; the verdict goes in the result (repeatable)")
+	fs.StringArrayVar(&cfg.AssertSpecs, "assert", nil, "threshold every result must meet, e.g. throughput>=400MiB/s, p99<=0.2s or error-rate<1%, else exit 1 (repeatable)")
+}
+
+func validateResultFlags(cfg *myConfig) {
+	if cfg.OutputMode != "append" && cfg.OutputMode != "truncate" {
+		fatalf("unknown output mode '%s'", cfg.OutputMode)
+	}
+
+	for _, q := range cfg.Quantiles {
+		if q < 0 || q > 1 {
+			fatalf("quantile %v is not between 0 and 1", q)
+		}
+	}
+
+	if _, ok := outputFormats[cfg.OutputFormat]; !ok {
+		fatalf("unknown output format '%s'", cfg.OutputFormat)
+	}
+
+	for k := range cfg.Labels {
+		if k == "" {
+			fatalf("--label needs a key")
+		}
+		for _, tag := range influxTags {
+			if k == tag {
+				fatalf("--label key '%s' is reserved", k)
+			}
+		}
+	}
+
+	if _, _, err := splitNamespace(cfg.MongoDBCollection); err != nil {
+		fatalf("bad mongodb-collection: %v", err)
+	}
+
+	for _, spec := range cfg.AssertSpecs {
+		a, err := parseAssertion(spec)
+		if err != nil {
+			fatalf("error parsing assert: %v", err)
+		}
+		cfg.Asserts = append(cfg.Asserts, a)
+	}
+	for _, spec := range cfg.SLOSpecs {
+		a, err := parseAssertion(spec)
+		if err != nil {
+			fatalf("error parsing slo: %v", err)
+		}
+		cfg.SLOs = append(cfg.SLOs, a)
+	}
+
+	// Always record a seed, so even unplanned runs can be reproduced.
+	if cfg.Seed == 0 {
+		cfg.Seed = time.Now().UnixNano()
+	}
+}
+
+// emitDatapoint formats a datapoint and writes it out.
+func emitDatapoint(cfg *myConfig, dp Datapoint) {
+	dp.ID = newResultID(dp.StartTime)
+	dp.SchemaVersion = SchemaVersion
+	dp.SLO = evaluateSLO(cfg, dp)
+	out, err := outputFormats[cfg.OutputFormat](dp)
+	if err != nil {
+		fatalf("error encoding datapoint as %s: %v", cfg.OutputFormat, err)
+	}
+
+	var header string
+	if h, ok := outputHeaders[cfg.OutputFormat]; ok {
+		header = h()
+	}
+	if err := writeResult(cfg, header, out); err != nil {
+		fatalf("error writing result: %v", err)
+	}
+	if err := publishMetrics(cfg, dp); err != nil {
+		fatalf("error publishing CloudWatch metrics: %v", err)
+	}
+	if err := storeResult(cfg, dp); err != nil {
+		fatalf("error adding result to store: %v", err)
+	}
+	if err := insertResult(cfg, dp); err != nil {
+		fatalf("error inserting result into MongoDB: %v", err)
+	}
+
+	checkAssertions(cfg, dp)
+	notify.record(dp)
+	dash.record(dp)
+}
+
+// prepareOutput readies the --output file, if any, before the first result
+// is written.  Appending needs no preparation; truncating empties it once up
+// front so that results from every iteration accumulate after that.
+func prepareOutput(cfg *myConfig) error {
+	if cfg.OutputMode != "truncate" {
+		return nil
+	}
+	for _, name := range []string{cfg.OutputFile, cfg.RawOutput} {
+		if name == "" {
+			continue
+		}
+		f, err := os.Create(name)
+		if err != nil {
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeResult writes a formatted result line to stdout or, with --output, to
+// the end of the output file under an exclusive lock.  A header, if any, goes
+// first when nothing has been written there yet.
+func writeResult(cfg *myConfig, header, line string) error {
+	if cfg.OutputFile == "" {
+		if header != "" && !wroteStdout {
+			line = header + "\n" + line
+		}
+		wroteStdout = true
+		_, err := fmt.Println(line)
+		return err
+	}
+
+	f, err := os.OpenFile(cfg.OutputFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := lockFile(f); err != nil {
+		return err
+	}
+	defer unlockFile(f)
+
+	if header != "" {
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if info.Size() == 0 {
+			line = header + "\n" + line
+		}
+	}
+	_, err = f.WriteString(line + "\n")
+	return err
+}
+
+func formatJSON(dp Datapoint) (string, error) {
+	jb, err := json.Marshal(dp)
+	if err != nil {
+		return "", err
+	}
+	return string(jb), nil
+}
+
+// influxTags are the tags formatInflux gives every line, which --label keys
+// can't reuse.
+var influxTags = []string{"instance", "set", "goroutines", "op", "summary"}
+
+// formatInflux renders a datapoint as a single line of InfluxDB line
+// protocol, timestamped with the start of the run.
+func formatInflux(dp Datapoint) (string, error) {
+	tagValues := map[string]string{
+		"instance":   influxEscape(dp.EC2Instance),
+		"set":        influxEscape(dp.FileSizeLabel),
+		"goroutines": strconv.Itoa(dp.Goroutines),
+		"op":         influxEscape(dp.Operation),
+	}
+
+	// A --count summary shares its first iteration's timestamp, so it needs
+	// a tag of its own not to overwrite it.
+	if dp.Iterations > 0 {
+		tagValues["summary"] = "true"
+	}
+
+	// Labels become extra tags.  Influx has no empty tag values, so labels
+	// without one are left out, and none replaces the tags above.
+	for k, v := range dp.Labels {
+		if _, fixed := tagValues[influxEscape(k)]; v != "" && !fixed {
+			tagValues[influxEscape(k)] = influxEscape(v)
+		}
+	}
+
+	// All in sorted order, as Influx prefers.
+	tagKeys := make([]string, 0, len(tagValues))
+	for k := range tagValues {
+		tagKeys = append(tagKeys, k)
+	}
+	sort.Strings(tagKeys)
+	tags := make([]string, len(tagKeys))
+	for i, k := range tagKeys {
+		tags[i] = k + "=" + tagValues[k]
+	}
+
+	fields := []string{
+		influxFloat("throughput_mibs", dp.ThroughputMiBs),
+		influxFloat("p50_latency", dp.P50Latency),
+		influxFloat("p95_latency", dp.P95Latency),
+		influxFloat("p99_latency", dp.P99Latency),
+		influxFloat("min_latency", dp.MinLatency),
+		influxFloat("max_latency", dp.MaxLatency),
+		influxFloat("mean_latency", dp.MeanLatency),
+		influxFloat("stddev_latency", dp.StdDev),
+		influxFloat("trimmed_mean_latency", dp.TrimmedMeanLatency),
+		influxInt("outliers", dp.Outliers),
+		influxInt("request_count", dp.RequestCount),
+		influxFloat("full_p50_latency", dp.FullP50Latency),
+		influxFloat("full_p95_latency", dp.FullP95Latency),
+		influxFloat("full_p99_latency", dp.FullP99Latency),
+		influxFloat("queue_p99_delay", dp.QueueP99Delay),
+		influxFloat("worker_min_mibs", dp.WorkerMinMiBs),
+		influxFloat("worker_max_mibs", dp.WorkerMaxMiBs),
+		influxFloat("process_cpu_pct", dp.ProcessCPUPct),
+		influxFloat("system_busy_peak_pct", dp.SystemBusyPeakPct),
+		influxFloat("gc_pause_total_secs", dp.GCPauseTotalSecs),
+		influxInt("peak_heap_bytes", int(dp.PeakHeapBytes)),
+		influxInt("nic_rx_bytes", int(dp.NICRxBytes)),
+		influxFloat("tcp_retrans_rate", dp.TCPRetransRate),
+		influxFloat("estimated_cost_usd", dp.EstimatedCostUSD),
+		influxInt("schema_version", dp.SchemaVersion),
+		influxFloat("elapsed_secs", dp.ElapsedSecs),
+		influxInt("file_size_bytes", dp.FileSizeBytes),
+		influxInt("total_size_bytes", dp.TotalSizeBytes),
+		influxInt("conns_established", dp.ConnsEstablished),
+		influxFloat("conns_per_sec", dp.ConnsPerSec),
+		influxFloat("objects_per_conn", dp.ObjectsPerConn),
+		influxFloat("conn_reuse_rate", dp.ConnReuseRate),
+		influxFloat("server_p50_latency", dp.ServerP50Latency),
+		influxFloat("server_p99_latency", dp.ServerP99Latency),
+		influxFloat("ttfb_p99_latency", dp.TTFBP99Latency),
+		influxInt("timeouts", dp.Timeouts),
+		influxInt("errors", dp.Errors),
+		influxFloat("error_rate", dp.ErrorRate),
+		influxInt("retries", dp.Retries),
+		influxInt("slow_downs", dp.SlowDowns),
+		influxFloat("backoff_secs", dp.BackoffSecs),
+		"truncated=" + strconv.FormatBool(dp.Truncated),
+	}
+	if dp.SLO != nil {
+		fields = append(fields, "slo_pass="+strconv.FormatBool(dp.SLO.Pass))
+	}
+
+	qKeys := make([]string, 0, len(dp.Quantiles))
+	for k := range dp.Quantiles {
+		qKeys = append(qKeys, k)
+	}
+	sort.Strings(qKeys)
+	for _, k := range qKeys {
+		fields = append(fields, influxFloat("latency_q"+k, dp.Quantiles[k]))
+	}
+
+	lines := []string{fmt.Sprintf("%s,%s %s %d",
+		InfluxMeasurement,
+		strings.Join(tags, ","),
+		strings.Join(fields, ","),
+		dp.StartTime.UnixNano(),
+	)}
+
+	// Points within the run share its tags.  Where a second and a window
+	// start together, Influx merges their fields.
+	seriesLine := func(at time.Time, fields ...string) {
+		lines = append(lines, fmt.Sprintf("%s,%s %s %d",
+			InfluxSeriesMeasurement,
+			strings.Join(tags, ","),
+			strings.Join(fields, ","),
+			at.UnixNano(),
+		))
+	}
+	for i, v := range dp.ThroughputSeries {
+		seriesLine(dp.StartTime.Add(time.Duration(i)*time.Second), influxFloat("throughput_mibs", v))
+	}
+	for _, w := range dp.LatencyWindows {
+		seriesLine(dp.StartTime.Add(time.Duration(w.StartSecs*float64(time.Second))),
+			influxInt("requests", w.Requests),
+			influxFloat("p50_latency", w.P50Latency),
+			influxFloat("p99_latency", w.P99Latency),
+		)
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// influxEscape escapes a tag value per the line protocol rules.
+func influxEscape(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `).Replace(s)
+}
+
+func influxFloat(k string, v float64) string {
+	return k + "=" + strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func influxInt(k string, v int) string {
+	return k + "=" + strconv.Itoa(v) + "i"
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+)
+
+// parquetRowGroupRows bounds how many raw records are held in memory before
+// they're written out as a row group.
+const parquetRowGroupRows = 128 * 1024
+
+// Parquet enum values used here, from parquet.thrift.
+const (
+	parquetBoolean   = 0
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetUTF8            = 0
+	parquetTimestampMicros = 10
+
+	parquetRequired = 0
+	parquetPlain    = 0
+	parquetRLE      = 3
+	parquetGzip     = 2
+	parquetDataPage = 0
+)
+
+// parquetColumn is a column of rawRecords and its PLAIN encoding.
+type parquetColumn struct {
+	name      string
+	typ       int32
+	converted int32 // -1 for none
+	encode    func(b *bytes.Buffer, recs []rawRecord)
+}
+
+var rawParquetColumns = []parquetColumn{
+	{"Key", parquetByteArray, parquetUTF8, func(b *bytes.Buffer, recs []rawRecord) {
+		for _, r := range recs {
+			binary.Write(b, binary.LittleEndian, uint32(len(r.Key)))
+			b.WriteString(r.Key)
+		}
+	}},
+	{"SizeBytes", parquetInt64, -1, func(b *bytes.Buffer, recs []rawRecord) {
+		for _, r := range recs {
+			binary.Write(b, binary.LittleEndian, r.SizeBytes)
+		}
+	}},
+	{"Start", parquetInt64, parquetTimestampMicros, func(b *bytes.Buffer, recs []rawRecord) {
+		for _, r := range recs {
+			binary.Write(b, binary.LittleEndian, r.Start.UnixMicro())
+		}
+	}},
+	{"TTFBSecs", parquetDouble, -1, func(b *bytes.Buffer, recs []rawRecord) {
+		for _, r := range recs {
+			binary.Write(b, binary.LittleEndian, math.Float64bits(r.TTFBSecs))
+		}
+	}},
+	{"TotalSecs", parquetDouble, -1, func(b *bytes.Buffer, recs []rawRecord) {
+		for _, r := range recs {
+			binary.Write(b, binary.LittleEndian, math.Float64bits(r.TotalSecs))
+		}
+	}},
+	{"Attempts", parquetInt32, -1, func(b *bytes.Buffer, recs []rawRecord) {
+		for _, r := range recs {
+			binary.Write(b, binary.LittleEndian, int32(r.Attempts))
+		}
+	}},
+	{"Status", parquetInt32, -1, func(b *bytes.Buffer, recs []rawRecord) {
+		for _, r := range recs {
+			binary.Write(b, binary.LittleEndian, int32(r.Status))
+		}
+	}},
+	{"TimedOut", parquetBoolean, -1, func(b *bytes.Buffer, recs []rawRecord) {
+		// Bit-packed, least significant bit first.
+		packed := make([]byte, (len(recs)+7)/8)
+		for i, r := range recs {
+			if r.TimedOut {
+				packed[i/8] |= 1 << (i % 8)
+			}
+		}
+		b.Write(packed)
+	}},
+}
+
+// parquetChunk locates a column chunk for the footer.
+type parquetChunk struct {
+	offset           int64
+	uncompressedSize int64
+	compressedSize   int64
+}
+
+type parquetRowGroup struct {
+	chunks []parquetChunk
+	rows   int64
+}
+
+// parquetWriter writes rawRecords to a Parquet file: all columns required,
+// PLAIN encoded, in gzipped pages of one per column per row group.  Parquet
+// can't be appended to, so the file is written afresh and only readable
+// once closed.  Records added after it's closed, as when fatalf closes it
+// while requests are still in flight, are dropped.
+type parquetWriter struct {
+	sync.Mutex
+	closed    bool
+	f         *os.File
+	w         *bufio.Writer
+	offset    int64
+	pending   []rawRecord
+	rowGroups []parquetRowGroup
+	rows      int64
+}
+
+func newParquetWriter(name string) (*parquetWriter, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	pw := &parquetWriter{f: f, w: bufio.NewWriter(f)}
+	if err := pw.write([]byte("PAR1")); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return pw, nil
+}
+
+func (pw *parquetWriter) write(b []byte) error {
+	n, err := pw.w.Write(b)
+	pw.offset += int64(n)
+	return err
+}
+
+// parquetSize checks that a size fits the int32 Parquet's metadata has for
+// it.
+func parquetSize(what string, n int) (int32, error) {
+	if n > math.MaxInt32 {
+		return 0, fmt.Errorf("parquet %s of %d bytes is too large", what, n)
+	}
+	return int32(n), nil
+}
+
+func (pw *parquetWriter) Add(rec rawRecord) error {
+	pw.Lock()
+	defer pw.Unlock()
+	if pw.closed {
+		return nil
+	}
+	pw.pending = append(pw.pending, rec)
+	if len(pw.pending) >= parquetRowGroupRows {
+		return pw.flush()
+	}
+	return nil
+}
+
+// flush writes pending records as a row group.
+func (pw *parquetWriter) flush() error {
+	if len(pw.pending) == 0 {
+		return nil
+	}
+	rg := parquetRowGroup{rows: int64(len(pw.pending))}
+	var plain, gz bytes.Buffer
+	for _, col := range rawParquetColumns {
+		plain.Reset()
+		gz.Reset()
+		col.encode(&plain, pw.pending)
+		zw := gzip.NewWriter(&gz)
+		if _, err := zw.Write(plain.Bytes()); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		plainSize, err := parquetSize(col.name+" page", plain.Len())
+		if err != nil {
+			return err
+		}
+		gzSize, err := parquetSize(col.name+" page", gz.Len())
+		if err != nil {
+			return err
+		}
+
+		var t thriftCompact
+		t.i32(1, parquetDataPage)
+		t.i32(2, plainSize)
+		t.i32(3, gzSize)
+		t.structBegin(5)
+		t.i32(1, int32(len(pw.pending)))
+		t.i32(2, parquetPlain)
+		t.i32(3, parquetRLE)
+		t.i32(4, parquetRLE)
+		t.structEnd()
+		t.stop()
+
+		rg.chunks = append(rg.chunks, parquetChunk{
+			offset:           pw.offset,
+			uncompressedSize: int64(t.b.Len() + plain.Len()),
+			compressedSize:   int64(t.b.Len() + gz.Len()),
+		})
+		if err := pw.write(t.b.Bytes()); err != nil {
+			return err
+		}
+		if err := pw.write(gz.Bytes()); err != nil {
+			return err
+		}
+	}
+	pw.rowGroups = append(pw.rowGroups, rg)
+	pw.rows += rg.rows
+	pw.pending = pw.pending[:0]
+	return nil
+}
+
+// Close writes any pending records and the footer.
+func (pw *parquetWriter) Close() error {
+	pw.Lock()
+	defer pw.Unlock()
+	if pw.closed {
+		return nil
+	}
+	pw.closed = true
+	if err := pw.flush(); err != nil {
+		pw.f.Close()
+		return err
+	}
+
+	// FileMetaData
+	var t thriftCompact
+	t.i32(1, 1)
+	t.listBegin(2, thriftStruct, len(rawParquetColumns)+1)
+	t.elemBegin()
+	t.binary(4, "schema")
+	t.i32(5, int32(len(rawParquetColumns)))
+	t.structEnd()
+	for _, col := range rawParquetColumns {
+		t.elemBegin()
+		t.i32(1, col.typ)
+		t.i32(3, parquetRequired)
+		t.binary(4, col.name)
+		if col.converted >= 0 {
+			t.i32(6, col.converted)
+		}
+		t.structEnd()
+	}
+	t.i64(3, pw.rows)
+	t.listBegin(4, thriftStruct, len(pw.rowGroups))
+	for _, rg := range pw.rowGroups {
+		t.elemBegin()
+		t.listBegin(1, thriftStruct, len(rg.chunks))
+		var total int64
+		for i, c := range rg.chunks {
+			col := rawParquetColumns[i]
+			t.elemBegin()
+			t.i64(2, c.offset)
+			t.structBegin(3)
+			t.i32(1, col.typ)
+			t.listBegin(2, thriftI32, 1)
+			t.zigzag(parquetPlain)
+			t.listBegin(3, thriftBinary, 1)
+			t.str(col.name)
+			t.i32(4, parquetGzip)
+			t.i64(5, rg.rows)
+			t.i64(6, c.uncompressedSize)
+			t.i64(7, c.compressedSize)
+			t.i64(9, c.offset)
+			t.structEnd()
+			t.structEnd()
+			total += c.uncompressedSize
+		}
+		t.i64(2, total)
+		t.i64(3, rg.rows)
+		t.structEnd()
+	}
+	t.binary(6, "s3skunk")
+	t.stop()
+
+	footerSize, err := parquetSize("footer", t.b.Len())
+	if err == nil {
+		err = pw.write(t.b.Bytes())
+	}
+	if err == nil {
+		var size [4]byte
+		binary.LittleEndian.PutUint32(size[:], uint32(footerSize))
+		err = pw.write(size[:])
+	}
+	if err == nil {
+		err = pw.write([]byte("PAR1"))
+	}
+	if err == nil {
+		err = pw.w.Flush()
+	}
+	if err != nil {
+		pw.f.Close()
+		return err
+	}
+	return pw.f.Close()
+}
+
+// Thrift compact protocol types.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftCompact encodes Thrift structs in the compact protocol, which is
+// all Parquet metadata needs.  Fields must be written in increasing order
+// of id.
+type thriftCompact struct {
+	b     bytes.Buffer
+	last  int16
+	stack []int16
+}
+
+func (t *thriftCompact) varint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	t.b.Write(buf[:binary.PutUvarint(buf[:], v)])
+}
+
+func (t *thriftCompact) zigzag(v int64) {
+	t.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (t *thriftCompact) field(id int16, typ byte) {
+	if d := id - t.last; d > 0 && d <= 15 {
+		t.b.WriteByte(byte(d)<<4 | typ)
+	} else {
+		t.b.WriteByte(typ)
+		t.zigzag(int64(id))
+	}
+	t.last = id
+}
+
+func (t *thriftCompact) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.zigzag(int64(v))
+}
+
+func (t *thriftCompact) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.zigzag(v)
+}
+
+func (t *thriftCompact) str(s string) {
+	t.varint(uint64(len(s)))
+	t.b.WriteString(s)
+}
+
+func (t *thriftCompact) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.str(s)
+}
+
+func (t *thriftCompact) listBegin(id int16, elem byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.b.WriteByte(byte(n)<<4 | elem)
+	} else {
+		t.b.WriteByte(0xf0 | elem)
+		t.varint(uint64(n))
+	}
+}
+
+func (t *thriftCompact) structBegin(id int16) {
+	t.field(id, thriftStruct)
+	t.elemBegin()
+}
+
+// elemBegin starts a struct that's a list element.
+func (t *thriftCompact) elemBegin() {
+	t.stack = append(t.stack, t.last)
+	t.last = 0
+}
+
+func (t *thriftCompact) structEnd() {
+	t.stop()
+	t.last = t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+}
+
+func (t *thriftCompact) stop() {
+	t.b.WriteByte(0)
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// thriftReader decodes the Thrift compact protocol independently of
+// thriftCompact, into structs of field id to value.
+type thriftReader struct {
+	b   []byte
+	pos int
+}
+
+func (r *thriftReader) byte() byte {
+	c := r.b[r.pos]
+	r.pos++
+	return c
+}
+
+func (r *thriftReader) varint() uint64 {
+	v, n := binary.Uvarint(r.b[r.pos:])
+	if n <= 0 {
+		panic("bad varint")
+	}
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case 1, 2: // struct field booleans carry their value in the type
+		return typ == 1
+	case 3:
+		return int64(int8(r.byte()))
+	case 4, 5, 6:
+		return r.zigzag()
+	case 7:
+		v := math.Float64frombits(binary.LittleEndian.Uint64(r.b[r.pos:]))
+		r.pos += 8
+		return v
+	case 8:
+		n := int(r.varint())
+		s := string(r.b[r.pos : r.pos+n])
+		r.pos += n
+		return s
+	case 9, 10:
+		h := r.byte()
+		n, elem := int(h>>4), h&0x0f
+		if n == 15 {
+			n = int(r.varint())
+		}
+		list := make([]interface{}, n)
+		for i := range list {
+			if elem == 1 || elem == 2 {
+				list[i] = r.byte() == 1
+			} else {
+				list[i] = r.value(elem)
+			}
+		}
+		return list
+	case 12:
+		return r.structure()
+	}
+	panic("unsupported thrift type")
+}
+
+func (r *thriftReader) structure() map[int16]interface{} {
+	m := make(map[int16]interface{})
+	var last int16
+	for {
+		h := r.byte()
+		if h == 0 {
+			return m
+		}
+		id := last + int16(h>>4)
+		if h>>4 == 0 {
+			id = int16(r.zigzag())
+		}
+		last = id
+		m[id] = r.value(h & 0x0f)
+	}
+}
+
+// readParquetRaw reads back a file written by parquetWriter: the footer,
+// then each column chunk's single gzipped PLAIN data page.
+func readParquetRaw(t *testing.T, name string) []rawRecord {
+	t.Helper()
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(b, []byte("PAR1")) || !bytes.HasSuffix(b, []byte("PAR1")) {
+		t.Fatal("missing PAR1 magic")
+	}
+	footerLen := int(binary.LittleEndian.Uint32(b[len(b)-8:]))
+	footer := &thriftReader{b: b[:len(b)-8], pos: len(b) - 8 - footerLen}
+	meta := footer.structure()
+
+	schema := meta[2].([]interface{})
+	if root := schema[0].(map[int16]interface{}); root[5].(int64) != int64(len(rawParquetColumns)) {
+		t.Fatalf("root has %d children, want %d", root[5], len(rawParquetColumns))
+	}
+	for i, col := range rawParquetColumns {
+		el := schema[i+1].(map[int16]interface{})
+		if el[4] != col.name || el[1] != int64(col.typ) {
+			t.Errorf("schema element %d is %v %v, want %s %d", i, el[4], el[1], col.name, col.typ)
+		}
+	}
+
+	var recs []rawRecord
+	for _, g := range meta[4].([]interface{}) {
+		rg := g.(map[int16]interface{})
+		rows := int(rg[3].(int64))
+		got := make([]rawRecord, rows)
+		for i, c := range rg[1].([]interface{}) {
+			cm := c.(map[int16]interface{})[3].(map[int16]interface{})
+			if cm[5].(int64) != int64(rows) {
+				t.Fatalf("column %d has %d values, want %d", i, cm[5], rows)
+			}
+			page := &thriftReader{b: b, pos: int(cm[9].(int64))}
+			header := page.structure()
+			if header[1].(int64) != parquetDataPage {
+				t.Fatalf("column %d page type %d", i, header[1])
+			}
+			compressed := int(header[3].(int64))
+			zr, err := gzip.NewReader(bytes.NewReader(b[page.pos : page.pos+compressed]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			plain, err := io.ReadAll(zr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(plain) != int(header[2].(int64)) {
+				t.Fatalf("column %d page is %d bytes, header says %d", i, len(plain), header[2])
+			}
+			decodePlain(rawParquetColumns[i].name, plain, got)
+		}
+		recs = append(recs, got...)
+	}
+	if n := meta[3].(int64); n != int64(len(recs)) {
+		t.Errorf("footer has %d rows, read %d", n, len(recs))
+	}
+	return recs
+}
+
+// decodePlain reads a PLAIN-encoded column into recs.
+func decodePlain(name string, b []byte, recs []rawRecord) {
+	le := binary.LittleEndian
+	for i := range recs {
+		r := &recs[i]
+		switch name {
+		case "Key":
+			n := int(le.Uint32(b))
+			r.Key, b = string(b[4:4+n]), b[4+n:]
+		case "SizeBytes":
+			r.SizeBytes, b = int64(le.Uint64(b)), b[8:]
+		case "Start":
+			r.Start, b = time.UnixMicro(int64(le.Uint64(b))).UTC(), b[8:]
+		case "TTFBSecs":
+			r.TTFBSecs, b = math.Float64frombits(le.Uint64(b)), b[8:]
+		case "TotalSecs":
+			r.TotalSecs, b = math.Float64frombits(le.Uint64(b)), b[8:]
+		case "Attempts":
+			r.Attempts, b = int(int32(le.Uint32(b))), b[4:]
+		case "Status":
+			r.Status, b = int(int32(le.Uint32(b))), b[4:]
+		case "TimedOut":
+			r.TimedOut = b[i/8]&(1<<(i%8)) != 0
+		}
+	}
+}
+
+func testRawRecords(n int) []rawRecord {
+	start := time.Date(2023, 6, 1, 10, 0, 0, 123456000, time.UTC)
+	recs := make([]rawRecord, n)
+	for i := range recs {
+		recs[i] = rawRecord{
+			Key:       "prefix/M001/" + string(rune('a'+i%26)),
+			SizeBytes: int64(i+1) * MiB,
+			Start:     start.Add(time.Duration(i) * time.Millisecond),
+			TTFBSecs:  0.01 * float64(i+1),
+			TotalSecs: 0.1 * float64(i+1),
+			Attempts:  1 + i%3,
+			Status:    200,
+		}
+		if i%7 == 3 {
+			recs[i].TotalSecs, recs[i].Status, recs[i].TimedOut = 0, 0, true
+		}
+	}
+	return recs
+}
+
+func writeTestParquet(t *testing.T, groups ...[]rawRecord) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "raw.parquet")
+	pw, err := newParquetWriter(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, g := range groups {
+		for _, rec := range g {
+			if err := pw.Add(rec); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := pw.flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestParquetRoundTrip(t *testing.T) {
+	recs := testRawRecords(20)
+	tests := []struct {
+		name   string
+		groups [][]rawRecord
+	}{
+		{"empty", nil},
+		{"one row", [][]rawRecord{recs[:1]}},
+		{"one row group", [][]rawRecord{recs}},
+		{"several row groups", [][]rawRecord{recs[:9], recs[9:17], recs[17:]}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var want []rawRecord
+			for _, g := range tt.groups {
+				want = append(want, g...)
+			}
+			got := readParquetRaw(t, writeTestParquet(t, tt.groups...))
+			if len(got) != len(want) {
+				t.Fatalf("read %d records, want %d", len(got), len(want))
+			}
+			for i := range want {
+				if got[i].Start.Equal(want[i].Start) {
+					got[i].Start = want[i].Start
+				}
+				if !reflect.DeepEqual(got[i], want[i]) {
+					t.Errorf("record %d:\n got %+v\nwant %+v", i, got[i], want[i])
+				}
+			}
+		})
+	}
+}
+
+// TestParquetPyArrow checks that pyarrow reads the file too, where it's
+// installed.
+func TestParquetPyArrow(t *testing.T) {
+	if exec.Command("python3", "-c", "import pyarrow.parquet").Run() != nil {
+		t.Skip("python3 with pyarrow not available")
+	}
+	recs := testRawRecords(20)
+	name := writeTestParquet(t, recs[:12], recs[12:])
+
+	script := `import datetime, json, sys, pyarrow.parquet as pq
+epoch = datetime.datetime(1970, 1, 1)
+def micros(v):
+    if isinstance(v, datetime.datetime):
+        return (v.replace(tzinfo=None) - epoch) // datetime.timedelta(microseconds=1)
+    return v
+t = pq.read_table(sys.argv[1])
+print(json.dumps({c: [micros(v) for v in t.column(c).to_pylist()] for c in t.column_names}))`
+	out, err := exec.Command("python3", "-c", script, name).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cols map[string][]interface{}
+	if err := json.Unmarshal(out, &cols); err != nil {
+		t.Fatal(err)
+	}
+	for _, col := range rawParquetColumns {
+		if len(cols[col.name]) != len(recs) {
+			t.Fatalf("pyarrow read %d %s values, want %d", len(cols[col.name]), col.name, len(recs))
+		}
+	}
+	for i, rec := range recs {
+		if cols["Key"][i] != rec.Key || cols["SizeBytes"][i] != float64(rec.SizeBytes) ||
+			cols["Start"][i] != float64(rec.Start.UnixMicro()) || cols["TTFBSecs"][i] != rec.TTFBSecs ||
+			cols["TimedOut"][i] != rec.TimedOut || cols["Status"][i] != float64(rec.Status) {
+			t.Errorf("row %d differs: %+v", i, rec)
+		}
+	}
+}
+
+// TestParquetWriteErrors checks that a failed write is reported rather than
+// leaving a corrupt file behind silently.
+func TestParquetWriteErrors(t *testing.T) {
+	pw, err := newParquetWriter(filepath.Join(t.TempDir(), "raw.parquet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pw.f.Close()
+	if err := pw.Add(testRawRecords(1)[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := pw.Close(); err == nil {
+		t.Error("Close succeeded writing to a closed file")
+	}
+
+	if _, err := parquetSize("page", math.MaxInt32); err != nil {
+		t.Errorf("parquetSize(MaxInt32) = %v", err)
+	}
+	if _, err := parquetSize("page", math.MaxInt32+1); err == nil {
+		t.Error("parquetSize(MaxInt32+1) succeeded")
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ListPageSize is the most keys a ListObjectsV2 page returns.
+const ListPageSize = 1000
+
+// Plan describes what a download run would do, for --dry-run.
+type Plan struct {
+	Bucket              string
+	Prefix              string
+	FileSetName         string
+	FileSizeBytes       int
+	RangeSizeBytes      int // 0 for whole objects
+	ObjectsInSet        int // as listed in the bucket
+	ObjectsPerIteration int
+	Goroutines          int
+	ObjectsPerGoroutine float64
+	Iterations          int
+	DurationSecs        float64 // per iteration, instead of a fixed size
+	BytesPerIteration   int
+
+	// Estimated across all iterations; hedging or retries would add to these
+	ListRequests int
+	GetRequests  int
+	TotalBytes   int64
+}
+
+// dryRun lists the file set and prints the plan as JSON instead of
+// downloading anything.
+func dryRun(cfg *myConfig) int {
+	s3Client, err := configS3(cfg)
+	if err != nil {
+		fatalf("error configuring S3: %v", err)
+	}
+
+	files, err := listS3Files(cfg, s3Client)
+	if err != nil {
+		fatalf("error listing file set: %v", err)
+	}
+	if len(files) == 0 {
+		warnf("no S3 files found for file set %s", cfg.FileSetName)
+	}
+
+	size := meanObjectSize(cfg)
+	objects := cfg.DownloadSizeBytes / requestSize(cfg)
+	listPages := (len(files) + ListPageSize - 1) / ListPageSize
+	if listPages == 0 {
+		listPages = 1
+	}
+
+	plan := Plan{
+		Bucket:              cfg.Bucket,
+		Prefix:              cfg.Prefix,
+		FileSetName:         cfg.FileSetName,
+		FileSizeBytes:       size,
+		RangeSizeBytes:      cfg.RangeSizeBytes,
+		ObjectsInSet:        len(files),
+		ObjectsPerIteration: objects,
+		Goroutines:          cfg.Goroutines,
+		ObjectsPerGoroutine: float64(objects) / float64(cfg.Goroutines),
+		Iterations:          cfg.Count,
+		DurationSecs:        cfg.Duration.Seconds(),
+		BytesPerIteration:   cfg.DownloadSizeBytes,
+
+		ListRequests: listPages * cfg.Count,
+		GetRequests:  objects * cfg.Count,
+		TotalBytes:   int64(cfg.DownloadSizeBytes) * int64(cfg.Count),
+	}
+
+	jb, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		fatalf("error encoding plan to JSON: %v", err)
+	}
+	fmt.Println(string(jb))
+
+	return 0
+}
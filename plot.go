@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/spf13/pflag"
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/plotutil"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+// plotCDFPoints bounds the points drawn for each latency CDF.
+const plotCDFPoints = 2000
+
+// plotThroughput charts the mean throughput of each workload at each
+// goroutine count, on a log axis with a tick at each count.
+func plotThroughput(dps []Datapoint, name string) error {
+	vary := variedFields(dps)
+	series := map[string]map[int][]float64{}
+	counts := map[int]bool{}
+	for _, dp := range dps {
+		if dp.Goroutines == 0 || dp.TotalSizeBytes == 0 {
+			continue
+		}
+		label := seriesLabel(dp, vary, dp.FileSizeLabel)
+		if series[label] == nil {
+			series[label] = map[int][]float64{}
+		}
+		series[label][dp.Goroutines] = append(series[label][dp.Goroutines], dp.ThroughputMiBs)
+		counts[dp.Goroutines] = true
+	}
+	if len(series) == 0 {
+		return fmt.Errorf("no throughput results to plot")
+	}
+
+	p := plot.New()
+	p.Title.Text = "Throughput vs concurrency"
+	p.X.Label.Text = "goroutines"
+	p.Y.Label.Text = "MiB/s"
+	p.X.Scale = plot.LogScale{}
+	var ticks []plot.Tick
+	for g := range counts {
+		ticks = append(ticks, plot.Tick{Value: float64(g), Label: fmt.Sprint(g)})
+	}
+	p.X.Tick.Marker = plot.ConstantTicks(ticks)
+	p.Y.Min = 0
+	p.Add(plotter.NewGrid())
+
+	labels := make([]string, 0, len(series))
+	for label := range series {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for i, label := range labels {
+		byG := series[label]
+		xys := make(plotter.XYs, 0, len(byG))
+		for g, vs := range byG {
+			xys = append(xys, plotter.XY{X: float64(g), Y: mean(vs)})
+		}
+		sort.Slice(xys, func(i, j int) bool { return xys[i].X < xys[j].X })
+		line, points, err := plotter.NewLinePoints(xys)
+		if err != nil {
+			return err
+		}
+		line.Color = plotutil.Color(i)
+		points.Color = plotutil.Color(i)
+		points.Shape = draw.CircleGlyph{}
+		p.Add(line, points)
+		p.Legend.Add(label, line, points)
+	}
+	p.Legend.Top = true
+	p.Legend.Left = true
+	return p.Save(8*vg.Inch, 5*vg.Inch, name)
+}
+
+// plotLatencyCDF charts the cumulative distribution of time to first byte
+// of each series of request records, on a log axis to show the tail.
+// Requests that timed out have no time to first byte, so are left out.
+func plotLatencyCDF(series map[string][]rawRecord, name string) error {
+	p := plot.New()
+	p.Title.Text = "Latency CDF"
+	p.X.Label.Text = "time to first byte (ms)"
+	p.Y.Label.Text = "fraction of requests"
+	p.X.Scale = plot.LogScale{}
+	p.X.Tick.Marker = plot.LogTicks{Prec: -1}
+	p.Y.Min, p.Y.Max = 0, 1
+	p.Add(plotter.NewGrid())
+
+	labels := make([]string, 0, len(series))
+	for label := range series {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	plotted := 0
+	for _, label := range labels {
+		var ms []float64
+		for _, r := range series[label] {
+			if !r.TimedOut && r.TTFBSecs > 0 {
+				ms = append(ms, r.TTFBSecs*1000)
+			}
+		}
+		if len(ms) == 0 {
+			continue
+		}
+		sort.Float64s(ms)
+		step := (len(ms) + plotCDFPoints - 1) / plotCDFPoints
+		var xys plotter.XYs
+		for i := 0; i < len(ms); i += step {
+			xys = append(xys, plotter.XY{X: ms[i], Y: float64(i+1) / float64(len(ms))})
+		}
+		if last := len(ms) - 1; last%step != 0 {
+			xys = append(xys, plotter.XY{X: ms[last], Y: 1})
+		}
+		line, err := plotter.NewLine(xys)
+		if err != nil {
+			return err
+		}
+		line.Color = plotutil.Color(plotted)
+		line.Width = vg.Points(1.5)
+		p.Add(line)
+		p.Legend.Add(fmt.Sprintf("%s (%d requests)", label, len(ms)), line)
+		plotted++
+	}
+	if plotted == 0 {
+		return fmt.Errorf("no request records to plot; use a --store database with --store-requests or --raw-output files with --raw")
+	}
+	p.Legend.Top = true
+	p.Legend.Left = true
+	return p.Save(8*vg.Inch, 5*vg.Inch, name)
+}
+
+// runPlot draws charts from results files as images, in the format given
+// by each file's extension: .png, .svg, .pdf and the like.
+func runPlot(args []string) int {
+	fs := pflag.NewFlagSet("plot", pflag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: s3skunk plot [flags] [<results.jsonl|results.db>...]\n")
+		fs.PrintDefaults()
+	}
+	tputOut := fs.String("throughput", "", "file to draw throughput vs goroutines to, e.g. throughput.png")
+	cdfOut := fs.String("latency-cdf", "", "file to draw latency CDFs to, from the request records in --store databases and --raw files")
+	rawFiles := fs.StringArray("raw", nil, "JSON --raw-output file to add to the latency CDF as a series of its own (repeatable)")
+	parseFlags(fs, args)
+
+	if *tputOut == "" && *cdfOut == "" {
+		fatalf("nothing to plot: give --throughput or --latency-cdf")
+	}
+	if fs.NArg() == 0 && len(*rawFiles) == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	var dps []Datapoint
+	for _, name := range fs.Args() {
+		d, err := readResults(name)
+		if err != nil {
+			fatalf("error reading %s: %v", name, err)
+		}
+		dps = append(dps, d...)
+	}
+
+	if *tputOut != "" {
+		if err := plotThroughput(dps, *tputOut); err != nil {
+			fatalf("error plotting throughput: %v", err)
+		}
+		infof("wrote %s", *tputOut)
+	}
+
+	if *cdfOut != "" {
+		// Stored requests are grouped as the throughput chart groups
+		// datapoints; each raw file is a series named for the file.
+		series := map[string][]rawRecord{}
+		vary := variedFields(dps)
+		for _, name := range fs.Args() {
+			if !isStoreFile(name) {
+				continue
+			}
+			d, err := readStoredDatapoints(name)
+			if err != nil {
+				fatalf("error reading %s: %v", name, err)
+			}
+			recs, err := readStoredRequests(name)
+			if err != nil {
+				fatalf("error reading requests from %s: %v", name, err)
+			}
+			for _, dp := range d {
+				label := seriesLabel(dp, vary, dp.FileSizeLabel)
+				series[label] = append(series[label], recs[dp.ID.Hex()]...)
+			}
+		}
+		for _, name := range *rawFiles {
+			recs, err := readRawRecords(name)
+			if err != nil {
+				fatalf("error reading %s: %v", name, err)
+			}
+			label := filepath.Base(name)
+			series[label] = append(series[label], recs...)
+		}
+		if err := plotLatencyCDF(series, *cdfOut); err != nil {
+			fatalf("error plotting latency CDF: %v", err)
+		}
+		infof("wrote %s", *cdfOut)
+	}
+	return 0
+}
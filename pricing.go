@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// pricing is what S3 charges, in USD, for estimating what a run cost.
+type pricing struct {
+	GetPer1000     float64 `yaml:"get_per_1000"`     // GET, HEAD and other reads
+	PutPer1000     float64 `yaml:"put_per_1000"`     // PUT, COPY, POST and LIST
+	TransferPerGiB float64 `yaml:"transfer_per_gib"` // data transfer out
+}
+
+// defaultPricing is S3 Standard in us-east-1, read from EC2 in the same
+// region, where data transfer is free.
+var defaultPricing = pricing{GetPer1000: 0.0004, PutPer1000: 0.005}
+
+// loadPricing reads a YAML pricing table like
+//
+//	get_per_1000: 0.00044
+//	put_per_1000: 0.0055
+//	transfer_per_gib: 0.02
+//
+// where keys left out keep their default.
+func loadPricing(name string) (pricing, error) {
+	p := defaultPricing
+	if name == "" {
+		return p, nil
+	}
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return p, err
+	}
+	err = yaml.Unmarshal(b, &p)
+	return p, err
+}
+
+// setCost estimates what the requests and bytes in dp cost.  Retried
+// attempts are billed too, and are counted as reads.
+func (dp *Datapoint) setCost(p pricing) {
+	reads := dp.Requests - dp.Writes + dp.Retries
+	dp.RequestCostUSD = float64(reads)/1000*p.GetPer1000 + float64(dp.Writes)/1000*p.PutPer1000
+	dp.TransferCostUSD = float64(dp.TotalSizeBytes) / (1024 * MiB) * p.TransferPerGiB
+	dp.EstimatedCostUSD = dp.RequestCostUSD + dp.TransferCostUSD
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rampStep is one step of a --ramp schedule: run with this many workers for
+// this long.
+type rampStep struct {
+	Goroutines int
+	Duration   time.Duration
+}
+
+// parseRamp parses a schedule like "4:30s,16:30s,64:30s".  Workers are only
+// ever added, so the counts can't go down.
+func parseRamp(s string) ([]rampStep, error) {
+	var steps []rampStep
+	for _, part := range strings.Split(s, ",") {
+		n, d, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return nil, fmt.Errorf("step '%s' isn't goroutines:duration", part)
+		}
+		goroutines, err := strconv.Atoi(n)
+		if err != nil || goroutines < 1 {
+			return nil, fmt.Errorf("step '%s' needs a positive number of goroutines", part)
+		}
+		dur, err := time.ParseDuration(d)
+		if err != nil || dur <= 0 {
+			return nil, fmt.Errorf("step '%s' needs a positive duration", part)
+		}
+		if len(steps) > 0 && goroutines < steps[len(steps)-1].Goroutines {
+			return nil, fmt.Errorf("step '%s' has fewer goroutines than the step before it", part)
+		}
+		steps = append(steps, rampStep{Goroutines: goroutines, Duration: dur})
+	}
+	return steps, nil
+}
+
+// stepDigest is a latency digest that a ramp swaps out at the end of each
+// step, so each step reports only its own requests.
+type stepDigest struct {
+	sync.Mutex
+	td    *latencyDigest
+	flush chan chan struct{} // to collect, once it's running
+}
+
+func newStepDigest() *stepDigest {
+	return &stepDigest{td: newLatencyDigest()}
+}
+
+func (sd *stepDigest) Add(secs float64) {
+	sd.Lock()
+	defer sd.Unlock()
+	sd.td.Add(secs)
+}
+
+// take returns the digest so far and starts a fresh one.
+func (sd *stepDigest) take() *latencyDigest {
+	sd.Lock()
+	defer sd.Unlock()
+	td := sd.td
+	sd.td = newLatencyDigest()
+	return td
+}
+
+// collect adds the latencies sent on ch until it's closed, then closes the
+// channel it returns.  Meanwhile takeStep can have it add those already
+// sent but still buffered.
+func (sd *stepDigest) collect(ch <-chan float64) <-chan struct{} {
+	sd.flush = make(chan chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case v, ok := <-ch:
+				if !ok {
+					return
+				}
+				sd.Add(v)
+			case flushed := <-sd.flush:
+				for n := len(ch); n > 0; n-- {
+					sd.Add(<-ch)
+				}
+				close(flushed)
+			}
+		}
+	}()
+	return done
+}
+
+// takeStep is take at the end of a ramp step, once the latencies sent
+// during the step are in the digest rather than waiting to be collected.
+func (sd *stepDigest) takeStep() *latencyDigest {
+	flushed := make(chan struct{})
+	sd.flush <- flushed
+	<-flushed
+	return sd.take()
+}
+
+// rampSteps grows the worker pool through cfg.Ramp, calling startWorker for
+// each new worker, and emits a datapoint at the end of every step from the
+// latencies and counters accumulated during it, which it also returns.
+// Before the last step's, it calls wait for the workers to finish, so that
+// requests still in flight count in it.  For --report-interval the steps
+// are the intervals and the pool never grows.
+func rampSteps(ctx context.Context, cfg *myConfig, rs *runState, td, wtd *stepDigest, startWorker func(i int), wait func()) []Datapoint {
+	var steps []Datapoint
+	var started int
+	for n, step := range cfg.Ramp {
+		for ; started < step.Goroutines; started++ {
+			startWorker(started)
+		}
+		debugf("ramp step %d: %d goroutines for %v", n+1, step.Goroutines, step.Duration)
+
+		stepStart := time.Now()
+		t := time.NewTimer(step.Duration)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+		}
+		if n == len(cfg.Ramp)-1 || ctx.Err() != nil {
+			wait()
+		}
+		elapsedSec := time.Since(stepStart).Seconds()
+
+		datapoint := rs.takeDatapoint(ctx, td, wtd, true, stepStart, elapsedSec)
+		datapoint.Goroutines = step.Goroutines
+		datapoint.DurationSecs = step.Duration.Seconds()
+		datapoint.RampStep = n + 1
+
+		emitDatapoint(cfg, datapoint)
+		steps = append(steps, datapoint)
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return steps
+}
+
+// rampTotal returns a datapoint, not to be emitted, of the throughput over
+// all of a ramp's steps, for tables of runs such as the matrix's.
+func rampTotal(steps []Datapoint) Datapoint {
+	var dp Datapoint
+	for _, step := range steps {
+		dp.TotalSizeBytes += step.TotalSizeBytes
+		dp.ElapsedSecs += step.ElapsedSecs
+	}
+	if dp.ElapsedSecs > 0 {
+		dp.ThroughputMiBs = float64(dp.TotalSizeBytes) / MiB / dp.ElapsedSecs
+	}
+	return dp
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRamp(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []rampStep
+		wantErr string
+	}{
+		{spec: "8:1m", want: []rampStep{{8, time.Minute}}},
+		{
+			spec: "4:30s,16:30s,64:30s",
+			want: []rampStep{{4, 30 * time.Second}, {16, 30 * time.Second}, {64, 30 * time.Second}},
+		},
+		{spec: "4:10s, 4:20s", want: []rampStep{{4, 10 * time.Second}, {4, 20 * time.Second}}},
+		{spec: "4", wantErr: "isn't goroutines:duration"},
+		{spec: "4:30s,", wantErr: "isn't goroutines:duration"},
+		{spec: "0:30s", wantErr: "positive number of goroutines"},
+		{spec: "many:30s", wantErr: "positive number of goroutines"},
+		{spec: "4:0s", wantErr: "positive duration"},
+		{spec: "4:30", wantErr: "positive duration"},
+		{spec: "16:30s,4:30s", wantErr: "fewer goroutines"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := parseRamp(tt.spec)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestStepDigestTakeStep checks that latencies still buffered when a step
+// ends count in that step, not the next.
+func TestStepDigestTakeStep(t *testing.T) {
+	sd := newStepDigest()
+	ch := make(chan float64, 10)
+	done := sd.collect(ch)
+
+	for i := 0; i < 5; i++ {
+		ch <- 0.1
+	}
+	if n := sd.takeStep().count; n != 5 {
+		t.Errorf("first step has %d latencies, want 5", n)
+	}
+	ch <- 0.2
+	ch <- 0.2
+	if n := sd.takeStep().count; n != 2 {
+		t.Errorf("second step has %d latencies, want 2", n)
+	}
+
+	close(ch)
+	<-done
+	if n := sd.take().count; n != 0 {
+		t.Errorf("%d latencies left over", n)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// rawRecord is one GET as written to --raw-output.
+type rawRecord struct {
+	Key       string
+	SizeBytes int64
+	Start     time.Time
+	TTFBSecs  float64 // to response headers
+	TotalSecs float64 // to the last byte of the body; 0 if it timed out
+	Attempts  int     // including SDK retries
+	Status    int     // HTTP status of the final attempt; 0 if it timed out
+	TimedOut  bool    `json:",omitempty"`
+}
+
+// rawRecorder appends a sample of rawRecords to --raw-output, one JSON
+// object per line or as Parquet, and with --store-requests keeps them for
+// the store.
+type rawRecorder struct {
+	sync.Mutex
+	f      *os.File // nil without --raw-output, or for Parquet
+	w      *bufio.Writer
+	enc    *json.Encoder
+	pq     *parquetWriter
+	keep   bool
+	kept   []rawRecord
+	sample float64
+	rng    *rand.Rand // its own, so sampling doesn't change which keys are fetched
+}
+
+// rawSamplers seeds each rawRecorder's sampler, so that every iteration or
+// step of a run samples differently, yet the same again for the same --seed.
+var rawSamplers struct {
+	sync.Mutex
+	rng *rand.Rand
+}
+
+// openRaw is the JSON --raw-output recorders not yet closed, which a fatal
+// error closes, through the one onFatal hook registered for them.
+var openRaw struct {
+	sync.Mutex
+	recorders map[*rawRecorder]struct{}
+	hook      sync.Once
+}
+
+func newRawRecorder(cfg *myConfig) (*rawRecorder, error) {
+	rawSamplers.Lock()
+	if rawSamplers.rng == nil {
+		rawSamplers.rng = rand.New(rand.NewSource(cfg.Seed))
+	}
+	seed := rawSamplers.rng.Int63()
+	rawSamplers.Unlock()
+
+	rr := &rawRecorder{
+		keep:   cfg.StoreRequests,
+		sample: cfg.RawSample,
+		rng:    rand.New(rand.NewSource(seed)),
+	}
+	if cfg.RawOutput != "" && cfg.RawFormat == "parquet" {
+		if rawParquet == nil {
+			pw, err := newParquetWriter(cfg.RawOutput)
+			if err != nil {
+				return nil, err
+			}
+			rawParquet = pw
+		}
+		rr.pq = rawParquet
+	} else if cfg.RawOutput != "" {
+		f, err := os.OpenFile(cfg.RawOutput, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		rr.f = f
+		rr.w = bufio.NewWriter(f)
+		rr.enc = json.NewEncoder(rr.w)
+		openRaw.hook.Do(func() { onFatal(closeOpenRaw) })
+		openRaw.Lock()
+		if openRaw.recorders == nil {
+			openRaw.recorders = make(map[*rawRecorder]struct{})
+		}
+		openRaw.recorders[rr] = struct{}{}
+		openRaw.Unlock()
+	}
+	return rr, nil
+}
+
+// closeOpenRaw flushes and closes the JSON --raw-output recorders still open.
+func closeOpenRaw() {
+	openRaw.Lock()
+	recorders := openRaw.recorders
+	openRaw.recorders = nil
+	openRaw.Unlock()
+	for rr := range recorders {
+		if err := rr.Close(); err != nil {
+			errorf("error writing raw output: %v", err)
+		}
+	}
+}
+
+// record writes rec if it's sampled.  Attempts and status come from the
+// response metadata, if there was a response.
+func (rr *rawRecorder) record(rec rawRecord, metadata *middleware.Metadata) error {
+	rr.Lock()
+	defer rr.Unlock()
+	if rr.sample < 1 && rr.rng.Float64() >= rr.sample {
+		return nil
+	}
+
+	if metadata != nil {
+		if results, ok := retry.GetAttemptResults(*metadata); ok {
+			rec.Attempts = len(results.Results)
+		}
+		if resp, ok := awsmiddleware.GetRawResponse(*metadata).(*smithyhttp.Response); ok {
+			rec.Status = resp.StatusCode
+		}
+	}
+	if rr.keep {
+		rr.kept = append(rr.kept, rec)
+	}
+	if rr.pq != nil {
+		return rr.pq.Add(rec)
+	}
+	if rr.enc == nil {
+		return nil
+	}
+	return rr.enc.Encode(rec)
+}
+
+// take returns the records kept so far and starts afresh.
+func (rr *rawRecorder) take() []rawRecord {
+	rr.Lock()
+	defer rr.Unlock()
+	kept := rr.kept
+	rr.kept = nil
+	return kept
+}
+
+// rawParquet is the Parquet --raw-output.  It's shared by every run of the
+// process, since a Parquet file can't be appended to, and written out by
+// closeRawParquet at exit.
+var rawParquet *parquetWriter
+
+func closeRawParquet() error {
+	if rawParquet == nil {
+		return nil
+	}
+	return rawParquet.Close()
+}
+
+// Close flushes and closes the JSON --raw-output.  Records made after it's
+// closed are dropped.
+func (rr *rawRecorder) Close() error {
+	rr.Lock()
+	defer rr.Unlock()
+	if rr.f == nil {
+		return nil
+	}
+	f := rr.f
+	rr.f, rr.enc = nil, nil
+	openRaw.Lock()
+	delete(openRaw.recorders, rr)
+	openRaw.Unlock()
+	if err := rr.w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readRawRecords reads a JSON --raw-output file.
+func readRawRecords(name string) ([]rawRecord, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var recs []rawRecord
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for {
+		var rec rawRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			return recs, nil
+		} else if err != nil {
+			return nil, err
+		}
+		recs = append(recs, rec)
+	}
+}
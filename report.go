@@ -0,0 +1,572 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// Chart geometry, in SVG pixels.
+const (
+	chartWidth  = 760
+	chartHeight = 420
+	chartLeft   = 70
+	chartRight  = 20
+	chartTop    = 20
+	chartBottom = 50
+)
+
+// chartColors are the series colors, in order.
+var chartColors = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf"}
+
+type chartTick struct {
+	Pos   float64
+	Label string
+}
+
+type chartPoint struct {
+	X, Y  float64
+	Title string
+}
+
+type chartSeries struct {
+	Name   string
+	Color  string
+	Path   string
+	Points []chartPoint
+}
+
+// chart is an SVG line chart with a log x axis, ready for the template.
+type chart struct {
+	Title          string
+	Note           string
+	XLabel, YLabel string
+	Width, Height  int
+	Left, Bottom   float64 // plot area edges
+	Right, Top     float64
+	XTicks, YTicks []chartTick
+	Series         []chartSeries
+}
+
+// reportGroup is the datapoints of one series at one x value.
+type reportGroup struct {
+	x  float64
+	ys []float64
+}
+
+// newChart lays out series of (x, y) values, averaging those at the same
+// x.  The x axis is log scale, with a tick at each x value present, since
+// concurrency and object size are usually geometric series.
+func newChart(title, note, xlabel, ylabel string, series map[string]map[float64][]float64, xfmt, yfmt func(float64) string) *chart {
+	c := &chart{
+		Title: title, Note: note, XLabel: xlabel, YLabel: ylabel,
+		Width: chartWidth, Height: chartHeight,
+		Left: chartLeft, Right: chartWidth - chartRight,
+		Top: chartTop, Bottom: chartHeight - chartBottom,
+	}
+
+	xset := map[float64]bool{}
+	ymax := 0.0
+	for _, byX := range series {
+		for x, ys := range byX {
+			xset[x] = true
+			for _, y := range ys {
+				ymax = math.Max(ymax, y)
+			}
+		}
+	}
+	xs := make([]float64, 0, len(xset))
+	for x := range xset {
+		xs = append(xs, x)
+	}
+	sort.Float64s(xs)
+	if len(xs) == 0 {
+		return c
+	}
+
+	lo, hi := math.Log(xs[0]), math.Log(xs[len(xs)-1])
+	xpos := func(x float64) float64 {
+		if hi == lo {
+			return (c.Left + c.Right) / 2
+		}
+		return c.Left + (math.Log(x)-lo)/(hi-lo)*(c.Right-c.Left)
+	}
+	step := niceStep(ymax / 5)
+	top := math.Ceil(ymax/step) * step
+	if top == 0 {
+		top = 1
+	}
+	ypos := func(y float64) float64 {
+		return c.Bottom - y/top*(c.Bottom-c.Top)
+	}
+
+	for _, x := range xs {
+		c.XTicks = append(c.XTicks, chartTick{math.Round(xpos(x)*10) / 10, xfmt(x)})
+	}
+	for y := 0.0; y <= top+step/2; y += step {
+		c.YTicks = append(c.YTicks, chartTick{math.Round(ypos(y)*10) / 10, yfmt(y)})
+	}
+
+	names := make([]string, 0, len(series))
+	for name := range series {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		var groups []reportGroup
+		for x, ys := range series[name] {
+			groups = append(groups, reportGroup{x, ys})
+		}
+		sort.Slice(groups, func(i, j int) bool { return groups[i].x < groups[j].x })
+
+		s := chartSeries{Name: name, Color: chartColors[i%len(chartColors)]}
+		var path strings.Builder
+		for j, g := range groups {
+			sort.Float64s(g.ys)
+			m := mean(g.ys)
+			px, py := math.Round(xpos(g.x)*10)/10, math.Round(ypos(m)*10)/10
+			if j == 0 {
+				fmt.Fprintf(&path, "M%.1f,%.1f", px, py)
+			} else {
+				fmt.Fprintf(&path, " L%.1f,%.1f", px, py)
+			}
+			title := fmt.Sprintf("%s at %s: %s", name, xfmt(g.x), yfmt(m))
+			if len(g.ys) > 1 {
+				title += fmt.Sprintf(" (mean of %d, %s to %s)", len(g.ys), yfmt(g.ys[0]), yfmt(g.ys[len(g.ys)-1]))
+			}
+			s.Points = append(s.Points, chartPoint{px, py, title})
+		}
+		s.Path = path.String()
+		c.Series = append(c.Series, s)
+	}
+	return c
+}
+
+// niceStep rounds a tick step up to 1, 2 or 5 times a power of ten.
+func niceStep(raw float64) float64 {
+	if raw <= 0 {
+		return 1
+	}
+	p := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if raw <= m*p {
+			return m * p
+		}
+	}
+	return 10 * p
+}
+
+// formatSize renders a byte count in the file set style, e.g. 64K or 16M.
+func formatSize(b float64) string {
+	switch {
+	case b >= MiB:
+		return fmt.Sprintf("%gM", b/MiB)
+	case b >= KiB:
+		return fmt.Sprintf("%gK", b/KiB)
+	}
+	return fmt.Sprintf("%gB", b)
+}
+
+// reportRow is a line of the report's table: the datapoints of one
+// workload.
+type reportRow struct {
+	Instance, Operation, Set string
+	Goroutines               int
+	N                        int
+	ThroughputMiBs           float64
+	P50ms, P99ms             float64
+	ErrorRate                float64
+}
+
+type reportPage struct {
+	Generated  string
+	Sources    []string
+	Datapoints int
+	From, To   string
+	Charts     []*chart
+	Rows       []reportRow
+}
+
+// seriesLabel names a workload by whichever of its instance type,
+// operation and file set vary across the report.
+func seriesLabel(dp Datapoint, vary map[string]bool, parts ...string) string {
+	var label []string
+	if vary["instance"] {
+		label = append(label, dp.EC2Instance)
+	}
+	if vary["op"] {
+		label = append(label, dp.Operation)
+	}
+	for _, p := range parts {
+		if p != "" {
+			label = append(label, p)
+		}
+	}
+	return strings.Join(label, " ")
+}
+
+// variedFields reports which of instance, op and set take more than one
+// value across dps, for seriesLabel.
+func variedFields(dps []Datapoint) map[string]bool {
+	seen := map[string]map[string]bool{"instance": {}, "op": {}, "set": {}}
+	for _, dp := range dps {
+		seen["instance"][dp.EC2Instance] = true
+		seen["op"][dp.Operation] = true
+		seen["set"][dp.FileSizeLabel] = true
+	}
+	vary := map[string]bool{}
+	for k, vs := range seen {
+		vary[k] = len(vs) > 1
+	}
+	return vary
+}
+
+func buildReport(sources []string, dps []Datapoint) reportPage {
+	page := reportPage{
+		Generated:  time.Now().UTC().Format(time.RFC1123),
+		Sources:    sources,
+		Datapoints: len(dps),
+	}
+	sort.Slice(dps, func(i, j int) bool { return dps[i].StartTime.Before(dps[j].StartTime) })
+	if len(dps) > 0 {
+		page.From = dps[0].StartTime.UTC().Format(time.RFC1123)
+		page.To = dps[len(dps)-1].StartTime.UTC().Format(time.RFC1123)
+	}
+
+	vary := variedFields(dps)
+
+	add := func(series map[string]map[float64][]float64, name string, x, y float64) {
+		if series[name] == nil {
+			series[name] = map[float64][]float64{}
+		}
+		series[name][x] = append(series[name][x], y)
+	}
+	tput := map[string]map[float64][]float64{}
+	lat := map[string]map[float64][]float64{}
+	for _, dp := range dps {
+		if dp.Goroutines > 0 && dp.TotalSizeBytes > 0 {
+			add(tput, seriesLabel(dp, vary, dp.FileSizeLabel), float64(dp.Goroutines), dp.ThroughputMiBs)
+		}
+		if dp.FileSizeBytes > 0 && dp.P50Latency > 0 {
+			add(lat, seriesLabel(dp, vary, "p50"), float64(dp.FileSizeBytes), dp.P50Latency*1000)
+			add(lat, seriesLabel(dp, vary, "p99"), float64(dp.FileSizeBytes), dp.P99Latency*1000)
+		}
+	}
+	count := func(v float64) string { return fmt.Sprintf("%g", v) }
+	page.Charts = []*chart{
+		newChart("Throughput vs concurrency", "Mean over the datapoints at each goroutine count; hover for the range.",
+			"goroutines", "MiB/s", tput, count, func(v float64) string { return fmt.Sprintf("%.4g", v) }),
+		newChart("Latency vs object size", "Latency to response headers, averaged over all concurrency levels.",
+			"object size", "ms", lat, formatSize, func(v float64) string { return fmt.Sprintf("%.4g", v) }),
+	}
+
+	rows := map[string]*reportRow{}
+	for _, dp := range dps {
+		k := fmt.Sprintf("%s\x00%s\x00%s\x00%09d", dp.EC2Instance, dp.Operation, dp.FileSizeLabel, dp.Goroutines)
+		r := rows[k]
+		if r == nil {
+			r = &reportRow{Instance: dp.EC2Instance, Operation: dp.Operation, Set: dp.FileSizeLabel, Goroutines: dp.Goroutines}
+			rows[k] = r
+		}
+		// Running means.
+		r.N++
+		n := float64(r.N)
+		r.ThroughputMiBs += (dp.ThroughputMiBs - r.ThroughputMiBs) / n
+		r.P50ms += (dp.P50Latency*1000 - r.P50ms) / n
+		r.P99ms += (dp.P99Latency*1000 - r.P99ms) / n
+		r.ErrorRate += (dp.ErrorRate*100 - r.ErrorRate) / n
+	}
+	keys := make([]string, 0, len(rows))
+	for k := range rows {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		page.Rows = append(page.Rows, *rows[k])
+	}
+	return page
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>s3skunk report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h2 { margin-top: 2em; }
+.note, .meta { color: #666; font-size: 90%; }
+svg text { font-size: 12px; }
+.axis { stroke: #999; }
+.grid { stroke: #eee; }
+.legend span { cursor: pointer; margin-right: 1.5em; user-select: none; }
+.legend span.off { opacity: 0.3; }
+.off-series { display: none; }
+table { border-collapse: collapse; margin-top: 1em; }
+th, td { padding: 0.3em 0.8em; text-align: right; border-bottom: 1px solid #ddd; }
+th { cursor: pointer; background: #f4f4f4; }
+td:nth-child(-n+3), th:nth-child(-n+3) { text-align: left; }
+</style>
+</head>
+<body>
+<h1>s3skunk report</h1>
+<p class="meta">{{.Datapoints}} datapoints from {{range $i, $s := .Sources}}{{if $i}}, {{end}}{{$s}}{{end}}{{if .From}}, run {{.From}} to {{.To}}{{end}}.  Generated {{.Generated}}.</p>
+{{range $ci, $c := .Charts}}
+<h2>{{$c.Title}}</h2>
+<p class="note">{{$c.Note}}  Click a legend entry to hide or show it.</p>
+{{if $c.Series}}
+<div class="legend">{{range $si, $s := $c.Series}}<span data-chart="{{$ci}}" data-series="{{$si}}"><span style="color: {{$s.Color}}">&#9632;</span> {{$s.Name}}</span>{{end}}</div>
+<svg width="{{$c.Width}}" height="{{$c.Height}}" id="chart{{$ci}}">
+{{range $c.YTicks}}<line class="grid" x1="{{$c.Left}}" x2="{{$c.Right}}" y1="{{.Pos}}" y2="{{.Pos}}"/><text x="{{$c.Left}}" dx="-6" y="{{.Pos}}" dy="4" text-anchor="end">{{.Label}}</text>
+{{end}}{{range $c.XTicks}}<line class="grid" x1="{{.Pos}}" x2="{{.Pos}}" y1="{{$c.Top}}" y2="{{$c.Bottom}}"/><text x="{{.Pos}}" y="{{$c.Bottom}}" dy="16" text-anchor="middle">{{.Label}}</text>
+{{end}}<line class="axis" x1="{{$c.Left}}" x2="{{$c.Right}}" y1="{{$c.Bottom}}" y2="{{$c.Bottom}}"/>
+<line class="axis" x1="{{$c.Left}}" x2="{{$c.Left}}" y1="{{$c.Top}}" y2="{{$c.Bottom}}"/>
+<text x="{{$c.Right}}" y="{{$c.Height}}" dy="-8" text-anchor="end">{{$c.XLabel}}</text>
+<text x="14" y="{{$c.Top}}" dy="10" transform="rotate(-90 14 {{$c.Top}})" text-anchor="end">{{$c.YLabel}}</text>
+{{range $si, $s := $c.Series}}<g class="series{{$si}}"><path d="{{$s.Path}}" fill="none" stroke="{{$s.Color}}" stroke-width="2"/>{{range $s.Points}}<circle cx="{{.X}}" cy="{{.Y}}" r="4" fill="{{$s.Color}}"><title>{{.Title}}</title></circle>{{end}}</g>
+{{end}}</svg>
+{{else}}<p>No datapoints for this chart.</p>{{end}}
+{{end}}
+<h2>Workloads</h2>
+<p class="note">Means over each workload's datapoints.  Click a heading to sort.</p>
+<table id="workloads">
+<thead><tr><th>instance</th><th>operation</th><th>set</th><th>goroutines</th><th>datapoints</th><th>MiB/s</th><th>p50 ms</th><th>p99 ms</th><th>errors %</th></tr></thead>
+<tbody>
+{{range .Rows}}<tr><td>{{.Instance}}</td><td>{{.Operation}}</td><td>{{.Set}}</td><td>{{.Goroutines}}</td><td>{{.N}}</td><td>{{printf "%.1f" .ThroughputMiBs}}</td><td>{{printf "%.2f" .P50ms}}</td><td>{{printf "%.2f" .P99ms}}</td><td>{{printf "%.2f" .ErrorRate}}</td></tr>
+{{end}}</tbody>
+</table>
+<script>
+document.querySelectorAll(".legend > span").forEach(function (item) {
+  item.addEventListener("click", function () {
+    item.classList.toggle("off");
+    document.querySelector("#chart" + item.dataset.chart + " .series" + item.dataset.series).classList.toggle("off-series");
+  });
+});
+document.querySelectorAll("#workloads th").forEach(function (th, col) {
+  th.addEventListener("click", function () {
+    var body = document.querySelector("#workloads tbody");
+    var rows = Array.from(body.rows);
+    var asc = th.dataset.order !== "asc";
+    th.dataset.order = asc ? "asc" : "desc";
+    rows.sort(function (a, b) {
+      var x = a.cells[col].textContent, y = b.cells[col].textContent;
+      var c = (isNaN(x) || isNaN(y)) ? x.localeCompare(y) : x - y;
+      return asc ? c : -c;
+    });
+    rows.forEach(function (r) { body.appendChild(r); });
+  });
+});
+</script>
+</body>
+</html>
+`))
+
+// readResults reads datapoints from a JSON results file or, by its
+// extension, a --store database.
+func readResults(name string) ([]Datapoint, error) {
+	if isStoreFile(name) {
+		return readStoredDatapoints(name)
+	}
+	return readDatapoints(name)
+}
+
+// isStoreFile reports whether a results file is a --store database, by its
+// extension.
+func isStoreFile(name string) bool {
+	switch filepath.Ext(name) {
+	case ".db", ".sqlite", ".sqlite3":
+		return true
+	}
+	return false
+}
+
+// reportDimensions are the fields --filter and --group-by name.  Any other
+// name is a --label key.
+var reportDimensions = map[string]func(Datapoint) string{
+	"instance":   func(dp Datapoint) string { return dp.EC2Instance },
+	"set":        func(dp Datapoint) string { return dp.FileSizeLabel },
+	"op":         func(dp Datapoint) string { return dp.Operation },
+	"goroutines": func(dp Datapoint) string { return strconv.Itoa(dp.Goroutines) },
+	"region":     func(dp Datapoint) string { return dp.InstanceRegion },
+	"az":         func(dp Datapoint) string { return dp.InstanceAZ },
+	"endpoint":   func(dp Datapoint) string { return dp.Endpoint },
+}
+
+func reportDimension(name string) func(Datapoint) string {
+	if get, ok := reportDimensions[name]; ok {
+		return get
+	}
+	return func(dp Datapoint) string { return dp.Labels[name] }
+}
+
+// filterDatapoints keeps the datapoints matching every name=value filter.
+// Filters on the same name are alternatives.
+func filterDatapoints(dps []Datapoint, filters []string) ([]Datapoint, error) {
+	allowed := map[string]map[string]bool{}
+	for _, f := range filters {
+		name, value, ok := strings.Cut(f, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("filter '%s' is not name=value", f)
+		}
+		if allowed[name] == nil {
+			allowed[name] = map[string]bool{}
+		}
+		allowed[name][value] = true
+	}
+
+	var kept []Datapoint
+	for _, dp := range dps {
+		match := true
+		for name, values := range allowed {
+			if !values[reportDimension(name)(dp)] {
+				match = false
+				break
+			}
+		}
+		if match {
+			kept = append(kept, dp)
+		}
+	}
+	return kept, nil
+}
+
+// lessValue orders the values of a --filter or --group-by name.  Numbers,
+// like goroutine counts, sort as numbers.
+func lessValue(a, b string) bool {
+	na, errA := strconv.ParseFloat(a, 64)
+	nb, errB := strconv.ParseFloat(b, 64)
+	if errA == nil && errB == nil {
+		return na < nb
+	}
+	return a < b
+}
+
+// reportGroupBy is the default --group-by.
+var reportGroupBy = []string{"instance", "op", "set", "goroutines"}
+
+// printGroups prints a table of the datapoints aggregated by the --group-by
+// fields: how many there are, and the mean and range of their throughput
+// and mean latencies and error rate.
+func printGroups(dps []Datapoint, groupBy []string) {
+	type group struct {
+		values []string
+		dps    []Datapoint
+	}
+	groups := map[string]*group{}
+	for _, dp := range dps {
+		values := make([]string, len(groupBy))
+		for i, name := range groupBy {
+			values[i] = reportDimension(name)(dp)
+		}
+		k := strings.Join(values, "\x00")
+		if groups[k] == nil {
+			groups[k] = &group{values: values}
+		}
+		groups[k].dps = append(groups[k].dps, dp)
+	}
+
+	sorted := make([]*group, 0, len(groups))
+	for _, g := range groups {
+		sorted = append(sorted, g)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		for k := range groupBy {
+			if a, b := sorted[i].values[k], sorted[j].values[k]; a != b {
+				return lessValue(a, b)
+			}
+		}
+		return false
+	})
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, name := range groupBy {
+		fmt.Fprintf(tw, "%s\t", name)
+	}
+	fmt.Fprintf(tw, "n\tMiB/s\tmin\tmax\tp50 ms\tp95 ms\tp99 ms\terrors %%\t\n")
+	for _, g := range sorted {
+		var tput, p50, p95, p99, errRate []float64
+		for _, dp := range g.dps {
+			tput = append(tput, dp.ThroughputMiBs)
+			p50 = append(p50, dp.P50Latency*1000)
+			p95 = append(p95, dp.P95Latency*1000)
+			p99 = append(p99, dp.P99Latency*1000)
+			errRate = append(errRate, dp.ErrorRate*100)
+		}
+		sort.Float64s(tput)
+		for _, v := range g.values {
+			if v == "" {
+				v = "-"
+			}
+			fmt.Fprintf(tw, "%s\t", v)
+		}
+		fmt.Fprintf(tw, "%d\t%.1f\t%.1f\t%.1f\t%.2f\t%.2f\t%.2f\t%.2f\t\n",
+			len(g.dps), mean(tput), tput[0], tput[len(tput)-1], mean(p50), mean(p95), mean(p99), mean(errRate))
+	}
+	tw.Flush()
+}
+
+// runReport answers questions of accumulated results files: it prints
+// their datapoints aggregated by --group-by, after any --filter, or with
+// --html renders them as a single HTML page of charts and a table, with no
+// outside scripts or styles, so it can be mailed or attached to a ticket as
+// is.
+func runReport(args []string) int {
+	fs := pflag.NewFlagSet("report", pflag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: s3skunk report [flags] <results.jsonl|results.db>...\n")
+		fs.PrintDefaults()
+	}
+	out := fs.String("html", "", "file to write an HTML report to, instead of printing a table")
+	filters := fs.StringArray("filter", nil, "only report datapoints with name=value, where name is instance, set, op, goroutines, region, az, endpoint or a --label key (repeatable; repeats of a name are alternatives)")
+	groupBy := fs.StringSlice("group-by", reportGroupBy, "names to aggregate datapoints by, as for --filter")
+	parseFlags(fs, args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	var dps []Datapoint
+	for _, name := range fs.Args() {
+		d, err := readResults(name)
+		if err != nil {
+			fatalf("error reading %s: %v", name, err)
+		}
+		dps = append(dps, d...)
+	}
+	dps, err := filterDatapoints(dps, *filters)
+	if err != nil {
+		fatalf("bad filter: %v", err)
+	}
+	if len(dps) == 0 {
+		fatalf("no datapoints to report")
+	}
+
+	if *out == "" {
+		printGroups(dps, *groupBy)
+		return 0
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		fatalf("error creating report: %v", err)
+	}
+	if err := reportTemplate.Execute(f, buildReport(fs.Args(), dps)); err != nil {
+		f.Close()
+		fatalf("error writing report: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		fatalf("error writing report: %v", err)
+	}
+	infof("wrote a report of %d datapoints to %s", len(dps), *out)
+	return 0
+}
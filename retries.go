@@ -0,0 +1,53 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// retryCounts tallies what the SDK retryer did, across all clients.  With
+// up to 10 attempts per request, retries can hide a lot of throttling from
+// the final results.
+type retryCounts struct {
+	retries   int64 // atomic
+	backoff   int64 // atomic; nanoseconds slept before retries
+	slowDowns int64 // atomic; 503 SlowDown responses, retried or not
+}
+
+var sdkRetries retryCounts
+
+func (rc *retryCounts) addSlowDown() {
+	atomic.AddInt64(&rc.slowDowns, 1)
+}
+
+// take returns the counts so far and starts afresh.
+func (rc *retryCounts) take() (retries, slowDowns int, backoff time.Duration) {
+	retries = int(atomic.SwapInt64(&rc.retries, 0))
+	slowDowns = int(atomic.SwapInt64(&rc.slowDowns, 0))
+	backoff = time.Duration(atomic.SwapInt64(&rc.backoff, 0))
+	return retries, slowDowns, backoff
+}
+
+// countingRetryer counts retries and their backoff in sdkRetries.  The retry
+// middleware asks for a delay only when it's about to retry.
+type countingRetryer struct {
+	aws.Retryer
+}
+
+func (r countingRetryer) RetryDelay(attempt int, opErr error) (time.Duration, error) {
+	d, err := r.Retryer.RetryDelay(attempt, opErr)
+	if err == nil {
+		atomic.AddInt64(&sdkRetries.retries, 1)
+		atomic.AddInt64(&sdkRetries.backoff, int64(d))
+	}
+	return d, err
+}
+
+// setRetries reports SDK retries, throttling and backoff.
+func (dp *Datapoint) setRetries() {
+	var backoff time.Duration
+	dp.Retries, dp.SlowDowns, backoff = sdkRetries.take()
+	dp.BackoffSecs = backoff.Seconds()
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SchemaVersion is the version of the Datapoint layout results are written
+// in.  Bump it for any change to the layout, adding a field included, and
+// note the change below, so that pipelines can tell which fields to expect.
+// A renamed or removed field, or one whose meaning changes, also needs
+// upgradeDatapoint to carry old results forward.
+//
+// Versions:
+//
+//	0  results from before SchemaVersion, which lack the field.  The
+//	   earliest have no Operation, as only downloads were benchmarked, and
+//	   those from before MongoDB output have no _id.
+//	1  SchemaVersion on every result.
+//	2  RawSampleRate, the fraction of requests with raw records; 0 for
+//	   earlier versions, where it went unrecorded.
+//	3  Client, how GETs were made; "sdk" for earlier versions, which had
+//	   no other way.
+//	4  NICAllowanceExceeded, ethtool's allowance counters by interface; nil
+//	   for earlier versions, which didn't read them.
+//	5  WriteSizeBytes, the bytes of --write-ratio PUTs, which are no longer
+//	   in TotalSizeBytes and ThroughputMiBs.  Earlier versions counted them
+//	   there, and can't be split apart; they're left as they were.
+//	6  PartConcurrency and PartSizeBytes also on downloads, the --client
+//	   manager Downloader's settings; before, they were for multipart and
+//	   copy only.  Versions 3 to 5 already set them so, unannounced, and
+//	   those before have none on downloads; nothing needs upgrading.
+//	7  Client "crt" for --client crt, with CRTTargetGbps, and PartSizeBytes
+//	   also on its downloads; absent from earlier versions, which had no
+//	   such client.
+const SchemaVersion = 7
+
+// decodeDatapoint reads a result of any schema version up to SchemaVersion
+// and upgrades it to the current layout.  Results written by a newer
+// s3skunk are an error, and so are fields a versioned layout doesn't have,
+// rather than silently dropped.  Results from before SchemaVersion are read
+// as leniently as they always were: unknown fields are ignored, and the
+// earliest have no StartTime.
+func decodeDatapoint(b []byte) (Datapoint, error) {
+	var v struct{ SchemaVersion int }
+	if err := json.Unmarshal(b, &v); err != nil {
+		return Datapoint{}, err
+	}
+	if v.SchemaVersion > SchemaVersion {
+		return Datapoint{}, fmt.Errorf("result has schema version %d, newer than this s3skunk's %d", v.SchemaVersion, SchemaVersion)
+	}
+
+	var dp Datapoint
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if v.SchemaVersion > 0 {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(&dp); err != nil {
+		return Datapoint{}, fmt.Errorf("schema version %d: %w", v.SchemaVersion, err)
+	}
+	if v.SchemaVersion > 0 && dp.StartTime.IsZero() {
+		return Datapoint{}, fmt.Errorf("result has no StartTime")
+	}
+	upgradeDatapoint(&dp)
+	return dp, nil
+}
+
+// upgradeDatapoint fills in what a result of an older schema version lacks,
+// one version at a time.
+func upgradeDatapoint(dp *Datapoint) {
+	if dp.SchemaVersion == 0 {
+		if dp.Operation == "" {
+			dp.Operation = "download"
+		}
+		if primitive.ObjectID(dp.ID).IsZero() {
+			if dp.StartTime.IsZero() {
+				dp.ID = resultID(primitive.NewObjectID())
+			} else {
+				dp.ID = newResultID(dp.StartTime)
+			}
+		}
+		dp.SchemaVersion = 1
+	}
+	if dp.SchemaVersion == 1 {
+		dp.SchemaVersion = 2
+	}
+	if dp.SchemaVersion == 2 {
+		dp.Client = "sdk"
+		dp.SchemaVersion = 3
+	}
+	if dp.SchemaVersion == 3 {
+		dp.SchemaVersion = 4
+	}
+	if dp.SchemaVersion == 4 {
+		dp.SchemaVersion = 5
+	}
+	if dp.SchemaVersion == 5 {
+		dp.SchemaVersion = 6
+	}
+	if dp.SchemaVersion == 6 {
+		dp.SchemaVersion = 7
+	}
+}
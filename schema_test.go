@@ -0,0 +1,117 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestDecodeDatapoint(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		wantErr string
+		check   func(t *testing.T, dp Datapoint)
+	}{
+		{
+			name: "baseline",
+			line: `{"EC2Instance":"c5n.18xlarge","FileSizeBytes":1048576,"FileSizeLabel":"M001","Goroutines":16,"TotalSizeBytes":1073741824,"ElapsedSecs":3.2,"P50Latency":0.02,"P95Latency":0.05,"P99Latency":0.08,"ThroughputMiBs":320}`,
+			check: func(t *testing.T, dp Datapoint) {
+				if dp.ThroughputMiBs != 320 || dp.Goroutines != 16 || dp.FileSizeLabel != "M001" {
+					t.Errorf("fields not read: %+v", dp)
+				}
+				if !dp.StartTime.IsZero() {
+					t.Errorf("StartTime = %v, want zero", dp.StartTime)
+				}
+			},
+		},
+		{
+			name: "version 0 with a field since dropped",
+			line: `{"FileSizeLabel":"K064","StartTime":"2021-12-01T10:00:00Z","SomethingOld":1}`,
+			check: func(t *testing.T, dp Datapoint) {
+				if got := primitive.ObjectID(dp.ID).Timestamp().Unix(); got != dp.StartTime.Unix() {
+					t.Errorf("ID timestamp = %d, want StartTime %d", got, dp.StartTime.Unix())
+				}
+			},
+		},
+		{
+			name: "version 3",
+			line: `{"SchemaVersion":3,"Operation":"download","Client":"manager","StartTime":"2023-06-01T10:00:00Z"}`,
+			check: func(t *testing.T, dp Datapoint) {
+				if dp.Client != "manager" {
+					t.Errorf("Client = %q, want it kept as manager", dp.Client)
+				}
+			},
+		},
+		{
+			name:    "versioned with an unknown field",
+			line:    `{"SchemaVersion":1,"StartTime":"2023-06-01T10:00:00Z","Bogus":1}`,
+			wantErr: "unknown field",
+		},
+		{
+			name:    "versioned without StartTime",
+			line:    `{"SchemaVersion":2}`,
+			wantErr: "no StartTime",
+		},
+		{
+			name:    "newer than this s3skunk",
+			line:    `{"SchemaVersion":1000}`,
+			wantErr: "newer",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dp, err := decodeDatapoint([]byte(tt.line))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dp.SchemaVersion != SchemaVersion {
+				t.Errorf("SchemaVersion = %d, want %d", dp.SchemaVersion, SchemaVersion)
+			}
+			if primitive.ObjectID(dp.ID).IsZero() {
+				t.Error("no ID")
+			}
+			if tt.check != nil {
+				tt.check(t, dp)
+			}
+		})
+	}
+}
+
+func TestUpgradeDatapoint(t *testing.T) {
+	tests := []struct {
+		name          string
+		in            Datapoint
+		wantOperation string
+		wantClient    string
+	}{
+		{"version 0", Datapoint{}, "download", "sdk"},
+		{"version 0 upload", Datapoint{Operation: "upload"}, "upload", "sdk"},
+		{"version 2", Datapoint{SchemaVersion: 2, Operation: "list"}, "list", "sdk"},
+		{"version 3", Datapoint{SchemaVersion: 3, Operation: "download", Client: "sdkv1"}, "download", "sdkv1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dp := tt.in
+			upgradeDatapoint(&dp)
+			if dp.SchemaVersion != SchemaVersion {
+				t.Errorf("SchemaVersion = %d, want %d", dp.SchemaVersion, SchemaVersion)
+			}
+			if dp.Operation != tt.wantOperation {
+				t.Errorf("Operation = %q, want %q", dp.Operation, tt.wantOperation)
+			}
+			if dp.Client != tt.wantClient {
+				t.Errorf("Client = %q, want %q", dp.Client, tt.wantClient)
+			}
+		})
+	}
+}
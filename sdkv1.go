@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	awsv1 "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	credentialsv1 "github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	s3v1 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// configS3V1 makes an aws-sdk-go v1 S3 client for --client sdkv1, set up as
+// configS3's v2 client is: the same credentials, endpoint, addressing,
+// connection settings and attempts per request, so that results differ
+// only by SDK.  Statuses, retries and throttling are counted as for v2, but
+// there are no per-attempt spans or debug logs.
+func configS3V1(cfg *myConfig) (*s3v1.S3, error) {
+	awscfg, err := loadAWSConfig(cfg, cfg.Region, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	v1cfg := awsv1.NewConfig().
+		WithRegion(awscfg.Region).
+		WithCredentials(credentialsv1.NewCredentials(&v2Credentials{provider: awscfg.Credentials})).
+		WithHTTPClient(&http.Client{Transport: newHTTPClient(cfg).GetTransport()}).
+		WithS3ForcePathStyle(cfg.PathStyle)
+	if cfg.Endpoint != "" {
+		v1cfg = v1cfg.WithEndpoint(cfg.Endpoint)
+	}
+	v1cfg = request.WithRetryer(v1cfg, countingRetryerV1{client.DefaultRetryer{NumMaxRetries: 9}})
+	sess, err := session.NewSession(v1cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	c := s3v1.New(sess)
+	c.Handlers.CompleteAttempt.PushBack(recordAttemptV1)
+	return c, nil
+}
+
+// v2Credentials gives v1 clients the credentials loadAWSConfig found, so
+// --profile, --role-arn and the rest work for both SDKs.
+type v2Credentials struct {
+	provider aws.CredentialsProvider
+	creds    aws.Credentials
+}
+
+func (c *v2Credentials) Retrieve() (credentialsv1.Value, error) {
+	creds, err := c.provider.Retrieve(context.TODO())
+	if err != nil {
+		return credentialsv1.Value{}, err
+	}
+	c.creds = creds
+	return credentialsv1.Value{
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.SessionToken,
+		ProviderName:    creds.Source,
+	}, nil
+}
+
+func (c *v2Credentials) IsExpired() bool {
+	return c.creds.Expired()
+}
+
+// countingRetryerV1 counts retries and their backoff in sdkRetries, as
+// countingRetryer does for v2.  RetryRules is asked for a delay only when
+// the request is about to be retried.
+type countingRetryerV1 struct {
+	client.DefaultRetryer
+}
+
+func (r countingRetryerV1) RetryRules(req *request.Request) time.Duration {
+	d := r.DefaultRetryer.RetryRules(req)
+	atomic.AddInt64(&sdkRetries.retries, 1)
+	atomic.AddInt64(&sdkRetries.backoff, int64(d))
+	return d
+}
+
+// recordAttemptV1 records each attempt's status and SlowDown errors, as
+// addStatusRecorder does for v2.
+func recordAttemptV1(r *request.Request) {
+	if r.HTTPResponse != nil {
+		attemptStatuses.add(r.HTTPResponse.StatusCode)
+	}
+	var aerr awserr.Error
+	if errors.As(r.Error, &aerr) && aerr.Code() == "SlowDown" {
+		sdkRetries.addSlowDown()
+	}
+}
+
+// getV1 makes fetch's GetObject with a v1 client, for --client sdkv1, and
+// records it just as fetch does.
+func (rs *runState) getV1(ctx, reqCtx context.Context, span trace.Span, ws *workerStats, c *s3v1.S3, in *s3.GetObjectInput, sink *bodySink, start time.Time) {
+	f := aws.ToString(in.Key)
+	req, resp := c.GetObjectRequest(&s3v1.GetObjectInput{Bucket: in.Bucket, Key: in.Key, Range: in.Range})
+	req.SetContext(reqCtx)
+	err := req.Send()
+	expRequests.Add(1)
+	statsd.Count("requests", 1, "")
+	atomic.AddInt64(&rs.requests, 1)
+	if err != nil {
+		rs.getFailed(ctx, reqCtx, span, f, start, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	// The v2 SDK's request IDs are in response metadata; these go in the
+	// same places.
+	var metadata middleware.Metadata
+	awsmiddleware.SetRequestIDMetadata(&metadata, req.RequestID)
+	setResponseIDs(span, metadata)
+	if hostID := req.HTTPResponse.Header.Get("X-Amz-Id-2"); hostID != "" {
+		span.SetAttributes(attribute.String("aws.s3.extended_request_id", hostID))
+	}
+	secs := rs.recordLatency(f, start)
+	if rs.worst != nil {
+		rs.worst.Add(f, secs, metadata)
+	}
+	// The v1 response has no v2 metadata for the raw record, so its
+	// attempts and status are filled in here.
+	rec := rawRecord{
+		Key:      f,
+		Start:    start,
+		TTFBSecs: time.Since(start).Seconds(),
+		Attempts: req.RetryCount + 1,
+		Status:   req.HTTPResponse.StatusCode,
+	}
+	n, err := sink.write(&countingReader{r: resp.Body, n: &rs.streamed})
+	contentLength := int64(-1)
+	if resp.ContentLength != nil {
+		contentLength = *resp.ContentLength
+	}
+	rs.readDone(ctx, reqCtx, span, ws, secs, n, contentLength, rec, nil, err)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"path"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/spf13/pflag"
+)
+
+// seedKey returns the key for the i'th file in a set.  Files are spread over
+// 256 subdirectories by the last byte of their name, matching gen-rand.pl.
+func seedKey(prefix, set string, i int) string {
+	base := fmt.Sprintf("%08x", i)
+	return path.Join(prefix, set, base[len(base)-2:], base)
+}
+
+// runSeed uploads a file set of random data, replacing what gen-rand.pl and
+// a manual sync used to do.
+func runSeed(args []string) int {
+	cfg := &myConfig{}
+	fs := pflag.NewFlagSet("seed", pflag.ExitOnError)
+	addS3Flags(fs, cfg)
+	fs.StringVar(&cfg.FileSetName, "set", "M001", "file set to upload")
+	fs.IntVar(&cfg.Goroutines, "goroutines", runtime.NumCPU(), "parallel uploads")
+	totalSize := fs.Uint("size", 0, "total size of the file set in MiB (default 1 GiB for K sets, 10 GiB for M sets)")
+	parseFlags(fs, args)
+
+	validateS3Flags(cfg)
+
+	fileSet, ok := fileSets[cfg.FileSetName]
+	if !ok {
+		fatalf("unknown file set '%s'", cfg.FileSetName)
+	}
+	if cfg.Goroutines < 1 {
+		fatalf("goroutines must be at least 1")
+	}
+
+	setSize := int(*totalSize) * MiB
+	if setSize == 0 {
+		setSize = 1024 * MiB
+		if fileSet.Size >= MiB {
+			setSize *= 10
+		}
+	}
+	if setSize%fileSet.Size != 0 {
+		fatalf("size (%d MiB) must be a multiple of the file set size (%d)", setSize/MiB, fileSet.Size)
+	}
+	numFiles := setSize / fileSet.Size
+
+	s3Client, err := configS3(cfg)
+	if err != nil {
+		fatalf("error configuring S3: %v", err)
+	}
+
+	infof("seeding %d files of %d bytes to s3://%s/%s", numFiles, fileSet.Size, cfg.Bucket, path.Join(cfg.Prefix, cfg.FileSetName))
+
+	work := make(chan int, cfg.Goroutines)
+	go func() {
+		for i := 0; i < numFiles; i++ {
+			work <- i
+		}
+		close(work)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Goroutines; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			buf := make([]byte, fileSet.Size)
+			for n := range work {
+				rng.Read(buf)
+				key := seedKey(cfg.Prefix, cfg.FileSetName, n)
+				_, err := s3Client.PutObject(context.Background(), &s3.PutObjectInput{
+					Bucket:        aws.String(cfg.Bucket),
+					Key:           aws.String(key),
+					Body:          bytes.NewReader(buf),
+					ContentLength: int64(len(buf)),
+				})
+				if err != nil {
+					fatalf("error uploading %s: %v", key, err)
+				}
+			}
+		}(time.Now().UnixNano() + int64(i))
+	}
+	wg.Wait()
+
+	return 0
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/spf13/pflag"
+)
+
+// DefaultSelectExpression matches about 1% of the rows of a seeded CSV.
+const DefaultSelectExpression = "SELECT s.id FROM s3object s WHERE s.category = 'c07'"
+
+func parseSelectFlags(args []string) *myConfig {
+	cfg := &myConfig{}
+	fs := pflag.NewFlagSet("select", pflag.ExitOnError)
+	addS3Flags(fs, cfg)
+	fs.IntVar(&cfg.Count, "count", 1, "number of datapoints to generate")
+	fs.StringVar(&cfg.EC2Instance, "instance", "", "EC2 instance type (default: detected from instance metadata)")
+	fs.StringVar(&cfg.Key, "key", "", "existing object to query (default: seed a CSV object under --scratch-prefix)")
+	fs.StringVar(&cfg.SelectFormat, "input-format", "csv", "format of the object: csv (with a header row) or parquet")
+	fs.StringVar(&cfg.SelectExpression, "expression", DefaultSelectExpression, "SQL expression to run")
+	fs.IntVar(&cfg.SelectQueries, "queries", 5, "queries, one at a time, per datapoint")
+	csvSize := fs.Uint("csv-size", 64, "size in MiB of the CSV object to seed when --key isn't given")
+	fs.StringVar(&cfg.ScratchPrefix, "scratch-prefix", "s3skunk-scratch", "prefix to seed the CSV object under")
+	fs.BoolVar(&cfg.KeepScratch, "keep", false, "don't delete the seeded CSV object")
+	addResultFlags(fs, cfg)
+	parseFlags(fs, args)
+
+	validateS3Flags(cfg)
+	validateResultFlags(cfg)
+
+	switch cfg.SelectFormat {
+	case "csv":
+	case "parquet":
+		if cfg.Key == "" {
+			fatalf("--input-format parquet needs --key; only CSV objects can be seeded")
+		}
+	default:
+		fatalf("unknown input format '%s'", cfg.SelectFormat)
+	}
+	if cfg.SelectQueries < 1 {
+		fatalf("queries must be at least 1")
+	}
+	cfg.ObjectSizeBytes = int(*csvSize) * MiB
+	if cfg.Key == "" && cfg.ObjectSizeBytes == 0 {
+		fatalf("csv-size must be at least 1 MiB")
+	}
+
+	return cfg
+}
+
+// seedCSV uploads a CSV object of about size bytes with columns id,
+// category (c00 to c99) and a random payload.
+func seedCSV(cfg *myConfig, s3Client *s3.Client, key string, size int) error {
+	rng := rand.New(rand.NewSource(cfg.Seed))
+	var buf bytes.Buffer
+	buf.Grow(size + 64)
+	buf.WriteString("id,category,payload\n")
+	for id := 0; buf.Len() < size; id++ {
+		fmt.Fprintf(&buf, "%d,c%02d,%016x%016x\n", id, rng.Intn(100), rng.Uint64(), rng.Uint64())
+	}
+
+	_, err := s3Client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket:        aws.String(cfg.Bucket),
+		Key:           aws.String(key),
+		Body:          bytes.NewReader(buf.Bytes()),
+		ContentLength: int64(buf.Len()),
+	})
+	return err
+}
+
+// selectResult is what one query observed.
+type selectResult struct {
+	firstRecord   time.Duration // 0 if no records came back
+	bytesScanned  int64
+	bytesReturned int64
+}
+
+// selectOnce runs the expression against key and drains the event stream.
+func selectOnce(ctx context.Context, cfg *myConfig, s3Client *s3.Client, key string) (selectResult, error) {
+	var res selectResult
+	input := &types.InputSerialization{}
+	if cfg.SelectFormat == "parquet" {
+		input.Parquet = &types.ParquetInput{}
+	} else {
+		input.CSV = &types.CSVInput{FileHeaderInfo: types.FileHeaderInfoUse}
+	}
+
+	start := time.Now()
+	resp, err := s3Client.SelectObjectContent(ctx, &s3.SelectObjectContentInput{
+		Bucket:              aws.String(cfg.Bucket),
+		Key:                 aws.String(key),
+		Expression:          aws.String(cfg.SelectExpression),
+		ExpressionType:      types.ExpressionTypeSql,
+		InputSerialization:  input,
+		OutputSerialization: &types.OutputSerialization{CSV: &types.CSVOutput{}},
+	})
+	if err != nil {
+		return res, err
+	}
+	stream := resp.GetStream()
+	defer stream.Close()
+
+	for event := range stream.Events() {
+		switch e := event.(type) {
+		case *types.SelectObjectContentEventStreamMemberRecords:
+			if res.firstRecord == 0 && len(e.Value.Payload) > 0 {
+				res.firstRecord = time.Since(start)
+			}
+		case *types.SelectObjectContentEventStreamMemberStats:
+			if e.Value.Details != nil {
+				res.bytesScanned = e.Value.Details.BytesScanned
+				res.bytesReturned = e.Value.Details.BytesReturned
+			}
+		}
+	}
+	return res, stream.Err()
+}
+
+// runSelect benchmarks S3 Select: time to first record and scan throughput
+// for an expression over a CSV or Parquet object.
+func runSelect(args []string) int {
+	cfg := parseSelectFlags(args)
+	prepareRun(cfg)
+
+	s3Client, err := configS3(cfg)
+	if err != nil {
+		fatalf("error configuring S3: %v", err)
+	}
+
+	key := cfg.Key
+	if key == "" {
+		key = path.Join(cfg.ScratchPrefix, "select", strconv.FormatInt(time.Now().UnixNano(), 10)+".csv")
+		debugf("seeding %d MiB CSV object %s", cfg.ObjectSizeBytes/MiB, key)
+		if err := seedCSV(cfg, s3Client, key, cfg.ObjectSizeBytes); err != nil {
+			fatalf("error seeding %s: %v", key, err)
+		}
+	}
+
+	for i := 0; i < cfg.Count; i++ {
+		td := newLatencyDigest()
+		tracker := newConnTracker()
+		ctx := withConnTracker(context.Background(), tracker)
+		var scanned, returned int64
+		startTime := time.Now()
+
+		for q := 0; q < cfg.SelectQueries; q++ {
+			res, err := selectOnce(ctx, cfg, s3Client, key)
+			expRequests.Add(1)
+			if err != nil {
+				expErrors.Add(1)
+				fatalf("error selecting from %s: %v", key, err)
+			}
+			if res.firstRecord == 0 {
+				warnf("expression returned no records from %s", key)
+			} else {
+				td.Add(res.firstRecord.Seconds())
+			}
+			scanned += res.bytesScanned
+			returned += res.bytesReturned
+		}
+		elapsedSec := time.Since(startTime).Seconds()
+
+		datapoint := baseDatapoint(cfg)
+		datapoint.Operation = "select"
+		datapoint.Goroutines = 1
+		datapoint.SelectExpression = cfg.SelectExpression
+		datapoint.TotalSizeBytes = int(scanned)
+
+		datapoint.StartTime = startTime
+		datapoint.ElapsedSecs = elapsedSec
+		datapoint.setLatencies(td, cfg.Quantiles)
+		datapoint.ThroughputMiBs = float64(scanned) / MiB / elapsedSec
+		datapoint.RequestsPerSec = float64(cfg.SelectQueries) / elapsedSec
+		datapoint.BytesReturned = returned
+		datapoint.setConnStats(tracker, elapsedSec)
+
+		emitDatapoint(cfg, datapoint)
+	}
+
+	if cfg.Key == "" && !cfg.KeepScratch {
+		_, err := s3Client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+			Bucket: aws.String(cfg.Bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			fatalf("error cleaning up s3://%s/%s: %v", cfg.Bucket, key, err)
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// countingReader adds the bytes read through it to an atomic counter as
+// they arrive, rather than when the whole body is done.
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	atomic.AddInt64(cr.n, int64(n))
+	return n, err
+}
+
+// throughputSampler turns an atomic byte counter into MiB/s for each second
+// of a run.
+type throughputSampler struct {
+	sync.Mutex
+	counter *int64
+	last    int64
+	samples []float64
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func startThroughputSampler(counter *int64) *throughputSampler {
+	ts := &throughputSampler{
+		counter: counter,
+		last:    atomic.LoadInt64(counter),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go func() {
+		defer close(ts.done)
+		tick := time.NewTicker(time.Second)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				now := atomic.LoadInt64(ts.counter)
+				ts.Lock()
+				ts.samples = append(ts.samples, float64(now-ts.last)/MiB)
+				ts.last = now
+				ts.Unlock()
+			case <-ts.stop:
+				return
+			}
+		}
+	}()
+	return ts
+}
+
+// Stop ends sampling; a partial last second isn't sampled.
+func (ts *throughputSampler) Stop() {
+	close(ts.stop)
+	<-ts.done
+}
+
+// take returns the samples so far and starts a fresh series.
+func (ts *throughputSampler) take() []float64 {
+	ts.Lock()
+	defer ts.Unlock()
+	samples := ts.samples
+	ts.samples = nil
+	return samples
+}
+
+// setThroughputSeries summarizes per-second throughput, and with
+// --throughput-series includes the samples themselves.
+func (dp *Datapoint) setThroughputSeries(samples []float64, keep bool) {
+	for i, v := range samples {
+		if i == 0 || v < dp.ThroughputMinMiBs {
+			dp.ThroughputMinMiBs = v
+		}
+		if v > dp.ThroughputMaxMiBs {
+			dp.ThroughputMaxMiBs = v
+		}
+	}
+	if keep {
+		dp.ThroughputSeries = samples
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// sinkBufferSize is the buffer each worker writes bodies to disk through.
+const sinkBufferSize = 1 * MiB
+
+// directAlign is the buffer and write alignment O_DIRECT needs.
+const directAlign = 4096
+
+// parseSink parses --sink, "discard" or "disk:<dir>", returning the
+// directory to write to, or "" to discard.
+func parseSink(spec string) (string, error) {
+	if spec == "discard" {
+		return "", nil
+	}
+	name, dir, _ := strings.Cut(spec, ":")
+	if name != "disk" || dir == "" {
+		return "", fmt.Errorf("unknown sink '%s'; want discard or disk:<dir>", spec)
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return "", err
+	}
+	if !fi.IsDir() {
+		return "", fmt.Errorf("%s isn't a directory", dir)
+	}
+	return dir, nil
+}
+
+// bodySink is where a worker puts the bodies it downloads: nowhere, or with
+// --sink disk:<dir>, a file of its own that each body overwrites.
+type bodySink struct {
+	cfg  *myConfig
+	path string // empty to discard
+	buf  []byte
+}
+
+func newBodySink(cfg *myConfig, worker int) *bodySink {
+	if cfg.SinkDir == "" {
+		return &bodySink{cfg: cfg}
+	}
+	return &bodySink{
+		cfg:  cfg,
+		path: filepath.Join(cfg.SinkDir, "s3skunk-"+strconv.Itoa(worker)+".tmp"),
+		buf:  alignedBuffer(sinkBufferSize),
+	}
+}
+
+// alignedBuffer returns a buffer of size bytes starting on a directAlign
+// boundary.
+func alignedBuffer(size int) []byte {
+	b := make([]byte, size+directAlign)
+	off := 0
+	if rem := int(uintptr(unsafe.Pointer(&b[0])) % directAlign); rem != 0 {
+		off = directAlign - rem
+	}
+	return b[off : off+size]
+}
+
+// write drains r into the sink and returns how many bytes it read.
+func (bs *bodySink) write(r io.Reader) (int64, error) {
+	if bs.path == "" {
+		return io.Copy(io.Discard, r)
+	}
+
+	f, err := openSinkFile(bs.path, bs.cfg.SinkDirect)
+	if err != nil {
+		return 0, err
+	}
+	n, err := bs.copy(f, r)
+	if err == nil && bs.cfg.SinkFsync {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// copy writes r to f through the aligned buffer.  O_DIRECT can only write
+// whole blocks, so a short last block is padded and the file truncated
+// back to the body's length afterwards.
+func (bs *bodySink) copy(f *os.File, r io.Reader) (int64, error) {
+	if !bs.cfg.SinkDirect {
+		return io.CopyBuffer(f, r, bs.buf)
+	}
+
+	var total int64
+	for {
+		n, err := io.ReadFull(r, bs.buf)
+		if n > 0 {
+			padded := (n + directAlign - 1) / directAlign * directAlign
+			if _, werr := f.Write(bs.buf[:padded]); werr != nil {
+				return total, werr
+			}
+			total += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, f.Truncate(total)
+}
+
+// remove deletes the sink's file, if it has one.
+func (bs *bodySink) remove() error {
+	if bs.path == "" {
+		return nil
+	}
+	err := os.Remove(bs.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
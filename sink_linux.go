@@ -0,0 +1,16 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// openSinkFile opens path for writing a body, truncating it, and bypassing
+// the page cache if direct is set.
+func openSinkFile(path string, direct bool) (*os.File, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if direct {
+		flags |= syscall.O_DIRECT
+	}
+	return os.OpenFile(path, flags, 0o644)
+}
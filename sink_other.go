@@ -0,0 +1,17 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// openSinkFile opens path for writing a body, truncating it.  O_DIRECT
+// isn't available outside Linux.
+func openSinkFile(path string, direct bool) (*os.File, error) {
+	if direct {
+		return nil, errors.New("--sink-direct is only supported on Linux")
+	}
+	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+}
@@ -0,0 +1,28 @@
+package main
+
+// sloResult evaluates a datapoint against --slo objectives.
+type sloResult struct {
+	Pass       bool // every objective met
+	Objectives []sloObjective
+}
+
+type sloObjective struct {
+	Objective string // as given, e.g. "p99<=150ms"
+	Value     float64
+	Pass      bool
+}
+
+// evaluateSLO checks dp against each --slo, or returns nil if there are
+// none.  Unlike --assert, a miss only shows in the result.
+func evaluateSLO(cfg *myConfig, dp Datapoint) *sloResult {
+	if len(cfg.SLOs) == 0 {
+		return nil
+	}
+	res := &sloResult{Pass: true}
+	for _, a := range cfg.SLOs {
+		o := sloObjective{Objective: a.spec, Value: assertMetrics[a.metric].get(dp), Pass: a.holds(dp)}
+		res.Pass = res.Pass && o.Pass
+		res.Objectives = append(res.Objectives, o)
+	}
+	return res
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/spf13/pflag"
+)
+
+func parseSplitDownloadFlags(args []string) *myConfig {
+	cfg := &myConfig{}
+	fs := pflag.NewFlagSet("split-download", pflag.ExitOnError)
+	addS3Flags(fs, cfg)
+	fs.IntVar(&cfg.Count, "count", 1, "number of datapoints to generate per range count")
+	fs.StringVar(&cfg.EC2Instance, "instance", "", "EC2 instance type (default: detected from instance metadata)")
+	fs.StringVar(&cfg.FileSetName, "set", "M064", "file set to take the object from, if --key isn't given")
+	fs.StringVar(&cfg.Key, "key", "", "object to download (default: the first object in --set)")
+	fs.IntSliceVar(&cfg.RangeCounts, "range-counts", []int{1, 2, 4, 8, 16, 32}, "numbers of concurrent ranges to split the object into")
+	fs.BoolVar(&cfg.Reassemble, "reassemble", false, "copy the parts into one buffer, as a transfer manager would, instead of discarding them")
+	addResultFlags(fs, cfg)
+	parseFlags(fs, args)
+
+	validateS3Flags(cfg)
+	validateResultFlags(cfg)
+
+	if _, ok := fileSets[cfg.FileSetName]; !ok && cfg.Key == "" {
+		fatalf("unknown file set '%s'", cfg.FileSetName)
+	}
+	for _, n := range cfg.RangeCounts {
+		if n < 1 {
+			fatalf("range counts must be at least 1")
+		}
+	}
+
+	return cfg
+}
+
+// splitDownload fetches size bytes of key as n concurrent byte ranges.  With
+// buf, each range is read into its place in buf; otherwise it's discarded.
+func splitDownload(ctx context.Context, cfg *myConfig, s3Client *s3.Client, key string, size int64, n int, buf []byte, latency chan<- float64) error {
+	rangeSize := (size + int64(n) - 1) / int64(n)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		first := int64(i) * rangeSize
+		if first >= size {
+			break
+		}
+		last := first + rangeSize - 1
+		if last >= size {
+			last = size - 1
+		}
+
+		wg.Add(1)
+		go func(first, last int64) {
+			defer wg.Done()
+			start := time.Now()
+			resp, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+				Bucket: aws.String(cfg.Bucket),
+				Key:    aws.String(key),
+				Range:  aws.String(fmt.Sprintf("bytes=%d-%d", first, last)),
+			})
+			expRequests.Add(1)
+			if err != nil {
+				expErrors.Add(1)
+				errs <- err
+				return
+			}
+			defer resp.Body.Close()
+			latency <- time.Since(start).Seconds()
+
+			var read int64
+			if buf != nil {
+				var nr int
+				nr, err = io.ReadFull(resp.Body, buf[first:last+1])
+				read = int64(nr)
+			} else {
+				read, err = io.Copy(io.Discard, resp.Body)
+			}
+			expBytesRead.Add(read)
+			if err != nil {
+				errs <- err
+			}
+		}(first, last)
+	}
+	wg.Wait()
+	close(errs)
+
+	return <-errs
+}
+
+// runSplitDownload benchmarks downloading one large object by splitting it
+// into concurrent byte-range requests, the way transfer managers do, for
+// each of --range-counts.
+func runSplitDownload(args []string) int {
+	cfg := parseSplitDownloadFlags(args)
+	prepareRun(cfg)
+
+	s3Client, err := configS3(cfg)
+	if err != nil {
+		fatalf("error configuring S3: %v", err)
+	}
+
+	key := cfg.Key
+	if key == "" {
+		files, err := listS3Files(cfg, s3Client)
+		if err != nil {
+			fatalf("error listing file set: %v", err)
+		}
+		if len(files) == 0 {
+			fatalf("no S3 files found for file set %s under s3://%s/%s (see the list-sets command)", cfg.FileSetName, cfg.Bucket, cfg.Prefix)
+		}
+		key = files[0]
+	}
+
+	head, err := s3Client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(cfg.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		fatalf("error getting size of %s: %v", key, err)
+	}
+	size := head.ContentLength
+	if size == 0 {
+		fatalf("%s is empty", key)
+	}
+
+	var buf []byte
+	if cfg.Reassemble {
+		buf = make([]byte, size)
+	}
+
+	for _, n := range cfg.RangeCounts {
+		for i := 0; i < cfg.Count; i++ {
+			// A fresh client each time, so every datapoint pays for its
+			// own connections.
+			s3Client, err := configS3(cfg)
+			if err != nil {
+				fatalf("error configuring S3: %v", err)
+			}
+			debugf("downloading %s (%d bytes) as %d ranges", key, size, n)
+
+			latency := make(chan float64, n)
+			td := newLatencyDigest()
+			tracker := newConnTracker()
+			ctx := withConnTracker(context.Background(), tracker)
+			startTime := time.Now()
+
+			if err := splitDownload(ctx, cfg, s3Client, key, size, n, buf, latency); err != nil {
+				fatalf("error downloading %s: %v", key, err)
+			}
+			elapsedSec := time.Since(startTime).Seconds()
+
+			// The channel holds one latency per range, so no collector is
+			// needed.
+			close(latency)
+			for v := range latency {
+				td.Add(v)
+			}
+
+			datapoint := baseDatapoint(cfg)
+			datapoint.Operation = "split-download"
+			datapoint.FileSizeBytes = int(size)
+			datapoint.Goroutines = n
+			datapoint.RangeSizeBytes = int((size + int64(n) - 1) / int64(n))
+			datapoint.TotalSizeBytes = int(size)
+
+			datapoint.StartTime = startTime
+			datapoint.ElapsedSecs = elapsedSec
+			datapoint.setLatencies(td, cfg.Quantiles)
+			datapoint.ThroughputMiBs = float64(size) / MiB / elapsedSec
+			datapoint.setConnStats(tracker, elapsedSec)
+
+			emitDatapoint(cfg, datapoint)
+		}
+	}
+
+	return 0
+}
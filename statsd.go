@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StatsdPrefix starts the name of every metric sent to statsd.
+const StatsdPrefix = "s3skunk."
+
+// statsdPacketBytes keeps packets within a typical MTU.
+const statsdPacketBytes = 1432
+
+// statsdSender streams metrics to a statsd or DogStatsD agent over UDP.  It's
+// nil, and its methods do nothing, unless --statsd-addr is given.  Sends
+// never block a request: when the agent or network can't keep up, metrics
+// are dropped.
+type statsdSender struct {
+	conn net.Conn
+	tags string // DogStatsD suffix, e.g. "|#op:get,set:K064"
+	ch   chan string
+
+	stopOnce sync.Once
+	stop     chan struct{} // closed by stopStatsd
+	stopped  chan struct{} // closed once flush has sent everything
+}
+
+var statsd *statsdSender
+
+func startStatsd(addr string, tags []string) error {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return err
+	}
+	s := &statsdSender{
+		conn:    conn,
+		ch:      make(chan string, 4096),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if len(tags) > 0 {
+		s.tags = "|#" + strings.Join(tags, ",")
+	}
+	go s.flush()
+	statsd = s
+	return nil
+}
+
+// stopStatsd sends the metrics still queued and closes the socket, so the
+// end of a run isn't lost.  Metrics sent afterwards are dropped.
+func stopStatsd() {
+	s := statsd
+	if s == nil {
+		return
+	}
+	s.stopOnce.Do(func() { close(s.stop) })
+	<-s.stopped
+}
+
+// flush batches metrics into packets, sending each when it's full or at
+// least every 100ms.  Once stopped, it drains the queue, sends the last
+// packet and closes the socket.
+func (s *statsdSender) flush() {
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+	var buf []byte
+	send := func() {
+		if len(buf) > 0 {
+			s.conn.Write(buf)
+			buf = buf[:0]
+		}
+	}
+	add := func(m string) {
+		if len(buf)+len(m)+1 > statsdPacketBytes {
+			send()
+		}
+		if len(buf) > 0 {
+			buf = append(buf, '\n')
+		}
+		buf = append(buf, m...)
+	}
+	for {
+		select {
+		case m := <-s.ch:
+			add(m)
+		case <-tick.C:
+			send()
+		case <-s.stop:
+			// Requests may still be running, when stopped by fatalf, so
+			// the channel stays open; take only what's queued now.
+			for n := len(s.ch); n > 0; n-- {
+				add(<-s.ch)
+			}
+			send()
+			s.conn.Close()
+			close(s.stopped)
+			return
+		}
+	}
+}
+
+func (s *statsdSender) send(name, value, kind, tags string) {
+	if s == nil {
+		return
+	}
+	m := StatsdPrefix + name + ":" + value + "|" + kind + s.tags
+	if tags != "" {
+		if s.tags == "" {
+			m += "|#" + tags
+		} else {
+			m += "," + tags
+		}
+	}
+	select {
+	case s.ch <- m:
+	default:
+	}
+}
+
+// Timing sends a request latency, in milliseconds.
+func (s *statsdSender) Timing(name string, secs float64) {
+	s.send(name, strconv.FormatFloat(secs*1000, 'f', 3, 64), "ms", "")
+}
+
+// Count adds n to a counter, with optional extra DogStatsD tags.
+func (s *statsdSender) Count(name string, n int64, tags string) {
+	s.send(name, strconv.FormatInt(n, 10), "c", tags)
+}
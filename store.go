@@ -0,0 +1,237 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	_ "modernc.org/sqlite" // pure Go, so cross-compiled builds have --store
+)
+
+// storeTimeLayout has fixed-width fractional seconds, so times stored as
+// text in UTC sort in time order.
+const storeTimeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// storeSchema sets up a --store database.  Each datapoint keeps its whole
+// JSON result, for json_extract; the columns beside it are for indexes and
+// the usual queries.  Requests are the --raw-output records of a datapoint,
+// with --store-requests.
+const storeSchema = `
+CREATE TABLE IF NOT EXISTS datapoints (
+	id              TEXT PRIMARY KEY,
+	start_time      TEXT NOT NULL,
+	instance        TEXT NOT NULL,
+	file_set        TEXT NOT NULL,
+	operation       TEXT NOT NULL,
+	goroutines      INTEGER NOT NULL,
+	throughput_mibs REAL NOT NULL,
+	p50_latency     REAL NOT NULL,
+	p99_latency     REAL NOT NULL,
+	error_rate      REAL NOT NULL,
+	result          TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS datapoints_instance ON datapoints (instance, start_time);
+CREATE INDEX IF NOT EXISTS datapoints_file_set ON datapoints (file_set, start_time);
+CREATE INDEX IF NOT EXISTS datapoints_start_time ON datapoints (start_time);
+
+CREATE TABLE IF NOT EXISTS requests (
+	datapoint_id TEXT NOT NULL REFERENCES datapoints (id),
+	key          TEXT NOT NULL,
+	size_bytes   INTEGER NOT NULL,
+	start_time   TEXT NOT NULL,
+	ttfb_secs    REAL NOT NULL,
+	total_secs   REAL NOT NULL,
+	attempts     INTEGER NOT NULL,
+	status       INTEGER NOT NULL,
+	timed_out    INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS requests_datapoint ON requests (datapoint_id);
+`
+
+// resultStore is a --store SQLite database that accumulates results across
+// runs.
+type resultStore struct {
+	db *sql.DB
+}
+
+// store is the --store database, opened on first use.
+var store *resultStore
+
+// openStore opens or creates a result store.  Several benchmarks may share
+// one, so writers wait a while for each other's locks.
+func openStore(name string) (*resultStore, error) {
+	db, err := sql.Open("sqlite", "file:"+name+"?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(storeSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &resultStore{db: db}, nil
+}
+
+// Add inserts a datapoint and its request records in one transaction.  A
+// datapoint whose ID is stored already is skipped with a warning, as MongoDB
+// inserts skip duplicate _ids, rather than ending the run.
+func (s *resultStore) Add(dp Datapoint) error {
+	result, err := json.Marshal(dp)
+	if err != nil {
+		return err
+	}
+	id := dp.ID.Hex()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT INTO datapoints
+		(id, start_time, instance, file_set, operation, goroutines, throughput_mibs, p50_latency, p99_latency, error_rate, result)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO NOTHING`,
+		id, dp.StartTime.UTC().Format(storeTimeLayout), dp.EC2Instance, dp.FileSizeLabel, dp.Operation, dp.Goroutines,
+		dp.ThroughputMiBs, dp.P50Latency, dp.P99Latency, dp.ErrorRate, string(result))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		warnf("result %s is already in the store; skipping it", id)
+		return nil
+	}
+
+	if len(dp.requests) > 0 {
+		stmt, err := tx.Prepare(`INSERT INTO requests
+			(datapoint_id, key, size_bytes, start_time, ttfb_secs, total_secs, attempts, status, timed_out)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, r := range dp.requests {
+			_, err := stmt.Exec(id, r.Key, r.SizeBytes, r.Start.UTC().Format(storeTimeLayout), r.TTFBSecs, r.TotalSecs, r.Attempts, r.Status, r.TimedOut)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// storeResult adds a datapoint to --store, if given.
+func storeResult(cfg *myConfig, dp Datapoint) error {
+	if cfg.Store == "" {
+		return nil
+	}
+	if store == nil {
+		s, err := openStore(cfg.Store)
+		if err != nil {
+			return fmt.Errorf("opening %s: %w", cfg.Store, err)
+		}
+		store = s
+	}
+	return store.Add(dp)
+}
+
+// closeStore closes the --store database, if it was opened.
+func closeStore() error {
+	if store == nil {
+		return nil
+	}
+	s := store
+	store = nil
+	return s.db.Close()
+}
+
+// openStoreReadOnly opens an existing --store database for reading, while
+// benchmarks may still be adding to it.
+func openStoreReadOnly(name string) (*sql.DB, error) {
+	if _, err := os.Stat(name); err != nil {
+		return nil, err
+	}
+	return sql.Open("sqlite", "file:"+name+"?mode=ro&_pragma=busy_timeout(10000)")
+}
+
+// readStoredDatapoints reads the datapoints in a --store database, skipping
+// --count summaries as readDatapoints does.
+func readStoredDatapoints(name string) ([]Datapoint, error) {
+	return readStoredDatapointsBetween(name, time.Time{}, time.Time{})
+}
+
+// readStoredDatapointsBetween reads the datapoints in a --store database
+// that started from from to to, in time order, as readStoredDatapoints
+// does.  A zero time leaves that end open.
+func readStoredDatapointsBetween(name string, from, to time.Time) ([]Datapoint, error) {
+	db, err := openStoreReadOnly(name)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	query := `SELECT result FROM datapoints WHERE 1=1`
+	var args []interface{}
+	if !from.IsZero() {
+		query += ` AND start_time >= ?`
+		args = append(args, from.UTC().Format(storeTimeLayout))
+	}
+	if !to.IsZero() {
+		query += ` AND start_time <= ?`
+		args = append(args, to.UTC().Format(storeTimeLayout))
+	}
+	rows, err := db.Query(query+` ORDER BY start_time`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var dps []Datapoint
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			return nil, err
+		}
+		dp, err := decodeDatapoint([]byte(result))
+		if err != nil {
+			return nil, err
+		}
+		if dp.Iterations == 0 {
+			dps = append(dps, dp)
+		}
+	}
+	return dps, rows.Err()
+}
+
+// readStoredRequests reads the --store-requests records in a --store
+// database, by the hex ID of their datapoint.
+func readStoredRequests(name string) (map[string][]rawRecord, error) {
+	db, err := openStoreReadOnly(name)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT datapoint_id, key, size_bytes, start_time, ttfb_secs, total_secs, attempts, status, timed_out
+		FROM requests`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	recs := map[string][]rawRecord{}
+	for rows.Next() {
+		var id, start string
+		var r rawRecord
+		if err := rows.Scan(&id, &r.Key, &r.SizeBytes, &start, &r.TTFBSecs, &r.TotalSecs, &r.Attempts, &r.Status, &r.TimedOut); err != nil {
+			return nil, err
+		}
+		if r.Start, err = time.Parse(storeTimeLayout, start); err != nil {
+			return nil, err
+		}
+		recs[id] = append(recs[id], r)
+	}
+	return recs, rows.Err()
+}
@@ -0,0 +1,96 @@
+package main
+
+import "math"
+
+// iterationStat summarizes one measure across the iterations of a --count
+// run.
+type iterationStat struct {
+	Mean   float64
+	StdDev float64 // sample standard deviation; 0 for one iteration
+	Min    float64
+	Max    float64
+}
+
+func newIterationStat(vs []float64) iterationStat {
+	var s iterationStat
+	for i, v := range vs {
+		if i == 0 || v < s.Min {
+			s.Min = v
+		}
+		if i == 0 || v > s.Max {
+			s.Max = v
+		}
+		s.Mean += v
+	}
+	s.Mean /= float64(len(vs))
+	if len(vs) > 1 {
+		var ss float64
+		for _, v := range vs {
+			ss += (v - s.Mean) * (v - s.Mean)
+		}
+		s.StdDev = math.Sqrt(ss / float64(len(vs)-1))
+	}
+	return s
+}
+
+// summarizeIterations returns a datapoint covering all of dps, the
+// iterations of one configuration: throughput and latencies are their
+// means, and IterationStats has the spread of each.  --quantiles are in
+// IterationStats keyed like "Quantiles/0.999".
+func summarizeIterations(cfg *myConfig, dps []Datapoint) Datapoint {
+	measures := map[string]func(Datapoint) float64{
+		"ThroughputMiBs": func(dp Datapoint) float64 { return dp.ThroughputMiBs },
+		"RequestsPerSec": func(dp Datapoint) float64 { return dp.RequestsPerSec },
+		"P50Latency":     func(dp Datapoint) float64 { return dp.P50Latency },
+		"P95Latency":     func(dp Datapoint) float64 { return dp.P95Latency },
+		"P99Latency":     func(dp Datapoint) float64 { return dp.P99Latency },
+		"FullP99Latency": func(dp Datapoint) float64 { return dp.FullP99Latency },
+	}
+	for q := range dps[0].Quantiles {
+		q := q
+		measures["Quantiles/"+q] = func(dp Datapoint) float64 { return dp.Quantiles[q] }
+	}
+	stats := make(map[string]iterationStat, len(measures))
+	vs := make([]float64, len(dps))
+	for name, get := range measures {
+		for i, dp := range dps {
+			vs[i] = get(dp)
+		}
+		stats[name] = newIterationStat(vs)
+	}
+
+	summary := baseDatapoint(cfg)
+	summary.Operation = dps[0].Operation
+	summary.StartTime = dps[0].StartTime
+	summary.Iterations = len(dps)
+	summary.IterationStats = stats
+	for _, dp := range dps {
+		summary.ElapsedSecs += dp.ElapsedSecs
+		summary.TotalSizeBytes += dp.TotalSizeBytes
+		summary.WriteSizeBytes += dp.WriteSizeBytes
+		summary.RequestCount += dp.RequestCount
+		summary.Requests += dp.Requests
+		summary.Timeouts += dp.Timeouts
+		summary.Errors += dp.Errors
+		summary.RequestCostUSD += dp.RequestCostUSD
+		summary.TransferCostUSD += dp.TransferCostUSD
+		summary.EstimatedCostUSD += dp.EstimatedCostUSD
+		summary.Truncated = summary.Truncated || dp.Truncated
+	}
+	if summary.Requests > 0 {
+		summary.ErrorRate = float64(summary.Errors) / float64(summary.Requests)
+	}
+	summary.ThroughputMiBs = stats["ThroughputMiBs"].Mean
+	summary.RequestsPerSec = stats["RequestsPerSec"].Mean
+	summary.P50Latency = stats["P50Latency"].Mean
+	summary.P95Latency = stats["P95Latency"].Mean
+	summary.P99Latency = stats["P99Latency"].Mean
+	summary.FullP99Latency = stats["FullP99Latency"].Mean
+	if dps[0].Quantiles != nil {
+		summary.Quantiles = make(map[string]float64, len(dps[0].Quantiles))
+		for q := range dps[0].Quantiles {
+			summary.Quantiles[q] = stats["Quantiles/"+q].Mean
+		}
+	}
+	return summary
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// sweepPoint is what the sweep saw at one concurrency.
+type sweepPoint struct {
+	goroutines     int
+	throughputMiBs float64 // mean over the iterations run
+	p99Latency     float64 // worst over --count iterations
+}
+
+// runSweep runs downloads at a geometric series of goroutine counts and
+// recommends the smallest one past which throughput stops improving, or
+// the largest whose p99 latency stays within --max-p99.
+func runSweep(args []string) int {
+	fs := pflag.NewFlagSet("sweep", pflag.ExitOnError)
+	from := fs.Int("from", 1, "goroutines to start the sweep at")
+	to := fs.Int("to", 256, "goroutines to end the sweep at")
+	factor := fs.Float64("factor", 2, "multiply goroutines by this between steps")
+	minGain := fs.Float64("min-gain", 0.1, "stop once a step improves throughput by less than this fraction")
+	maxP99 := fs.Duration("max-p99", 0, "stop once p99 latency exceeds this (0 for no bound)")
+	cfg := parseDownloadFlagSet(fs, args)
+
+	if fs.Changed("goroutines") || cfg.Ramp != nil {
+		fatalf("sweep chooses the goroutines itself; don't give --goroutines, --ramp or --report-interval")
+	}
+	if cfg.MatrixSets != nil {
+		fatalf("sweep runs a single file set, not a list")
+	}
+	if cfg.DryRun {
+		fatalf("sweep doesn't support --dry-run")
+	}
+	if *from < 1 || *to < *from {
+		fatalf("need 1 <= from <= to")
+	}
+	if *factor <= 1 {
+		fatalf("factor must be greater than 1")
+	}
+	if cfg.Duration == 0 && *to > cfg.DownloadSizeBytes/requestSize(cfg) {
+		fatalf("to (%d) is greater than files to download (%d)", *to, cfg.DownloadSizeBytes/requestSize(cfg))
+	}
+	if cfg.MaxMemoryBytes > 0 {
+		if est := estimatePeakBufferBytes(cfg, *to); est > cfg.MaxMemoryBytes {
+			fatalf("estimated peak buffer usage at %d goroutines (%d MiB) exceeds max-memory (%d MiB)", *to, est/MiB, cfg.MaxMemoryBytes/MiB)
+		}
+	}
+
+	prepareRun(cfg)
+	rng := rand.New(rand.NewSource(cfg.Seed))
+
+	ctx := context.Background()
+	if cfg.MaxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.MaxDuration)
+		defer cancel()
+	}
+
+	var best sweepPoint
+	recommended := 0
+	reason := "reached --to without throughput levelling off"
+
+sweep:
+	for n := *from; n <= *to; n = nextSweepStep(n, *factor) {
+		stepCfg := *cfg
+		stepCfg.Goroutines = n
+		bc := newBenchClients(&stepCfg)
+
+		pt := sweepPoint{goroutines: n}
+		iterations := 0
+		for i := 0; i < cfg.Count; i++ {
+			if i > 0 && !cooldown(ctx, cfg) {
+				break
+			}
+			if cfg.FreshClient && i > 0 {
+				bc = newBenchClients(&stepCfg)
+			}
+			dp := run(ctx, &stepCfg, bc, rng)
+			iterations++
+			pt.throughputMiBs += dp.ThroughputMiBs
+			if dp.P99Latency > pt.p99Latency {
+				pt.p99Latency = dp.P99Latency
+			}
+		}
+		pt.throughputMiBs /= float64(iterations)
+		if ctx.Err() != nil {
+			warnf("max-duration of %v reached at %d goroutines", cfg.MaxDuration, n)
+			reason = "stopped by --max-duration"
+			break
+		}
+		debugf("sweep: %d goroutines, %.1f MiB/s, p99 %.3fs", n, pt.throughputMiBs, pt.p99Latency)
+
+		switch {
+		case *maxP99 > 0 && pt.p99Latency > maxP99.Seconds():
+			reason = fmt.Sprintf("p99 latency exceeded --max-p99 at %d goroutines", n)
+			break sweep
+		case best.goroutines > 0 && pt.throughputMiBs < best.throughputMiBs*(1+*minGain):
+			reason = fmt.Sprintf("throughput levelled off at %d goroutines", n)
+			break sweep
+		}
+		best = pt
+		recommended = n
+	}
+
+	if recommended == 0 {
+		warnf("no recommendation: %s", reason)
+		return 1
+	}
+	infof("recommended goroutines: %d (%.1f MiB/s, p99 %v); %s", recommended, best.throughputMiBs,
+		time.Duration(best.p99Latency*float64(time.Second)).Round(time.Millisecond), reason)
+	return 0
+}
+
+// nextSweepStep multiplies n by factor, always moving up by at least one.
+func nextSweepStep(n int, factor float64) int {
+	next := int(float64(n) * factor)
+	if next <= n {
+		next = n + 1
+	}
+	return next
+}
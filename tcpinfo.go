@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// tcpSample is what the kernel's TCP_INFO says about one connection.
+type tcpSample struct {
+	rttSecs      float64 // smoothed round trip time
+	totalRetrans uint32  // segments retransmitted over the connection's life
+	segsOut      uint32
+	deliveryRate uint64 // bytes per second, as recently measured by the kernel
+}
+
+// tcpConnStats samples TCP_INFO from the benchmark's connections, to tell
+// a lossy or slow network path apart from S3 being slow.  Connections are
+// sampled when a datapoint is taken and as they close.  It needs
+// readTCPInfo, which is Linux only.
+type tcpConnStats struct {
+	sync.Mutex
+	live     map[*tcpInfoConn]struct{}
+	sampled  int
+	retrans  uint64
+	segsOut  uint64
+	rtt      *latencyDigest
+	delivery []float64 // MiB/s
+}
+
+var tcpConns = &tcpConnStats{live: make(map[*tcpInfoConn]struct{}), rtt: newLatencyDigest()}
+
+// tcpInfoConn is a benchmark connection that's sampled on close.
+type tcpInfoConn struct {
+	*net.TCPConn
+	lastRetrans uint32
+	lastSegsOut uint32
+}
+
+// wrapDial wraps a transport's dial function so its TCP connections are
+// tracked, where TCP_INFO can be read.
+func (ts *tcpConnStats) wrapDial(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if !tcpInfoSupported {
+		return dial
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		tcp, ok := conn.(*net.TCPConn)
+		if err != nil || !ok {
+			return conn, err
+		}
+		c := &tcpInfoConn{TCPConn: tcp}
+		ts.Lock()
+		ts.live[c] = struct{}{}
+		ts.Unlock()
+		return c, nil
+	}
+}
+
+func (c *tcpInfoConn) Close() error {
+	tcpConns.Lock()
+	if _, ok := tcpConns.live[c]; ok {
+		tcpConns.sample(c)
+		delete(tcpConns.live, c)
+	}
+	tcpConns.Unlock()
+	return c.TCPConn.Close()
+}
+
+// sample adds what c has done since it was last sampled.  The caller holds
+// the lock.
+func (ts *tcpConnStats) sample(c *tcpInfoConn) {
+	s, ok := readTCPInfo(c.TCPConn)
+	if !ok {
+		return
+	}
+	ts.sampled++
+	ts.retrans += uint64(s.totalRetrans - c.lastRetrans)
+	ts.segsOut += uint64(s.segsOut - c.lastSegsOut)
+	c.lastRetrans, c.lastSegsOut = s.totalRetrans, s.segsOut
+	ts.rtt.Add(s.rttSecs)
+	if s.deliveryRate > 0 {
+		ts.delivery = append(ts.delivery, float64(s.deliveryRate)/MiB)
+	}
+}
+
+// setTCPInfo samples every open connection and reports all samples since
+// the last take, then starts afresh.
+func (ts *tcpConnStats) setTCPInfo(dp *Datapoint) {
+	ts.Lock()
+	defer ts.Unlock()
+	for c := range ts.live {
+		ts.sample(c)
+	}
+
+	dp.ConnsSampled = ts.sampled
+	dp.ConnRTTP50Latency = quantile(ts.rtt.TDigest, 0.50)
+	dp.ConnRTTP99Latency = quantile(ts.rtt.TDigest, 0.99)
+	dp.ConnRetransSegs = int(ts.retrans)
+	if ts.segsOut > 0 {
+		dp.ConnRetransRate = float64(ts.retrans) / float64(ts.segsOut)
+	}
+	for _, v := range ts.delivery {
+		dp.ConnDeliveryMeanMiBs += v / float64(len(ts.delivery))
+		if v > dp.ConnDeliveryMaxMiBs {
+			dp.ConnDeliveryMaxMiBs = v
+		}
+	}
+
+	ts.sampled, ts.retrans, ts.segsOut = 0, 0, 0
+	ts.rtt = newLatencyDigest()
+	ts.delivery = nil
+}
+
+// reset starts afresh from the connections' current counters, without
+// reporting them.
+func (ts *tcpConnStats) reset() {
+	ts.setTCPInfo(&Datapoint{})
+}
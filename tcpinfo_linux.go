@@ -0,0 +1,53 @@
+//go:build linux && !386
+
+package main
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+const tcpInfoSupported = true
+
+// linuxTCPInfo is struct tcp_info from linux/tcp.h, up to delivery_rate
+// (Linux 4.9).  Older kernels fill in less of it.
+type linuxTCPInfo struct {
+	state, caState, retransmits, probes, backoff, options, wscale, flags uint8
+
+	rto, ato, sndMSS, rcvMSS                                     uint32
+	unacked, sacked, lost, retrans, fackets                      uint32
+	lastDataSent, lastAckSent, lastDataRecv, lastAckRecv         uint32
+	pmtu, rcvSsthresh, rtt, rttvar, sndSsthresh, sndCwnd, advMSS uint32
+	reordering, rcvRTT, rcvSpace, totalRetrans                   uint32
+
+	pacingRate, maxPacingRate, bytesAcked, bytesReceived uint64
+	segsOut, segsIn                                      uint32
+	notsentBytes, minRTT, dataSegsIn, dataSegsOut        uint32
+	deliveryRate                                         uint64
+}
+
+// readTCPInfo reads TCP_INFO for c.  Fields the kernel doesn't fill in are
+// left zero.
+func readTCPInfo(c *net.TCPConn) (tcpSample, bool) {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return tcpSample{}, false
+	}
+	var info linuxTCPInfo
+	size := uint32(unsafe.Sizeof(info))
+	var errno syscall.Errno
+	err = raw.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.IPPROTO_TCP, syscall.TCP_INFO,
+			uintptr(unsafe.Pointer(&info)), uintptr(unsafe.Pointer(&size)), 0)
+	})
+	if err != nil || errno != 0 {
+		return tcpSample{}, false
+	}
+	return tcpSample{
+		rttSecs:      float64(info.rtt) / 1e6, // microseconds
+		totalRetrans: info.totalRetrans,
+		segsOut:      info.segsOut,
+		deliveryRate: info.deliveryRate,
+	}, true
+}
@@ -0,0 +1,12 @@
+//go:build !linux || 386
+
+package main
+
+import "net"
+
+const tcpInfoSupported = false
+
+// readTCPInfo isn't supported here, so TCP_INFO isn't reported.
+func readTCPInfo(c *net.TCPConn) (tcpSample, bool) {
+	return tcpSample{}, false
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// parseThinkTime parses --think-time, a duration like "50ms" for a fixed
+// pause or "exp:50ms" for exponentially distributed pauses with that mean.
+func parseThinkTime(spec string) (time.Duration, bool, error) {
+	exp := false
+	if name, arg, ok := strings.Cut(spec, ":"); ok {
+		if name != "exp" {
+			return 0, false, fmt.Errorf("unknown think time distribution '%s'", name)
+		}
+		exp = true
+		spec = arg
+	}
+	d, err := time.ParseDuration(spec)
+	if err != nil || d < 0 {
+		return 0, false, fmt.Errorf("think time must be a non-negative duration, e.g. 50ms or exp:50ms")
+	}
+	return d, exp, nil
+}
+
+// think pauses a worker between requests for --think-time, drawing the
+// pause from rng when it's exponential.  It returns early if ctx ends.
+func (rs *runState) think(ctx context.Context, rng *rand.Rand) {
+	d := rs.cfg.ThinkTime
+	if rs.cfg.ThinkTimeExp {
+		d = time.Duration(rng.ExpFloat64() * float64(d))
+	}
+	if d <= 0 {
+		return
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/aws/smithy-go/middleware"
+)
+
+// connTracker aggregates httptrace events across all requests in a run so we
+// can see how much time goes into setting up connections, and how much into
+// waiting on S3.
+type connTracker struct {
+	sync.Mutex
+	conns         int
+	connectSecs   float64
+	handshakes    int
+	handshakeSecs float64
+	requests      int
+	reused        int                 // requests on a connection that had served one before
+	seenConns     map[string]struct{} // connections used, by connKey
+	phases        connPhases
+}
+
+// connPhases are digests of each phase of a request.  New connections add to
+// the first three; reused ones skip them.
+type connPhases struct {
+	dns     *latencyDigest
+	connect *latencyDigest
+	tls     *latencyDigest
+	server  *latencyDigest // request written to first response byte
+	ttfb    *latencyDigest // request start to first response byte
+
+	// ttfb split by whether the first attempt reused a connection
+	reusedTTFB *latencyDigest
+	newTTFB    *latencyDigest
+}
+
+func newConnPhases() connPhases {
+	return connPhases{
+		dns:     newLatencyDigest(),
+		connect: newLatencyDigest(),
+		tls:     newLatencyDigest(),
+		server:  newLatencyDigest(),
+		ttfb:    newLatencyDigest(),
+
+		reusedTTFB: newLatencyDigest(),
+		newTTFB:    newLatencyDigest(),
+	}
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{seenConns: make(map[string]struct{}), phases: newConnPhases()}
+}
+
+// connKey identifies a connection by its addresses, so distinct connections
+// are counted without holding on to them.  Connections opened before the
+// tracker started, as by --warmup or an earlier ramp step, count when they're
+// first used here.
+func connKey(c net.Conn) string {
+	return c.LocalAddr().String() + " " + c.RemoteAddr().String()
+}
+
+// clientTrace returns a trace for a single request, starting now.  A dial
+// may race several addresses, so connect start times are tracked by address.
+// With SDK retries, time to first byte is to the first attempt's response.
+func (ct *connTracker) clientTrace() *httptrace.ClientTrace {
+	reqStart := time.Now()
+	connectStart := make(map[string]time.Time)
+	var dnsStart, tlsStart, wrote time.Time
+	var gotConn, reused, gotFirstByte bool
+
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			ct.Lock()
+			defer ct.Unlock()
+			dnsStart = time.Now()
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			ct.Lock()
+			defer ct.Unlock()
+			if info.Err == nil {
+				ct.phases.dns.Add(time.Since(dnsStart).Seconds())
+			}
+		},
+		ConnectStart: func(network, addr string) {
+			ct.Lock()
+			defer ct.Unlock()
+			connectStart[network+addr] = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			ct.Lock()
+			defer ct.Unlock()
+			start, ok := connectStart[network+addr]
+			if !ok || err != nil {
+				return
+			}
+			secs := time.Since(start).Seconds()
+			ct.conns++
+			ct.connectSecs += secs
+			ct.phases.connect.Add(secs)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			ct.Lock()
+			defer ct.Unlock()
+			ct.requests++
+			if info.Reused {
+				ct.reused++
+			}
+			ct.seenConns[connKey(info.Conn)] = struct{}{}
+			if !gotConn {
+				gotConn = true
+				reused = info.Reused
+			}
+		},
+		TLSHandshakeStart: func() {
+			ct.Lock()
+			defer ct.Unlock()
+			tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			ct.Lock()
+			defer ct.Unlock()
+			if err != nil {
+				return
+			}
+			secs := time.Since(tlsStart).Seconds()
+			ct.handshakes++
+			ct.handshakeSecs += secs
+			ct.phases.tls.Add(secs)
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			ct.Lock()
+			defer ct.Unlock()
+			wrote = time.Now()
+		},
+		GotFirstResponseByte: func() {
+			ct.Lock()
+			defer ct.Unlock()
+			if !wrote.IsZero() {
+				ct.phases.server.Add(time.Since(wrote).Seconds())
+			}
+			if !gotFirstByte {
+				gotFirstByte = true
+				secs := time.Since(reqStart).Seconds()
+				ct.phases.ttfb.Add(secs)
+				if reused {
+					ct.phases.reusedTTFB.Add(secs)
+				} else {
+					ct.phases.newTTFB.Add(secs)
+				}
+			}
+		},
+	}
+}
+
+// connTrackerKey is the context key withConnTracker keeps a tracker under.
+type connTrackerKey struct{}
+
+// withConnTracker returns ctx carrying ct, so that every S3 call made with it
+// is traced into ct, each with a clientTrace of its own.  Commands making
+// several calls, at once or in turn, use this rather than sharing one trace.
+func withConnTracker(ctx context.Context, ct *connTracker) context.Context {
+	return context.WithValue(ctx, connTrackerKey{}, ct)
+}
+
+// addRequestTraces is an S3 API option that starts a clientTrace for each
+// call whose context carries a connTracker.  Initialize runs once per call,
+// outside the retry loop, as the trace expects.
+func addRequestTraces(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("TraceRequest",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			if ct, ok := ctx.Value(connTrackerKey{}).(*connTracker); ok {
+				ctx = httptrace.WithClientTrace(ctx, ct.clientTrace())
+			}
+			return next.HandleInitialize(ctx, in)
+		}), middleware.Before)
+}
+
+// reset clears everything tracked so far, for stats over a fresh interval.
+func (ct *connTracker) reset() {
+	ct.Lock()
+	defer ct.Unlock()
+	ct.conns = 0
+	ct.connectSecs = 0
+	ct.handshakes = 0
+	ct.handshakeSecs = 0
+	ct.requests = 0
+	ct.reused = 0
+	ct.seenConns = make(map[string]struct{})
+	ct.phases = newConnPhases()
+}
+
+// setPhases reads quantiles of each request phase into dp.
+func (ct *connTracker) setPhases(dp *Datapoint) {
+	ct.Lock()
+	defer ct.Unlock()
+	dp.DNSP50Latency = quantile(ct.phases.dns.TDigest, 0.50)
+	dp.DNSP99Latency = quantile(ct.phases.dns.TDigest, 0.99)
+	dp.ConnectP50Latency = quantile(ct.phases.connect.TDigest, 0.50)
+	dp.ConnectP99Latency = quantile(ct.phases.connect.TDigest, 0.99)
+	dp.TLSP50Latency = quantile(ct.phases.tls.TDigest, 0.50)
+	dp.TLSP99Latency = quantile(ct.phases.tls.TDigest, 0.99)
+	dp.ServerP50Latency = quantile(ct.phases.server.TDigest, 0.50)
+	dp.ServerP99Latency = quantile(ct.phases.server.TDigest, 0.99)
+	dp.TTFBP50Latency = quantile(ct.phases.ttfb.TDigest, 0.50)
+	dp.TTFBP99Latency = quantile(ct.phases.ttfb.TDigest, 0.99)
+}
+
+// setReuse reads connection reuse, and its effect on time to first byte,
+// into dp.
+func (ct *connTracker) setReuse(dp *Datapoint) {
+	ct.Lock()
+	defer ct.Unlock()
+	dp.ReusedConnRequests = ct.reused
+	dp.NewConnRequests = ct.requests - ct.reused
+	if ct.requests > 0 {
+		dp.ConnReuseRate = float64(ct.reused) / float64(ct.requests)
+	}
+	dp.ReusedConnP50TTFB = quantile(ct.phases.reusedTTFB.TDigest, 0.50)
+	dp.NewConnP50TTFB = quantile(ct.phases.newTTFB.TDigest, 0.50)
+	if ct.phases.reusedTTFB.Count() > 0 && ct.phases.newTTFB.Count() > 0 {
+		dp.NewConnP50Penalty = dp.NewConnP50TTFB - dp.ReusedConnP50TTFB
+	}
+}
+
+// ConnsEstablished returns the number of successful TCP connects.
+func (ct *connTracker) ConnsEstablished() int {
+	ct.Lock()
+	defer ct.Unlock()
+	return ct.conns
+}
+
+// MeanConnectSecs returns the mean TCP connect time.
+func (ct *connTracker) MeanConnectSecs() float64 {
+	ct.Lock()
+	defer ct.Unlock()
+	if ct.conns == 0 {
+		return 0
+	}
+	return ct.connectSecs / float64(ct.conns)
+}
+
+// MeanHandshakeSecs returns the mean TLS handshake time.
+func (ct *connTracker) MeanHandshakeSecs() float64 {
+	ct.Lock()
+	defer ct.Unlock()
+	if ct.handshakes == 0 {
+		return 0
+	}
+	return ct.handshakeSecs / float64(ct.handshakes)
+}
+
+// ObjectsPerConn returns the mean number of requests served by each distinct
+// connection used.
+func (ct *connTracker) ObjectsPerConn() float64 {
+	ct.Lock()
+	defer ct.Unlock()
+	if len(ct.seenConns) == 0 {
+		return 0
+	}
+	return float64(ct.requests) / float64(len(ct.seenConns))
+}
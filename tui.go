@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tuiWindowSecs is how many seconds of latencies the rolling quantiles
+// cover.
+const tuiWindowSecs = 10
+
+// tuiSecondSamples bounds the latencies kept for each second, sampled
+// evenly beyond that, so a fast run doesn't make quantiles slow.
+const tuiSecondSamples = 4096
+
+// tuiBarWidth is the width of the progress bar, in characters.
+const tuiBarWidth = 30
+
+// latencySecond is the latencies of one second, or a sample of them.
+type latencySecond struct {
+	samples []float64
+	seen    int
+}
+
+// rollingLatency keeps the latencies of the last tuiWindowSecs seconds, for
+// rolling quantiles.  Its owner calls window once a second.
+type rollingLatency struct {
+	sync.Mutex
+	seconds [tuiWindowSecs]latencySecond
+	cur     int
+	rng     *rand.Rand
+}
+
+func newRollingLatency(seed int64) *rollingLatency {
+	return &rollingLatency{rng: rand.New(rand.NewSource(seed))}
+}
+
+// Add records a request's latency.
+func (rl *rollingLatency) Add(secs float64) {
+	rl.Lock()
+	defer rl.Unlock()
+	s := &rl.seconds[rl.cur]
+	s.seen++
+	if len(s.samples) < tuiSecondSamples {
+		s.samples = append(s.samples, secs)
+	} else if i := rl.rng.Intn(s.seen); i < tuiSecondSamples {
+		s.samples[i] = secs
+	}
+}
+
+// window returns the rolling latencies, sorted, and starts a new second,
+// dropping the oldest.
+func (rl *rollingLatency) window() []float64 {
+	rl.Lock()
+	defer rl.Unlock()
+	var all []float64
+	for _, s := range rl.seconds {
+		all = append(all, s.samples...)
+	}
+	rl.cur = (rl.cur + 1) % tuiWindowSecs
+	rl.seconds[rl.cur] = latencySecond{samples: rl.seconds[rl.cur].samples[:0]}
+	sort.Float64s(all)
+	return all
+}
+
+// tuiDisplay redraws a panel of a run's progress on stderr every second:
+// how far through it is, MiB/s over the last second, rolling p50 and p99,
+// requests in flight and errors.  stdout is left alone for results.
+type tuiDisplay struct {
+	rs    *runState
+	w     io.Writer
+	ansi  bool // redraw in place; else print a line per second
+	start time.Time
+	last  int64
+	lat   *rollingLatency
+	lines int // drawn last time, to move back over
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+func startTUI(rs *runState) *tuiDisplay {
+	td := &tuiDisplay{
+		rs:    rs,
+		w:     os.Stderr,
+		ansi:  isTerminal(os.Stderr),
+		start: time.Now(),
+		last:  atomic.LoadInt64(&rs.streamed),
+		lat:   newRollingLatency(rs.cfg.Seed),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go func() {
+		defer close(td.done)
+		tick := time.NewTicker(time.Second)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				td.draw()
+			case <-td.stop:
+				td.draw()
+				return
+			}
+		}
+	}()
+	return td
+}
+
+// Stop draws the panel a last time and leaves it on screen.
+func (td *tuiDisplay) Stop() {
+	close(td.stop)
+	<-td.done
+}
+
+// isTerminal reports whether f is a terminal rather than a file or pipe.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Add records a request's latency for the rolling quantiles.
+func (td *tuiDisplay) Add(secs float64) {
+	td.lat.Add(secs)
+}
+
+func (td *tuiDisplay) draw() {
+	cfg := td.rs.cfg
+	elapsed := time.Since(td.start)
+	streamed := atomic.LoadInt64(&td.rs.streamed)
+	mibs := float64(streamed-td.last) / MiB
+	td.last = streamed
+
+	// Progress is by bytes for a fixed download size and by time for
+	// --duration.
+	progress := -1.0
+	switch {
+	case cfg.Duration > 0:
+		progress = elapsed.Seconds() / cfg.Duration.Seconds()
+	case cfg.DownloadSizeBytes > 0:
+		progress = float64(streamed) / float64(cfg.DownloadSizeBytes)
+	}
+	if progress > 1 {
+		progress = 1
+	}
+
+	var lines []string
+	if progress >= 0 {
+		filled := int(progress * tuiBarWidth)
+		lines = append(lines, fmt.Sprintf("[%s%s] %3.0f%%  %s elapsed",
+			strings.Repeat("#", filled), strings.Repeat(".", tuiBarWidth-filled), progress*100, elapsed.Round(time.Second)))
+	} else {
+		lines = append(lines, fmt.Sprintf("%s elapsed", elapsed.Round(time.Second)))
+	}
+
+	lat := td.lat.window()
+	p50, p99 := "-", "-"
+	if len(lat) > 0 {
+		p50 = fmt.Sprintf("%.1f ms", lat[len(lat)/2]*1000)
+		p99 = fmt.Sprintf("%.1f ms", lat[(len(lat)*99)/100]*1000)
+	}
+	lines = append(lines,
+		fmt.Sprintf("%8.1f MiB/s   p50 %s   p99 %s (last %ds)", mibs, p50, p99, tuiWindowSecs),
+		fmt.Sprintf("%8d in flight   %d requests   %d errors   %d timeouts",
+			expInFlight.Value(), atomic.LoadInt64(&td.rs.requests), td.rs.errors.total(), atomic.LoadInt64(&td.rs.timeouts)))
+
+	if !td.ansi {
+		fmt.Fprintln(td.w, strings.Join(lines, " | "))
+		return
+	}
+	var b strings.Builder
+	if td.lines > 0 {
+		fmt.Fprintf(&b, "\033[%dA", td.lines)
+	}
+	for _, l := range lines {
+		fmt.Fprintf(&b, "\r\033[K%s\n", l)
+	}
+	td.lines = len(lines)
+	io.WriteString(td.w, b.String())
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"net/http/httptrace"
+	"path"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/spf13/pflag"
+)
+
+func parseUploadFlags(args []string) *myConfig {
+	cfg := &myConfig{}
+	fs := pflag.NewFlagSet("upload", pflag.ExitOnError)
+	addS3Flags(fs, cfg)
+	fs.IntVar(&cfg.Count, "count", 1, "number of datapoints to generate")
+	fs.StringVar(&cfg.EC2Instance, "instance", "", "EC2 instance type (default: detected from instance metadata)")
+	fs.IntVar(&cfg.Goroutines, "goroutines", runtime.NumCPU(), "parallel uploads; each holds one object's worth of random data")
+	fs.StringVar(&cfg.FileSetName, "set", "M001", "file set whose object size to upload")
+	uploadSize := fs.Uint("upload", 256, "total size to upload in MiB")
+	fs.StringVar(&cfg.ScratchPrefix, "scratch-prefix", "s3skunk-scratch", "prefix to upload under; each iteration uses a fresh sub-prefix")
+	fs.BoolVar(&cfg.KeepScratch, "keep", false, "don't delete uploaded objects after each iteration")
+	addResultFlags(fs, cfg)
+	parseFlags(fs, args)
+
+	validateS3Flags(cfg)
+	validateResultFlags(cfg)
+
+	fileSet, ok := fileSets[cfg.FileSetName]
+	if !ok {
+		fatalf("unknown file set '%s'", cfg.FileSetName)
+	}
+
+	cfg.UploadSizeBytes = int(*uploadSize) * MiB
+	if cfg.UploadSizeBytes%fileSet.Size != 0 {
+		fatalf("upload (%d MiB) must be a multiple of the file set size (%d)", *uploadSize, fileSet.Size)
+	}
+
+	if cfg.Goroutines < 1 || cfg.Goroutines > cfg.UploadSizeBytes/fileSet.Size {
+		fatalf("goroutines (%d) must be between 1 and the files to upload (%d)", cfg.Goroutines, cfg.UploadSizeBytes/fileSet.Size)
+	}
+
+	// Every iteration gets its own client.
+	cfg.FreshClient = true
+
+	// Keep the scratch area well away from the file sets themselves.
+	if path.Clean(cfg.ScratchPrefix) == path.Clean(cfg.Prefix) {
+		fatalf("scratch-prefix must differ from prefix")
+	}
+
+	return cfg
+}
+
+// upload runs one upload iteration: every worker PUTs copies of its own
+// random buffer until the total size has been written.
+func upload(cfg *myConfig, s3Client *s3.Client) {
+	size := fileSets[cfg.FileSetName].Size
+	numFiles := cfg.UploadSizeBytes / size
+
+	// A sub-prefix per iteration, so runs can't overwrite each other and
+	// cleanup can't touch anything else.
+	runPrefix := path.Join(cfg.ScratchPrefix, strconv.FormatInt(time.Now().UnixNano(), 10))
+
+	// Fill buffers up front so generating data isn't part of the timing.
+	bufs := make([][]byte, cfg.Goroutines)
+	for i := range bufs {
+		rng := rand.New(rand.NewSource(cfg.Seed + int64(i)))
+		bufs[i] = make([]byte, size)
+		rng.Read(bufs[i])
+	}
+	debugf("uploading %d files to s3://%s/%s with %d goroutines", numFiles, cfg.Bucket, runPrefix, cfg.Goroutines)
+
+	work := make(chan int, cfg.Goroutines)
+	go func() {
+		for i := 0; i < numFiles; i++ {
+			work <- i
+		}
+		close(work)
+	}()
+
+	latency := make(chan float64, cfg.Goroutines)
+	latencyDone := make(chan struct{})
+	td := newLatencyDigest()
+	go func() {
+		for v := range latency {
+			td.Add(v)
+		}
+		close(latencyDone)
+	}()
+
+	tracker := newConnTracker()
+	startTime := time.Now()
+
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Goroutines; i++ {
+		wg.Add(1)
+		go func(buf []byte) {
+			defer wg.Done()
+			for n := range work {
+				key := seedKey(runPrefix, cfg.FileSetName, n)
+				ctx := httptrace.WithClientTrace(context.Background(), tracker.clientTrace())
+				start := time.Now()
+				_, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+					Bucket:        aws.String(cfg.Bucket),
+					Key:           aws.String(key),
+					Body:          bytes.NewReader(buf),
+					ContentLength: int64(len(buf)),
+				})
+				expRequests.Add(1)
+				if err != nil {
+					expErrors.Add(1)
+					fatalf("error uploading %s: %v", key, err)
+				}
+				latency <- time.Since(start).Seconds()
+				expBytesWritten.Add(int64(len(buf)))
+			}
+		}(bufs[i])
+	}
+
+	wg.Wait()
+	elapsedSec := time.Since(startTime).Seconds()
+
+	close(latency)
+	<-latencyDone
+
+	datapoint := baseDatapoint(cfg)
+	datapoint.Operation = "upload"
+	datapoint.TotalSizeBytes = cfg.UploadSizeBytes
+
+	datapoint.StartTime = startTime
+	datapoint.ElapsedSecs = elapsedSec
+	datapoint.setLatencies(td, cfg.Quantiles)
+	datapoint.ThroughputMiBs = float64(cfg.UploadSizeBytes) / MiB / elapsedSec
+	datapoint.setConnStats(tracker, elapsedSec)
+
+	emitDatapoint(cfg, datapoint)
+
+	if cfg.KeepScratch {
+		return
+	}
+	n, err := deletePrefix(s3Client, cfg.Bucket, runPrefix+"/")
+	if err != nil {
+		fatalf("error cleaning up s3://%s/%s: %v", cfg.Bucket, runPrefix, err)
+	}
+	debugf("deleted %d uploaded objects", n)
+}
+
+// runUpload is the upload benchmark, the mirror image of download: it PUTs
+// objects of a file set's size with a pool of goroutines and emits a
+// datapoint for each of --count iterations.
+func runUpload(args []string) int {
+	cfg := parseUploadFlags(args)
+	prepareRun(cfg)
+
+	for i := 0; i < cfg.Count; i++ {
+		s3Client, err := configS3(cfg)
+		if err != nil {
+			fatalf("error configuring S3: %v", err)
+		}
+		upload(cfg, s3Client)
+	}
+
+	return 0
+}
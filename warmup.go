@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+)
+
+// warmup downloads with the workload of cfg, but records nothing, so the
+// measured run starts on connections that are already set up and past TCP
+// slow start.  It runs for --warmup, or for --warmup-requests requests.
+func warmup(ctx context.Context, cfg *myConfig, bc *benchClients) {
+	warm := *cfg
+	warm.Warmup, warm.WarmupRequests = 0, 0
+	warm.Ramp = nil
+	warm.WriteRatio = 0
+	warm.RawOutput = ""
+	warm.StoreRequests = false
+	if cfg.Warmup > 0 {
+		warm.Duration = cfg.Warmup
+		debugf("warming up for %v", cfg.Warmup)
+	} else {
+		warm.Duration = 0
+		warm.DownloadSizeBytes = cfg.WarmupRequests * requestSize(cfg)
+		debugf("warming up with %d requests", cfg.WarmupRequests)
+	}
+
+	// Its own PRNG, so the measured run is the same with or without it.
+	measure(ctx, &warm, bc, rand.New(rand.NewSource(cfg.Seed)))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// latencyWindow is the latency of requests completing in one window of a
+// run, with --latency-window.
+type latencyWindow struct {
+	StartSecs  float64 // from the start of the run or step
+	Requests   int
+	P50Latency float64
+	P99Latency float64
+}
+
+// windowedLatency keeps a digest for each fixed-width window of a run, so
+// tail latency can be followed as the run goes on.
+type windowedLatency struct {
+	sync.Mutex
+	start   time.Time
+	width   time.Duration
+	digests []*latencyDigest
+}
+
+func newWindowedLatency(width time.Duration) *windowedLatency {
+	return &windowedLatency{start: time.Now(), width: width}
+}
+
+// Add records a latency in the window in which it completed.
+func (wl *windowedLatency) Add(secs float64) {
+	wl.Lock()
+	defer wl.Unlock()
+	i := int(time.Since(wl.start) / wl.width)
+	for len(wl.digests) <= i {
+		wl.digests = append(wl.digests, newLatencyDigest())
+	}
+	wl.digests[i].Add(secs)
+}
+
+// take summarizes the windows so far and starts afresh from now.
+func (wl *windowedLatency) take() []latencyWindow {
+	wl.Lock()
+	digests := wl.digests
+	wl.digests = nil
+	wl.start = time.Now()
+	wl.Unlock()
+
+	windows := make([]latencyWindow, len(digests))
+	for i, td := range digests {
+		windows[i] = latencyWindow{
+			StartSecs:  (time.Duration(i) * wl.width).Seconds(),
+			Requests:   td.count,
+			P50Latency: quantile(td.TDigest, 0.50),
+			P99Latency: quantile(td.TDigest, 0.99),
+		}
+	}
+	return windows
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"sync"
+)
+
+// workerStats is what one download goroutine has done since its stats were
+// last taken.
+type workerStats struct {
+	sync.Mutex
+	requests int64
+	bytes    int64
+	td       *latencyDigest
+}
+
+func newWorkerStats() *workerStats {
+	return &workerStats{td: newLatencyDigest()}
+}
+
+// Add records a completed read and the body bytes it returned.
+func (ws *workerStats) Add(secs float64, n int64) {
+	ws.Lock()
+	defer ws.Unlock()
+	ws.requests++
+	ws.bytes += n
+	ws.td.Add(secs)
+}
+
+// workerSummary is one goroutine's share of a run, as included in JSON
+// results with --per-worker.
+type workerSummary struct {
+	Worker         int
+	Requests       int64
+	Bytes          int64
+	ThroughputMiBs float64
+	P50Latency     float64 // to response headers
+	P99Latency     float64
+}
+
+// take summarizes the worker over elapsedSec and starts afresh.
+func (ws *workerStats) take(worker int, elapsedSec float64) workerSummary {
+	ws.Lock()
+	defer ws.Unlock()
+	s := workerSummary{
+		Worker:         worker,
+		Requests:       ws.requests,
+		Bytes:          ws.bytes,
+		ThroughputMiBs: float64(ws.bytes) / MiB / elapsedSec,
+		P50Latency:     quantile(ws.td.TDigest, 0.5),
+		P99Latency:     quantile(ws.td.TDigest, 0.99),
+	}
+	ws.requests, ws.bytes, ws.td = 0, 0, newLatencyDigest()
+	return s
+}
+
+// setWorkerStats reports the spread between the fastest and slowest workers
+// that have started, and with --per-worker includes each one.  A big spread
+// points at scheduling or connection affinity rather than S3.
+func (dp *Datapoint) setWorkerStats(workers []*workerStats, elapsedSec float64, keep bool) {
+	var summaries []workerSummary
+	for i, ws := range workers {
+		if ws == nil {
+			continue
+		}
+		s := ws.take(i, elapsedSec)
+		if len(summaries) == 0 {
+			dp.WorkerMinRequests, dp.WorkerMaxRequests = s.Requests, s.Requests
+			dp.WorkerMinMiBs, dp.WorkerMaxMiBs = s.ThroughputMiBs, s.ThroughputMiBs
+			dp.WorkerMinP50Latency, dp.WorkerMaxP50Latency = s.P50Latency, s.P50Latency
+		}
+		if s.Requests < dp.WorkerMinRequests {
+			dp.WorkerMinRequests = s.Requests
+		}
+		if s.Requests > dp.WorkerMaxRequests {
+			dp.WorkerMaxRequests = s.Requests
+		}
+		if s.ThroughputMiBs < dp.WorkerMinMiBs {
+			dp.WorkerMinMiBs = s.ThroughputMiBs
+		}
+		if s.ThroughputMiBs > dp.WorkerMaxMiBs {
+			dp.WorkerMaxMiBs = s.ThroughputMiBs
+		}
+		if s.P50Latency < dp.WorkerMinP50Latency {
+			dp.WorkerMinP50Latency = s.P50Latency
+		}
+		if s.P50Latency > dp.WorkerMaxP50Latency {
+			dp.WorkerMaxP50Latency = s.P50Latency
+		}
+		summaries = append(summaries, s)
+	}
+	if keep {
+		dp.Workers = summaries
+	}
+}